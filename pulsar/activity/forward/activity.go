@@ -0,0 +1,160 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/activity"
+	"github.com/project-flogo/core/data"
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/trace"
+)
+
+func init() {
+	_ = activity.Register(&Activity{}, New)
+}
+
+var activityMd = activity.ToMetadata(&Settings{}, &Input{}, &Output{})
+
+// New creates the forward activity
+func New(ctx activity.InitContext) (activity.Activity, error) {
+	s := &Settings{}
+	err := metadata.MapToStruct(ctx.Settings(), s, true)
+	if err != nil {
+		return nil, err
+	}
+
+	pulsarConn, err := coerce.ToConnection(s.Connection)
+	if err != nil {
+		return nil, err
+	}
+	connMgr := pulsarConn.GetConnection().(connection.PulsarConnManager)
+	topic, err := connMgr.NormalizeTopic(s.Topic)
+	if err != nil {
+		return nil, err
+	}
+	producerOptions := pulsar.ProducerOptions{
+		Topic: topic,
+	}
+	switch s.CompressionType {
+	case "LZ4":
+		producerOptions.CompressionType = pulsar.LZ4
+	case "ZLIB":
+		producerOptions.CompressionType = pulsar.ZLib
+	case "ZSTD":
+		producerOptions.CompressionType = pulsar.ZSTD
+	default:
+		producerOptions.CompressionType = pulsar.NoCompression
+	}
+
+	connMgr.Labels.RegisterMetric("pulsar_publish_labels", "topic", topic)
+	return &Activity{
+		producerOpts: producerOptions,
+		connMgr:      connMgr,
+	}, nil
+}
+
+// Activity publishes a consumed message to a destination topic and acknowledges the source message
+// once the broker confirmed the publish, which gives at-least-once delivery from topic to topic
+type Activity struct {
+	producerOpts pulsar.ProducerOptions
+	connMgr      connection.PulsarConnManager
+	lock         sync.Mutex
+	producer     pulsar.Producer
+}
+
+// Metadata returns the activity's metadata
+func (a *Activity) Metadata() *activity.Metadata {
+	return activityMd
+}
+
+// Eval publishes the payload to the destination topic, then acknowledges the source message
+func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
+	input := &Input{}
+	err = ctx.GetInputObject(input)
+	if err != nil {
+		return true, err
+	}
+	if input.Msgid == "" {
+		return true, fmt.Errorf("msgid of the source message is required")
+	}
+
+	producer, err := a.getProducer()
+	if err != nil {
+		return true, err
+	}
+
+	msg := pulsar.ProducerMessage{Properties: input.Properties}
+	if input.Payload != nil {
+		payload, err := coerce.ToType(input.Payload, data.TypeBytes)
+		if err != nil {
+			return true, err
+		}
+		msg.Payload = payload.([]byte)
+	}
+	if key, _ := coerce.ToString(input.Key); key != "" {
+		msg.Key = key
+	}
+	if msg.Properties == nil {
+		msg.Properties = make(map[string]string)
+	}
+	if propagation := a.connMgr.Propagation; propagation != nil {
+		propagation.Inject(propagation.Collect(nil, ctx.ActivityHost().Scope(), input.Context), msg.Properties)
+	}
+	if trace.Enabled() {
+		a.connMgr.Labels.TagSpan(ctx.GetTracingContext())
+		_ = trace.GetTracer().Inject(ctx.GetTracingContext(), trace.TextMap, msg.Properties)
+	}
+
+	// the source message must only be acknowledged once the destination has it
+	size := int64(len(msg.Payload))
+	a.connMgr.Memory.Acquire(size)
+	msgID, err := producer.Send(context.Background(), &msg)
+	a.connMgr.Memory.Release(size)
+	if err != nil {
+		return true, fmt.Errorf("Forward could not send message to topic [%s]: %v", a.producerOpts.Topic, err)
+	}
+	ctx.SetOutput("msgid", fmt.Sprintf("%x", msgID.Serialize()))
+
+	if err = connection.AckMessage(input.Msgid); err != nil {
+		// the flow completed already, its outcome decided about the acknowledgment
+		a.connMgr.Labels.Logger(ctx.Logger()).Warnf("Source message not acknowledged: %v", err)
+		return true, nil
+	}
+	a.connMgr.Labels.Logger(ctx.Logger()).Debugf("Message [%s] forwarded to topic [%s] and acknowledged", input.Msgid, a.producerOpts.Topic)
+	return true, nil
+}
+
+func (a *Activity) getProducer() (pulsar.Producer, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.producer != nil && a.connMgr.Refreshed(a.producer) {
+		// re-create the producer with the credentials of the refreshed connection
+		a.connMgr.CloseProducer(a.producer)
+		a.producer = nil
+	}
+	if a.producer != nil {
+		return a.producer, nil
+	}
+	producer, err := a.connMgr.GetProducer(a.producerOpts)
+	if err != nil {
+		return nil, err
+	}
+	a.producer = producer
+	return producer, nil
+}
+
+// Cleanup closes the producer
+func (a *Activity) Cleanup() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.producer != nil {
+		a.connMgr.CloseProducer(a.producer)
+		a.producer = nil
+	}
+	return nil
+}
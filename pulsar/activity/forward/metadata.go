@@ -0,0 +1,69 @@
+package forward
+
+import (
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/support/connection"
+)
+
+type Settings struct {
+	Connection      connection.Manager `md:"connection"`
+	Topic           string             `md:"topic,required"`
+	CompressionType string             `md:"compressionType"`
+}
+
+type Input struct {
+	Msgid      string            `md:"msgid,required"`
+	Key        interface{}       `md:"key"`
+	Properties map[string]string `md:"properties"`
+	Payload    interface{}       `md:"payload"`
+	Context    map[string]string `md:"context"`
+}
+
+func (r *Input) FromMap(values map[string]interface{}) (err error) {
+	r.Msgid, err = coerce.ToString(values["msgid"])
+	if err != nil {
+		return
+	}
+	r.Key, err = coerce.ToString(values["key"])
+	if err != nil {
+		return
+	}
+	r.Properties, err = coerce.ToParams(values["properties"])
+	if err != nil {
+		return
+	}
+	r.Payload, err = coerce.ToAny(values["payload"])
+	if err != nil {
+		return
+	}
+	r.Context, err = coerce.ToParams(values["context"])
+	return
+}
+
+func (r *Input) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"msgid":      r.Msgid,
+		"payload":    r.Payload,
+		"key":        r.Key,
+		"properties": r.Properties,
+		"context":    r.Context,
+	}
+}
+
+// Output of the forward activity
+type Output struct {
+	Msgid string `md:"msgid"`
+}
+
+// FromMap frommap
+func (o *Output) FromMap(values map[string]interface{}) (err error) {
+	o.Msgid, err = coerce.ToString(values["msgid"])
+	return
+}
+
+// ToMap tomap
+func (o *Output) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"msgid": o.Msgid,
+	}
+}
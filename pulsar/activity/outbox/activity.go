@@ -0,0 +1,74 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/activity"
+	"github.com/project-flogo/core/data"
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/data/metadata"
+)
+
+func init() {
+	_ = activity.Register(&Activity{}, New)
+}
+
+var activityMd = activity.ToMetadata(&Settings{}, &Input{})
+
+// New creates the outbox activity
+func New(ctx activity.InitContext) (activity.Activity, error) {
+	s := &Settings{}
+	err := metadata.MapToStruct(ctx.Settings(), s, true)
+	if err != nil {
+		return nil, err
+	}
+	store, err := GetStore(s.Store)
+	if err != nil {
+		return nil, err
+	}
+	// validated when staged, the relay qualifies it with the settings of its connection
+	if _, err = connection.NormalizeTopic(s.Topic, false); err != nil {
+		return nil, err
+	}
+	return &Activity{store: store, topic: s.Topic}, nil
+}
+
+// Activity stages messages in the outbox store, they are published by the outbox relay activity once the
+// transaction they were staged in is committed
+type Activity struct {
+	store Store
+	topic string
+}
+
+// Metadata returns the activity's metadata
+func (a *Activity) Metadata() *activity.Metadata {
+	return activityMd
+}
+
+// Eval stages the message in the transaction of the input, or of the context of the flow
+func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
+	input := &Input{}
+	err = ctx.GetInputObject(input)
+	if err != nil {
+		return true, err
+	}
+	msg := &Message{Topic: a.topic, Properties: input.Properties, StagedAt: time.Now()}
+	if input.Payload != nil {
+		payload, err := coerce.ToType(input.Payload, data.TypeBytes)
+		if err != nil {
+			return true, err
+		}
+		msg.Payload = payload.([]byte)
+	}
+	if key, _ := coerce.ToString(input.Key); key != "" {
+		msg.Key = key
+	}
+	// the activity context of the flow does not expose a Go context, the transaction can only be given as input
+	if err = a.store.Stage(context.Background(), input.Transaction, msg); err != nil {
+		return true, err
+	}
+	ctx.Logger().Debugf("Message staged in the outbox for topic [%s]", a.topic)
+	return true, nil
+}
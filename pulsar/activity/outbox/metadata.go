@@ -0,0 +1,43 @@
+package outbox
+
+import (
+	"github.com/project-flogo/core/data/coerce"
+)
+
+type Settings struct {
+	Store string `md:"store,required"`
+	Topic string `md:"topic,required"`
+}
+
+type Input struct {
+	Key         interface{}       `md:"key"`
+	Properties  map[string]string `md:"properties"`
+	Payload     interface{}       `md:"payload"`
+	Transaction interface{}       `md:"transaction"`
+}
+
+func (r *Input) FromMap(values map[string]interface{}) (err error) {
+	r.Key, err = coerce.ToString(values["key"])
+	if err != nil {
+		return
+	}
+	r.Properties, err = coerce.ToParams(values["properties"])
+	if err != nil {
+		return
+	}
+	r.Payload, err = coerce.ToAny(values["payload"])
+	if err != nil {
+		return
+	}
+	r.Transaction = values["transaction"]
+	return
+}
+
+func (r *Input) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"key":         r.Key,
+		"properties":  r.Properties,
+		"payload":     r.Payload,
+		"transaction": r.Transaction,
+	}
+}
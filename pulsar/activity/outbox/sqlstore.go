@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SQLStore is an outbox store in a table of a database/sql database, created by the app, e.g. for PostgreSQL:
+//
+//	CREATE TABLE pulsar_outbox (
+//		sequence   BIGSERIAL PRIMARY KEY,
+//		topic      VARCHAR(512) NOT NULL,
+//		msg_key    VARCHAR(512),
+//		properties TEXT,
+//		payload    BYTEA,
+//		staged_at  TIMESTAMP NOT NULL
+//	)
+type SQLStore struct {
+	DB    *sql.DB
+	Table string
+	// Placeholder returns the n-th bind parameter of a statement, starting at 1, "?" when nil. Set it to
+	// PostgresPlaceholder for PostgreSQL.
+	Placeholder func(n int) string
+}
+
+// PostgresPlaceholder returns the bind parameters of PostgreSQL, $1, $2, ...
+func PostgresPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (s *SQLStore) placeholder(n int) string {
+	if s.Placeholder == nil {
+		return "?"
+	}
+	return s.Placeholder(n)
+}
+
+// Stage implements Store.Stage, tx is a *sql.Tx. Without transaction the message is inserted on its own.
+func (s *SQLStore) Stage(ctx context.Context, tx interface{}, msg *Message) error {
+	properties, err := json.Marshal(msg.Properties)
+	if err != nil {
+		return err
+	}
+	statement := fmt.Sprintf("INSERT INTO %s (topic, msg_key, properties, payload, staged_at) VALUES (%s, %s, %s, %s, %s)",
+		s.Table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4), s.placeholder(5))
+	args := []interface{}{msg.Topic, msg.Key, string(properties), msg.Payload, msg.StagedAt}
+	switch t := tx.(type) {
+	case nil:
+		_, err = s.DB.ExecContext(ctx, statement, args...)
+	case *sql.Tx:
+		_, err = t.ExecContext(ctx, statement, args...)
+	default:
+		return fmt.Errorf("unsupported transaction type %T, expected *sql.Tx", tx)
+	}
+	return err
+}
+
+// Pending implements Store.Pending
+func (s *SQLStore) Pending(ctx context.Context, limit int) ([]*Message, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf("SELECT sequence, topic, msg_key, properties, payload, staged_at FROM %s ORDER BY sequence LIMIT %d", s.Table, limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []*Message
+	for rows.Next() {
+		msg := &Message{}
+		var key, properties sql.NullString
+		var stagedAt time.Time
+		if err = rows.Scan(&msg.Sequence, &msg.Topic, &key, &properties, &msg.Payload, &stagedAt); err != nil {
+			return nil, err
+		}
+		msg.Key, msg.StagedAt = key.String, stagedAt
+		if properties.String != "" {
+			if err = json.Unmarshal([]byte(properties.String), &msg.Properties); err != nil {
+				return nil, fmt.Errorf("invalid properties of outbox message %d: %v", msg.Sequence, err)
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+// Remove implements Store.Remove
+func (s *SQLStore) Remove(ctx context.Context, sequence int64) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE sequence <= %s", s.Table, s.placeholder(1)), sequence)
+	return err
+}
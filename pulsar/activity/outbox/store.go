@@ -0,0 +1,58 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Message is a message staged in the outbox
+type Message struct {
+	// Sequence orders the messages of the outbox, it is assigned by the store and increases with each staged message
+	Sequence   int64
+	Topic      string
+	Key        string
+	Properties map[string]string
+	Payload    []byte
+	StagedAt   time.Time
+}
+
+// Store keeps the outbox next to the data of the app, so that messages are staged in the same transaction as the
+// changes they announce
+type Store interface {
+	// Stage adds the message to the outbox within the transaction, nil if the flow has none
+	Stage(ctx context.Context, tx interface{}, msg *Message) error
+	// Pending returns up to limit staged messages by increasing sequence
+	Pending(ctx context.Context, limit int) ([]*Message, error)
+	// Remove deletes the messages up to and including the sequence once published
+	Remove(ctx context.Context, sequence int64) error
+}
+
+var (
+	storesLock sync.RWMutex
+	stores     = make(map[string]Store)
+)
+
+// RegisterStore registers an outbox store which the outbox and outbox relay activities reference by name in their
+// store setting. It is typically called from the init function of the registering package.
+func RegisterStore(name string, store Store) error {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	if _, exists := stores[name]; exists {
+		return fmt.Errorf("outbox store [%s] already registered", name)
+	}
+	stores[name] = store
+	return nil
+}
+
+// GetStore returns the store registered with the name
+func GetStore(name string) (Store, error) {
+	storesLock.RLock()
+	defer storesLock.RUnlock()
+	store, ok := stores[name]
+	if !ok {
+		return nil, fmt.Errorf("outbox store [%s] is not registered", name)
+	}
+	return store, nil
+}
@@ -0,0 +1,118 @@
+package outboxrelay
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/jdattatr-tibco/messaging-contrib/pulsar/activity/outbox"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/activity"
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/engine"
+)
+
+const (
+	defaultBatchSize = 100
+	defaultInterval  = 1000
+)
+
+func init() {
+	_ = activity.Register(&Activity{}, New)
+}
+
+var activityMd = activity.ToMetadata(&Settings{}, &Output{})
+
+// New creates the outbox relay activity and starts draining the outbox in the background
+func New(ctx activity.InitContext) (activity.Activity, error) {
+	s := &Settings{}
+	err := metadata.MapToStruct(ctx.Settings(), s, true)
+	if err != nil {
+		return nil, err
+	}
+	pulsarConn, err := coerce.ToConnection(s.Connection)
+	if err != nil {
+		return nil, err
+	}
+	connMgr := pulsarConn.GetConnection().(connection.PulsarConnManager)
+	store, err := outbox.GetStore(s.Store)
+	if err != nil {
+		return nil, err
+	}
+	if s.BatchSize <= 0 {
+		s.BatchSize = defaultBatchSize
+	}
+	if s.Interval <= 0 {
+		s.Interval = defaultInterval
+	}
+	if s.ProducerName == "" {
+		s.ProducerName = engine.GetAppName() + "-outbox-" + s.Store
+	}
+	logger := connMgr.Labels.Logger(ctx.Logger())
+	r := &relay{
+		connMgr:      connMgr,
+		store:        store,
+		storeName:    s.Store,
+		producerName: s.ProducerName,
+		batchSize:    s.BatchSize,
+		logger:       logger,
+		producers:    make(map[string]pulsar.Producer),
+	}
+	act := &Activity{relay: r, done: make(chan bool)}
+	if s.LeaderTopic != "" {
+		topic, err := connMgr.NormalizeTopic(s.LeaderTopic)
+		if err != nil {
+			return nil, err
+		}
+		// one replica drains the outbox, the others stand by
+		act.election = connection.NewLeaderElection(connMgr, topic, s.ProducerName+"-leader", candidateName(s.ProducerName), 0)
+		go act.election.Run(act.done, func() {}, func() {})
+	}
+	go r.run(time.Duration(s.Interval)*time.Millisecond, act.election, act.done)
+	logger.Infof("Relaying outbox [%s] every %dms", s.Store, s.Interval)
+	return act, nil
+}
+
+// Activity relays the messages staged by the outbox activity to their topics. The outbox is drained in the
+// background, a flow evaluating the activity drains it right away, e.g. after committing a transaction.
+type Activity struct {
+	relay    *relay
+	election *connection.LeaderElection
+	done     chan bool
+}
+
+// Metadata returns the activity's metadata
+func (a *Activity) Metadata() *activity.Metadata {
+	return activityMd
+}
+
+// Eval drains the outbox, unless another replica leads the relay
+func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
+	published := 0
+	if a.election == nil || a.election.IsLeader() {
+		if published, err = a.relay.drain(); err != nil {
+			return true, err
+		}
+	}
+	ctx.Logger().Debugf("Published %d outbox messages", published)
+	err = ctx.SetOutputObject(&Output{Published: published})
+	return true, err
+}
+
+// Cleanup stops relaying and closes the producers
+func (a *Activity) Cleanup() error {
+	close(a.done)
+	a.relay.close()
+	return nil
+}
+
+// candidateName identifies the replica in the leader election
+func candidateName(producerName string) string {
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = fmt.Sprintf("%d", time.Now().UnixMilli())
+	}
+	return producerName + "-" + hostName
+}
@@ -0,0 +1,33 @@
+package outboxrelay
+
+import (
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/support/connection"
+)
+
+type Settings struct {
+	Connection   connection.Manager `md:"connection"`
+	Store        string             `md:"store,required"`
+	ProducerName string             `md:"producerName"`
+	BatchSize    int                `md:"batchSize"`
+	Interval     int                `md:"interval"`
+	LeaderTopic  string             `md:"leaderTopic"`
+}
+
+// Output of the outbox relay activity
+type Output struct {
+	Published int `md:"published"`
+}
+
+// FromMap frommap
+func (o *Output) FromMap(values map[string]interface{}) (err error) {
+	o.Published, err = coerce.ToInt(values["published"])
+	return
+}
+
+// ToMap tomap
+func (o *Output) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"published": o.Published,
+	}
+}
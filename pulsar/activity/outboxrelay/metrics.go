@@ -0,0 +1,21 @@
+package outboxrelay
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are registered with the default prometheus registry, which is also used by the pulsar client
+var (
+	publishedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_outbox_published_messages_total",
+		Help: "Number of outbox messages published by the relay",
+	}, []string{"store", "topic"})
+	relayFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_outbox_relay_failures_total",
+		Help: "Number of outbox drains which failed to read, publish or remove messages",
+	}, []string{"store"})
+)
+
+func init() {
+	prometheus.MustRegister(publishedMessages, relayFailures)
+}
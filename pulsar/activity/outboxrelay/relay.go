@@ -0,0 +1,134 @@
+package outboxrelay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/jdattatr-tibco/messaging-contrib/pulsar/activity/outbox"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/support/log"
+)
+
+// relay publishes the messages of an outbox store in sequence order and removes them once the broker confirmed
+// them. A message published again after a failure, e.g. when the app stopped before removing it, carries the same
+// sequence id and is dropped by the brokers when deduplication is enabled.
+type relay struct {
+	connMgr      connection.PulsarConnManager
+	store        outbox.Store
+	storeName    string
+	producerName string
+	batchSize    int
+	logger       log.Logger
+
+	// one drain at a time, messages are published in order
+	lock      sync.Mutex
+	producers map[string]pulsar.Producer
+	closed    bool
+}
+
+// run drains the outbox every interval until done is closed, only while the replica leads if there is an election
+func (r *relay) run(interval time.Duration, election *connection.LeaderElection, done chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if election != nil && !election.IsLeader() {
+				continue
+			}
+			if _, err := r.drain(); err != nil {
+				r.logger.Warnf("Outbox [%s] not drained, retrying in %v: %v", r.storeName, interval, err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// drain publishes the pending messages batch by batch, it returns the number of published messages
+func (r *relay) drain() (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return 0, fmt.Errorf("outbox relay is stopped")
+	}
+	ctx := context.Background()
+	published := 0
+	for {
+		msgs, err := r.store.Pending(ctx, r.batchSize)
+		if err != nil {
+			relayFailures.WithLabelValues(r.storeName).Inc()
+			return published, fmt.Errorf("unable to read the outbox: %v", err)
+		}
+		if len(msgs) == 0 {
+			return published, nil
+		}
+		for _, msg := range msgs {
+			if err = r.publish(ctx, msg); err != nil {
+				relayFailures.WithLabelValues(r.storeName).Inc()
+				return published, err
+			}
+			// removed one by one, a failure only publishes the last message again
+			if err = r.store.Remove(ctx, msg.Sequence); err != nil {
+				relayFailures.WithLabelValues(r.storeName).Inc()
+				return published, fmt.Errorf("unable to remove outbox message %d: %v", msg.Sequence, err)
+			}
+			published++
+		}
+	}
+}
+
+func (r *relay) publish(ctx context.Context, msg *outbox.Message) error {
+	producer, err := r.producer(msg.Topic)
+	if err != nil {
+		return err
+	}
+	sequence := msg.Sequence
+	_, err = producer.Send(ctx, &pulsar.ProducerMessage{
+		Key:        msg.Key,
+		Properties: msg.Properties,
+		Payload:    msg.Payload,
+		EventTime:  msg.StagedAt,
+		SequenceID: &sequence,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to publish outbox message %d to topic [%s]: %v", msg.Sequence, msg.Topic, err)
+	}
+	publishedMessages.WithLabelValues(r.storeName, r.connMgr.MetricTopics.Label(msg.Topic)).Inc()
+	return nil
+}
+
+func (r *relay) producer(topic string) (pulsar.Producer, error) {
+	topic, err := r.connMgr.NormalizeTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if producer, ok := r.producers[topic]; ok {
+		if !r.connMgr.Refreshed(producer) {
+			return producer, nil
+		}
+		// re-create the producer with the credentials of the refreshed connection
+		r.connMgr.CloseProducer(producer)
+		delete(r.producers, topic)
+	}
+	// the brokers deduplicate by producer name and sequence id
+	producer, err := r.connMgr.GetProducer(pulsar.ProducerOptions{Topic: topic, Name: r.producerName})
+	if err != nil {
+		return nil, err
+	}
+	r.producers[topic] = producer
+	return producer, nil
+}
+
+func (r *relay) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	for topic, producer := range r.producers {
+		r.connMgr.CloseProducer(producer)
+		delete(r.producers, topic)
+	}
+}
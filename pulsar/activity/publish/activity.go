@@ -2,8 +2,11 @@ package publish
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/apache/pulsar-client-go/pulsar"
@@ -24,6 +27,12 @@ func init() {
 
 var activityMd = activity.ToMetadata(&Settings{}, &Input{}, &Output{})
 
+// defaultBackpressureTimeout is the time in milliseconds a send waits for the backpressure to clear
+const defaultBackpressureTimeout = 30000
+
+// errQueueFull is returned when the pending queue stays full for the backpressureTimeout
+var errQueueFull = fmt.Errorf("producer queue is full")
+
 //New optional factory method, should be used if one activity instance per configuration is desired
 func New(ctx activity.InitContext) (activity.Activity, error) {
 
@@ -41,8 +50,21 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 	if ctx.Settings()["topic"] == nil {
 		return nil, fmt.Errorf("no topic specified")
 	}
+	connMgr := pulsarConn.GetConnection().(connection.PulsarConnManager)
+	topic, err := connMgr.NormalizeTopic(ctx.Settings()["topic"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if s.FallbackTopic != "" {
+		if s.FallbackTopic, err = connMgr.NormalizeTopic(s.FallbackTopic); err != nil {
+			return nil, fmt.Errorf("fallbackTopic: %v", err)
+		}
+	}
+	if err = connMgr.CheckTopicPolicies(topic, s.AssertPolicies, s.PolicyViolation); err != nil {
+		return nil, err
+	}
 	producerOptions := pulsar.ProducerOptions{
-		Topic: ctx.Settings()["topic"].(string),
+		Topic: topic,
 	}
 	if ctx.Settings()["compressionType"] != nil {
 		switch ctx.Settings()["compressionType"].(string) {
@@ -57,22 +79,140 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 		}
 	}
 
-	connMgr := pulsarConn.GetConnection().(connection.PulsarConnManager)
+	if s.MaxPendingMessages > 0 {
+		producerOptions.MaxPendingMessages = s.MaxPendingMessages
+	}
+	if s.BackpressureDelay > 0 {
+		// fail fast on a full queue so that backpressure can be signalled to the consumer
+		producerOptions.DisableBlockIfQueueFull = true
+		if s.BackpressureTimeout <= 0 {
+			s.BackpressureTimeout = defaultBackpressureTimeout
+		}
+	}
 
+	postProcessors, err := getPostProcessors(s.PostProcessors)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := newProducerIdentity(s.ProducerIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if s.Checksum != "" {
+		if err = connection.ValidateChecksum(s.Checksum); err != nil {
+			return nil, err
+		}
+	}
+	dedup, err := connection.NewDeduplicator(time.Duration(s.DedupWindow)*time.Second, s.DedupHash, s.DedupProperties)
+	if err != nil {
+		return nil, fmt.Errorf("dedupHash: %v", err)
+	}
+	if s.CompensationTopic != "" {
+		if _, err = connection.NormalizeTopic(s.CompensationTopic, false); err != nil {
+			return nil, fmt.Errorf("invalid compensationTopic: %v", err)
+		}
+	}
+
+	metricTopic := connMgr.MetricTopics.Label(topic)
+	connMgr.Labels.RegisterMetric("pulsar_publish_labels", "topic", metricTopic)
 	act := &Activity{
-		producerOpts: producerOptions,
-		pulsarConn:   pulsarConn,
-		connMgr:      connMgr,
+		postProcessors:      postProcessors,
+		producerOpts:        producerOptions,
+		pulsarConn:          pulsarConn,
+		connMgr:             connMgr,
+		backpressureDelay:   time.Duration(s.BackpressureDelay) * time.Millisecond,
+		backpressureTimeout: time.Duration(s.BackpressureTimeout) * time.Millisecond,
+		keyExpression:       s.KeyExpression,
+		identity:            identity,
+		replay:              newReplayGuard(s.ReplayWindow, s.ReplayBurst, s.ReplayAction),
+		checksum:            s.Checksum,
+		pendingBytes:        connection.NewMemoryLimiter(s.MaxPendingBytes),
+		dedup:               dedup,
+		metricTopic:         metricTopic,
+		startSaga:           s.StartSaga,
+		compensationTopic:   s.CompensationTopic,
+	}
+	var sp *spool
+	if s.SpoolFile != "" {
+		sp = newSpool(s.SpoolFile, metricTopic, s.SpoolMaxMessages)
+	}
+	if s.StoreAndForward {
+		if sp == nil {
+			return nil, fmt.Errorf("spoolFile is required for store and forward")
+		}
+		act.forwarder = newForwarder(sp)
+	}
+	if s.CircuitBreaker != "" {
+		act.breaker = newSendBreaker(s.CircuitBreaker, s.FailureThreshold, s.CircuitOpenTime)
+		// with store and forward the spool is drained by the forwarder
+		if s.FallbackTopic != "" {
+			act.fallback = &fallback{topic: s.FallbackTopic, connMgr: connMgr}
+		} else if sp != nil && !s.StoreAndForward {
+			act.fallback = &fallback{spool: sp, connMgr: connMgr}
+		}
+	}
+	if s.WarmUp {
+		if err = act.warmUp(connMgr.Labels.Logger(ctx.Logger())); err != nil {
+			return nil, err
+		}
 	}
 	return act, nil
 }
 
 // Activity is an sample Activity that can be used as a base to create a custom activity
 type Activity struct {
-	producer     pulsar.Producer
-	producerOpts pulsar.ProducerOptions
-	connMgr      connection.PulsarConnManager
-	pulsarConn   cnn.Manager
+	producer            pulsar.Producer
+	producerOpts        pulsar.ProducerOptions
+	connMgr             connection.PulsarConnManager
+	pulsarConn          cnn.Manager
+	backpressureDelay   time.Duration
+	backpressureTimeout time.Duration
+	postProcessors      []PostProcessor
+	breaker             *sendBreaker
+	fallback            *fallback
+	forwarder           *forwarder
+	restore             sync.Once
+	keyExpression       string
+	identity            *producerIdentity
+	replay              *replayGuard
+	checksum            string
+	pendingBytes        *connection.MemoryLimiter
+	dedup               *connection.Deduplicator
+	metricTopic         string
+	startSaga           bool
+	compensationTopic   string
+}
+
+// warmUp eagerly creates the producer, which connects to the brokers of all partitions of the topic,
+// so the first message of the flow does not pay for the connection establishment
+func (a *Activity) warmUp(logger log.Logger) error {
+	start := time.Now()
+	producer, err := a.connMgr.GetProducer(a.producerOpts)
+	if err != nil {
+		return fmt.Errorf("Publisher warm-up failed for topic [%s]: %v", a.producerOpts.Topic, err)
+	}
+	a.producer = producer
+	a.producerReady(logger)
+	partitions, err := a.connMgr.Client.TopicPartitions(a.producerOpts.Topic)
+	if err != nil {
+		logger.Warnf("Unable to get partitions of topic [%s]: %v", a.producerOpts.Topic, err)
+	}
+	logger.Infof("Producer [%s] warmed up for topic [%s] with %d partition(s) in %v", producer.Name(), a.producerOpts.Topic, len(partitions), time.Since(start))
+	return nil
+}
+
+// producerReady resumes sending the messages diverted or spooled before a restart. The fallback is replayed
+// with the first producer only, later replays follow the closing of the circuit breaker.
+func (a *Activity) producerReady(logger log.Logger) {
+	if a.fallback != nil {
+		producer := a.producer
+		a.restore.Do(func() {
+			go a.fallback.replay(producer, logger)
+		})
+	}
+	if a.forwarder != nil {
+		a.forwarder.start(a.producer, logger)
+	}
 }
 
 // Metadata returns the activity's metadata
@@ -83,7 +223,13 @@ func (a *Activity) Metadata() *activity.Metadata {
 // Eval implements api.Activity.Eval - Logs the Message
 func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	a.connMgr = a.pulsarConn.GetConnection().(connection.PulsarConnManager)
-	var logger log.Logger = ctx.Logger()
+	var logger log.Logger = a.connMgr.Labels.Logger(ctx.Logger())
+
+	if a.producer != nil && a.connMgr.Refreshed(a.producer) {
+		logger.Infof("Connection refreshed, re-creating the producer for topic [%s]", a.producerOpts.Topic)
+		a.connMgr.CloseProducer(a.producer)
+		a.producer = nil
+	}
 
 	if a.producer == nil {
 		var hostName string
@@ -97,6 +243,7 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 
 			return false, err
 		}
+		a.producerReady(logger)
 	}
 
 	input := &Input{}
@@ -122,8 +269,8 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		if err != nil {
 			return true, err
 		}
-		logger.Debugf("Publisher payload properties: %v", input.Properties)
 		msg.Properties = props.(map[string]string)
+		logger.Debugf("Publisher payload properties: %v", a.connMgr.Masker.MaskProperties(msg.Properties))
 	}
 	if input.Key != "" {
 		logger.Debugf("Publisher payload key: %s", input.Key)
@@ -132,24 +279,223 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 			return true, err
 		}
 		msg.Key = keyStr.(string)
+	} else if a.keyExpression != "" {
+		if key, err := deriveKey(input.Payload, msg.Payload, a.keyExpression); err != nil {
+			logger.Warnf("%v, sending the message without key", err)
+		} else {
+			msg.Key = key
+			logger.Debugf("Publisher payload key derived from [%s]: %s", a.keyExpression, key)
+		}
+	}
+	if a.replay != nil && msg.Key != "" && !a.replay.allow(msg.Key, connection.Now()) {
+		suppressedMessages.WithLabelValues(a.metricTopic).Inc()
+		if a.replay.action == ReplayActionFail {
+			return true, fmt.Errorf("Publisher suppressed message with key [%s], sent more than %v times within %v", msg.Key, a.replay.burst, a.replay.window)
+		}
+		logger.Debugf("Publisher suppressed message with key [%s], sent more than %v times within %v", msg.Key, a.replay.burst, a.replay.window)
+		ctx.SetOutput("suppressed", true)
+		return true, nil
 	}
 	if msg.Properties == nil {
 		msg.Properties = make(map[string]string)
 	}
+	if len(input.StructuredProperties) > 0 {
+		if err = a.connMgr.PropertyCodec.Encode(input.StructuredProperties, msg.Properties); err != nil {
+			return true, fmt.Errorf("unable to encode structured properties: %v", err)
+		}
+	}
+	if a.dedup != nil && a.dedup.Duplicate(a.dedup.Hash(msg.Payload, msg.Properties), "") {
+		suppressedMessages.WithLabelValues(a.metricTopic).Inc()
+		logger.Debugf("Publisher suppressed message with the content of a message sent within the dedup window")
+		ctx.SetOutput("suppressed", true)
+		return true, nil
+	}
+	if a.identity != nil {
+		a.identity.stamp(ctx, msg.Properties)
+	}
+	saga, err := a.sagaStep(input.Saga)
+	if err != nil {
+		return true, err
+	}
+	if saga != nil {
+		saga.Inject(msg.Properties)
+		logger.Debugf("Publisher message is step %d of saga [%s]", saga.Step, saga.ID)
+	}
+	if propagation := a.connMgr.Propagation; propagation != nil {
+		propagation.Inject(propagation.Collect(nil, ctx.ActivityHost().Scope(), input.Context), msg.Properties)
+	}
 	if trace.Enabled() {
+		a.connMgr.Labels.TagSpan(ctx.GetTracingContext())
 		_ = trace.GetTracer().Inject(ctx.GetTracingContext(), trace.TextMap, msg.Properties)
 	}
-	msgID, err := a.producer.Send(context.Background(), &msg)
+	if input.DeliverAt != nil && input.DeliverAt != "" {
+		deliverAt, err := toDeliverAt(input.DeliverAt)
+		if err != nil {
+			return true, err
+		}
+		if connection.InFuture(deliverAt) {
+			msg.DeliverAt = deliverAt
+		}
+	}
+	for _, p := range a.postProcessors {
+		if err = p.Process(context.Background(), &msg); err != nil {
+			return true, fmt.Errorf("Publisher post-processing failed: %v", err)
+		}
+	}
+	if a.checksum != "" {
+		// of the payload as sent, after post-processing
+		if err = connection.StampChecksum(a.checksum, msg.Payload, msg.Properties); err != nil {
+			return true, err
+		}
+	}
+	msgID, err := a.publish(ctx, &msg)
 	if err != nil {
 		return true, fmt.Errorf("Publisher could not send message: %v", err)
 	}
-	ctx.SetOutput("msgid", fmt.Sprintf("%x", msgID.Serialize()))
+	if msgID != nil {
+		ctx.SetOutput("msgid", fmt.Sprintf("%x", msgID.Serialize()))
+	}
+	if saga != nil {
+		ctx.SetOutput("saga", saga.ToMap())
+	}
 	return true, nil
 }
 
+// sagaStep returns the saga of the message to publish: the step following the one of the input saga, usually the
+// saga output of the trigger, or a new saga if startSaga is set
+func (a *Activity) sagaStep(current map[string]interface{}) (*connection.Saga, error) {
+	saga, err := connection.SagaFromMap(current)
+	if err != nil {
+		return nil, err
+	}
+	if saga != nil {
+		saga = saga.Next()
+	} else if a.startSaga {
+		saga = connection.NewSaga("")
+	} else {
+		return nil, nil
+	}
+	if a.compensationTopic != "" {
+		saga.CompensationTopic = a.compensationTopic
+	}
+	return saga, nil
+}
+
+// publish sends the message, spooling it while the broker is unavailable in store and forward mode
+func (a *Activity) publish(ctx activity.Context, msg *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+	if a.forwarder == nil {
+		return a.guardedSend(ctx, msg)
+	}
+	return a.forwarder.publish(a.connMgr.Labels.Logger(ctx.Logger()), msg, func(m *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+		return a.guardedSend(ctx, m)
+	})
+}
+
+// guardedSend sends the message through the circuit breaker, if any. While the breaker is open messages
+// are diverted to the fallback and replayed once a probe message was sent successfully.
+func (a *Activity) guardedSend(ctx activity.Context, msg *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+	if a.breaker == nil {
+		return a.send(ctx, msg)
+	}
+	if !a.breaker.allow() {
+		if a.fallback == nil {
+			return nil, errCircuitOpen
+		}
+		a.connMgr.Labels.Logger(ctx.Logger()).Debugf("Circuit breaker [%s] is open, diverting message", a.breaker.name)
+		return a.fallback.divert(msg)
+	}
+	msgID, err := a.send(ctx, msg)
+	if a.breaker.done(err) && a.fallback != nil {
+		go a.fallback.replay(a.producer, a.connMgr.Labels.Logger(ctx.Logger()))
+	}
+	return msgID, err
+}
+
+func (a *Activity) send(ctx activity.Context, msg *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+	size := int64(len(msg.Payload))
+	deadline := time.Now().Add(a.backpressureTimeout)
+	for {
+		// without backpressure the send blocks until the memory limits allow it
+		available := true
+		if a.backpressureDelay > 0 {
+			available = a.pendingBytes.TryAcquire(size)
+			if available && !a.connMgr.Memory.TryAcquire(size) {
+				a.pendingBytes.Release(size)
+				available = false
+			}
+		} else {
+			// the limit of the activity first, so that waiting for it holds no memory of the connection
+			a.pendingBytes.Acquire(size)
+			a.connMgr.Memory.Acquire(size)
+		}
+		if available {
+			msgID, err := a.producer.Send(context.Background(), msg)
+			a.connMgr.Memory.Release(size)
+			a.pendingBytes.Release(size)
+			if a.backpressureDelay <= 0 || !isQueueFull(err) {
+				return msgID, err
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w, backpressure did not clear within %v", errQueueFull, a.backpressureTimeout)
+		}
+		a.connMgr.Labels.Logger(ctx.Logger()).Debugf("Producer queue is full, signalling backpressure for %v", a.backpressureDelay)
+		a.connMgr.Backpressure.Signal(a.backpressureDelay)
+		time.Sleep(a.backpressureDelay)
+	}
+}
+
+// deriveKey computes the message key from the payload with a JSON path such as "$.order.customerId"
+func deriveKey(payload interface{}, payloadBytes []byte, expression string) (string, error) {
+	doc, ok := payload.(map[string]interface{})
+	if !ok {
+		if err := json.Unmarshal(payloadBytes, &doc); err != nil {
+			return "", fmt.Errorf("keyExpression requires a JSON object payload: %v", err)
+		}
+	}
+	value, found := connection.LookupPath(doc, expression)
+	if !found || value == nil {
+		return "", fmt.Errorf("keyExpression [%s] does not match the payload", expression)
+	}
+	return coerce.ToString(value)
+}
+
+// toDeliverAt converts the deliverAt input, epoch milliseconds or an RFC3339 time, to a time
+func toDeliverAt(value interface{}) (time.Time, error) {
+	if s, ok := value.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+	}
+	ms, err := coerce.ToInt64(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deliverAt [%v]: epoch milliseconds or an RFC3339 time expected", value)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func isQueueFull(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errQueueFull) {
+		return true
+	}
+	if pErr, ok := err.(*pulsar.Error); ok {
+		return pErr.Result() == pulsar.ProducerQueueIsFull
+	}
+	return false
+}
+
 func (a *Activity) Cleanup() error {
 	if a.producer != nil {
-		a.producer.Close()
+		a.connMgr.CloseProducer(a.producer)
+	}
+	if a.fallback != nil {
+		a.fallback.close()
+	}
+	if a.forwarder != nil {
+		a.forwarder.close()
 	}
 	return nil
 }
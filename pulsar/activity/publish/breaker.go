@@ -0,0 +1,230 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/support/log"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCircuitOpenTime  = 30
+	replaySubscription      = "flogo-replay"
+	replayIdleTimeout       = 5 * time.Second
+)
+
+// errCircuitOpen is returned when the breaker is open and there is no fallback for the message
+var errCircuitOpen = fmt.Errorf("circuit breaker is open")
+
+// sendBreaker guards producer.Send. It opens after consecutive send failures and lets a single probe
+// message through once the open time elapsed. State changes are published with connection.SetCircuitState,
+// so Pulsar triggers referencing the same circuit breaker pause while it is open.
+type sendBreaker struct {
+	name      string
+	threshold int
+	openTime  time.Duration
+
+	lock     sync.Mutex
+	state    connection.CircuitState
+	failures int
+	openedAt time.Time
+}
+
+func newSendBreaker(name string, threshold, openTime int) *sendBreaker {
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	if openTime <= 0 {
+		openTime = defaultCircuitOpenTime
+	}
+	return &sendBreaker{name: name, threshold: threshold, openTime: time.Duration(openTime) * time.Second}
+}
+
+// allow returns true if the message may be sent to the broker
+func (b *sendBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case connection.CircuitClosed:
+		return true
+	case connection.CircuitOpen:
+		if time.Since(b.openedAt) < b.openTime {
+			return false
+		}
+		b.setState(connection.CircuitHalfOpen)
+		return true
+	default:
+		// a probe message is in flight
+		return false
+	}
+}
+
+// done records the result of a send, it returns true when the breaker closed again
+func (b *sendBreaker) done(err error) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err == nil {
+		b.failures = 0
+		if b.state != connection.CircuitClosed {
+			b.setState(connection.CircuitClosed)
+			return true
+		}
+		return false
+	}
+	b.failures++
+	if b.state == connection.CircuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(connection.CircuitOpen)
+	}
+	return false
+}
+
+func (b *sendBreaker) setState(state connection.CircuitState) {
+	b.state = state
+	connection.SetCircuitState(b.name, state)
+}
+
+// fallback receives the messages diverted while the breaker is open and replays them to the
+// destination topic once it is closed again
+type fallback struct {
+	topic    string
+	spool    *spool
+	connMgr  connection.PulsarConnManager
+	lock     sync.Mutex
+	producer pulsar.Producer
+	consumer pulsar.Consumer
+	replays  sync.Mutex
+}
+
+// divert publishes the message on the fallback topic or appends it to the spool file. The returned
+// message id is nil when the message was spooled.
+func (f *fallback) divert(msg *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+	if f.topic == "" {
+		return nil, f.spool.append(msg)
+	}
+	producer, err := f.getProducer()
+	if err != nil {
+		return nil, err
+	}
+	return producer.Send(context.Background(), msg)
+}
+
+// getProducer creates the fallback producer along with the replay subscription, so that the
+// diverted messages are retained until they are replayed
+func (f *fallback) getProducer() (pulsar.Producer, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.producer != nil && f.connMgr.Refreshed(f.producer) {
+		f.connMgr.CloseProducer(f.producer)
+		f.producer = nil
+	}
+	if f.producer != nil {
+		return f.producer, nil
+	}
+	if f.consumer == nil {
+		consumer, err := f.connMgr.GetSubscriber(pulsar.ConsumerOptions{
+			Topic:                       f.topic,
+			SubscriptionName:            replaySubscription,
+			Type:                        pulsar.Exclusive,
+			SubscriptionInitialPosition: pulsar.SubscriptionPositionEarliest,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to subscribe to fallback topic [%s]: %v", f.topic, err)
+		}
+		f.consumer = consumer
+	}
+	producer, err := f.connMgr.GetProducer(pulsar.ProducerOptions{Topic: f.topic})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create producer for fallback topic [%s]: %v", f.topic, err)
+	}
+	f.producer = producer
+	return producer, nil
+}
+
+// replay sends the diverted messages to the destination producer in order, concurrent calls are ignored
+func (f *fallback) replay(producer pulsar.Producer, logger log.Logger) {
+	if !f.replays.TryLock() {
+		return
+	}
+	defer f.replays.Unlock()
+
+	send := func(msg *pulsar.ProducerMessage) error {
+		_, err := producer.Send(context.Background(), msg)
+		return err
+	}
+	var count, rejected int
+	var err error
+	if f.topic == "" {
+		count, rejected, err = f.spool.replay(send)
+		if rejected > 0 {
+			logger.Errorf("%d diverted message(s) rejected by topic [%s] were moved to [%s%s]", rejected, producer.Topic(), f.spool.path, rejectedSuffix)
+		}
+	} else {
+		count, rejected, err = f.replayTopic(send)
+		if rejected > 0 {
+			logger.Errorf("%d diverted message(s) rejected by topic [%s] were dropped from fallback topic [%s]", rejected, producer.Topic(), f.topic)
+		}
+	}
+	if err != nil {
+		logger.Warnf("Replay of diverted messages stopped after %d message(s): %v", count, err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("Replayed %d diverted message(s) to topic [%s]", count, producer.Topic())
+	}
+}
+
+// replayTopic sends the messages of the fallback topic until it is drained or the broker is unavailable. Messages
+// rejected by the broker are acknowledged so they do not block the replay.
+func (f *fallback) replayTopic(send func(*pulsar.ProducerMessage) error) (sent, rejected int, err error) {
+	f.lock.Lock()
+	consumer := f.consumer
+	f.lock.Unlock()
+	if consumer == nil {
+		// nothing was diverted yet
+		return 0, 0, nil
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), replayIdleTimeout)
+		msg, err := consumer.Receive(ctx)
+		cancel()
+		if err != nil {
+			// the fallback topic is drained
+			return sent, rejected, nil
+		}
+		err = send(&pulsar.ProducerMessage{
+			Payload:    msg.Payload(),
+			Key:        msg.Key(),
+			Properties: msg.Properties(),
+			EventTime:  msg.EventTime(),
+		})
+		if isOutage(err) {
+			consumer.Nack(msg)
+			return sent, rejected, err
+		}
+		consumer.Ack(msg)
+		if err != nil {
+			rejected++
+		} else {
+			sent++
+		}
+	}
+}
+
+func (f *fallback) close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.producer != nil {
+		f.connMgr.CloseProducer(f.producer)
+		f.producer = nil
+	}
+	if f.consumer != nil {
+		f.connMgr.CloseSubscriber(f.consumer)
+		f.consumer = nil
+	}
+}
@@ -0,0 +1,118 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/support/log"
+)
+
+const spoolRetryInterval = 5 * time.Second
+
+// forwarder implements store-and-forward: messages which cannot be sent because the broker is unavailable
+// are appended to the spool and forwarded in order once the broker is reachable again
+type forwarder struct {
+	spool     *spool
+	startOnce sync.Once
+	stop      chan struct{}
+	lock      sync.Mutex
+	producer  pulsar.Producer
+}
+
+func newForwarder(s *spool) *forwarder {
+	return &forwarder{spool: s, stop: make(chan struct{})}
+}
+
+// publish sends the message, or spools it while the broker is unavailable. The returned message id
+// is nil when the message was spooled.
+func (f *forwarder) publish(logger log.Logger, msg *pulsar.ProducerMessage, send func(*pulsar.ProducerMessage) (pulsar.MessageID, error)) (pulsar.MessageID, error) {
+	if f.spool.size() > 0 {
+		// keep the order until the spool is drained
+		return nil, f.spool.append(msg)
+	}
+	msgID, err := send(msg)
+	if err != nil && isOutage(err) {
+		logger.Warnf("Broker unavailable, spooling message: %v", err)
+		return nil, f.spool.append(msg)
+	}
+	return msgID, err
+}
+
+// start forwards the spooled messages in the background with the given producer, it is called again
+// when the producer was re-created
+func (f *forwarder) start(producer pulsar.Producer, logger log.Logger) {
+	f.lock.Lock()
+	f.producer = producer
+	f.lock.Unlock()
+	f.startOnce.Do(func() {
+		go f.run(logger)
+	})
+}
+
+func (f *forwarder) run(logger log.Logger) {
+	ticker := time.NewTicker(spoolRetryInterval)
+	defer ticker.Stop()
+	for {
+		if f.spool.size() > 0 {
+			f.lock.Lock()
+			producer := f.producer
+			f.lock.Unlock()
+			count, rejected, err := f.spool.replay(func(msg *pulsar.ProducerMessage) error {
+				_, err := producer.Send(context.Background(), msg)
+				return err
+			})
+			if count > 0 {
+				logger.Infof("Forwarded %d spooled message(s) to topic [%s]", count, producer.Topic())
+			}
+			if rejected > 0 {
+				logger.Errorf("%d spooled message(s) rejected by topic [%s] were moved to [%s%s]", rejected, producer.Topic(), f.spool.path, rejectedSuffix)
+			}
+			if err != nil {
+				logger.Debugf("Forwarding of spooled messages stopped, retrying in %v: %v", spoolRetryInterval, err)
+			}
+		}
+		select {
+		case <-ticker.C:
+			f.spool.lock.Lock()
+			f.spool.updateMetrics()
+			f.spool.lock.Unlock()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *forwarder) close() {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+}
+
+// outageResults are the send failures of a broker which is unavailable or too slow, the message may be sent later
+var outageResults = map[pulsar.Result]bool{
+	pulsar.TimeoutError:        true,
+	pulsar.ProducerQueueIsFull: true,
+	pulsar.ProducerClosed:      true,
+}
+
+// isOutage returns true for errors caused by the broker being unavailable. Any other error, e.g. a message too big or
+// a missing permission, would also occur when the message is sent again later.
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == errCircuitOpen || errors.Is(err, errQueueFull) {
+		return true
+	}
+	var pErr *pulsar.Error
+	if errors.As(err, &pErr) && outageResults[pErr.Result()] {
+		return true
+	}
+	return connection.IsConnectivityError(err)
+}
@@ -0,0 +1,85 @@
+package publish
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/project-flogo/core/activity"
+	"github.com/project-flogo/core/engine"
+)
+
+// Values of the producerIdentity setting
+const (
+	IdentityAppName        = "appName"
+	IdentityAppVersion     = "appVersion"
+	IdentityHost           = "host"
+	IdentityFlowName       = "flowName"
+	IdentityFlowInstanceID = "flowInstanceId"
+	IdentityActivity       = "activity"
+)
+
+// identityProperties are the properties the identity values are stamped as
+var identityProperties = map[string]string{
+	IdentityAppName:        "PRODUCER_APP_NAME",
+	IdentityAppVersion:     "PRODUCER_APP_VERSION",
+	IdentityHost:           "PRODUCER_HOST",
+	IdentityFlowName:       "PRODUCER_FLOW_NAME",
+	IdentityFlowInstanceID: "PRODUCER_FLOW_INSTANCE_ID",
+	IdentityActivity:       "PRODUCER_ACTIVITY",
+}
+
+// producerIdentity stamps the allowlisted identity of the producing app and flow on each message, for downstream
+// debugging and lineage
+type producerIdentity struct {
+	fields []string
+	host   string
+}
+
+// newProducerIdentity parses a comma separated allowlist of identity values, nil if empty
+func newProducerIdentity(allowlist string) (*producerIdentity, error) {
+	var fields []string
+	for _, field := range strings.Split(allowlist, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, ok := identityProperties[field]; !ok {
+			return nil, fmt.Errorf("unknown producer identity value [%s]", field)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	host, _ := os.Hostname()
+	return &producerIdentity{fields: fields, host: host}, nil
+}
+
+// stamp adds the identity properties, properties set explicitly are never overwritten
+func (i *producerIdentity) stamp(ctx activity.Context, properties map[string]string) {
+	for _, field := range i.fields {
+		name := identityProperties[field]
+		if _, exists := properties[name]; exists {
+			continue
+		}
+		var value string
+		switch field {
+		case IdentityAppName:
+			value = engine.GetAppName()
+		case IdentityAppVersion:
+			value = engine.GetAppVersion()
+		case IdentityHost:
+			value = i.host
+		case IdentityFlowName:
+			value = ctx.ActivityHost().Name()
+		case IdentityFlowInstanceID:
+			value = ctx.ActivityHost().ID()
+		case IdentityActivity:
+			value = ctx.Name()
+		}
+		if value != "" {
+			properties[name] = value
+		}
+	}
+}
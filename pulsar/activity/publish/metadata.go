@@ -6,15 +6,45 @@ import (
 )
 
 type Settings struct {
-	Connection      connection.Manager `md:"connection"`
-	Topic           string             `md:"topic,required"`
-	CompressionType string             `md:"compressionType"`
+	Connection          connection.Manager `md:"connection"`
+	Topic               string             `md:"topic,required"`
+	CompressionType     string             `md:"compressionType"`
+	MaxPendingMessages  int                `md:"maxPendingMessages"`
+	BackpressureDelay   int                `md:"backpressureDelay"`
+	BackpressureTimeout int                `md:"backpressureTimeout"`
+	PostProcessors      string             `md:"postProcessors"`
+	WarmUp              bool               `md:"warmUp"`
+	CircuitBreaker      string             `md:"circuitBreaker"`
+	FailureThreshold    int                `md:"failureThreshold"`
+	CircuitOpenTime     int                `md:"circuitOpenTime"`
+	FallbackTopic       string             `md:"fallbackTopic"`
+	SpoolFile           string             `md:"spoolFile"`
+	StoreAndForward     bool               `md:"storeAndForward"`
+	SpoolMaxMessages    int                `md:"spoolMaxMessages"`
+	KeyExpression       string             `md:"keyExpression"`
+	ProducerIdentity    string             `md:"producerIdentity"`
+	AssertPolicies      string             `md:"assertPolicies"`
+	PolicyViolation     string             `md:"policyViolation"`
+	ReplayWindow        int                `md:"replayWindow"`
+	ReplayBurst         int                `md:"replayBurst"`
+	ReplayAction        string             `md:"replayAction"`
+	Checksum            string             `md:"checksum"`
+	MaxPendingBytes     int64              `md:"maxPendingBytes"`
+	StartSaga           bool               `md:"startSaga"`
+	CompensationTopic   string             `md:"compensationTopic"`
+	DedupWindow         int                `md:"dedupWindow"`
+	DedupHash           string             `md:"dedupHash"`
+	DedupProperties     string             `md:"dedupProperties"`
 }
 
 type Input struct {
-	Key        interface{}       `md:"key"`
-	Properties map[string]string `md:"properties"`
-	Payload    interface{}       `md:"payload"`
+	Key                  interface{}            `md:"key"`
+	Properties           map[string]string      `md:"properties"`
+	Payload              interface{}            `md:"payload"`
+	Context              map[string]string      `md:"context"`
+	StructuredProperties map[string]interface{} `md:"structuredProperties"`
+	DeliverAt            interface{}            `md:"deliverAt"`
+	Saga                 map[string]interface{} `md:"saga"`
 }
 
 func (r *Input) FromMap(values map[string]interface{}) (err error) {
@@ -30,20 +60,36 @@ func (r *Input) FromMap(values map[string]interface{}) (err error) {
 	if err != nil {
 		return
 	}
+	r.Context, err = coerce.ToParams(values["context"])
+	if err != nil {
+		return
+	}
+	r.StructuredProperties, err = coerce.ToObject(values["structuredProperties"])
+	if err != nil {
+		return
+	}
+	r.DeliverAt = values["deliverAt"]
+	r.Saga, err = coerce.ToObject(values["saga"])
 	return err
 }
 
 func (r *Input) ToMap() map[string]interface{} {
 	return map[string]interface{}{
-		"payload":    r.Payload,
-		"key":        r.Key,
-		"properties": r.Properties,
+		"payload":              r.Payload,
+		"key":                  r.Key,
+		"properties":           r.Properties,
+		"context":              r.Context,
+		"structuredProperties": r.StructuredProperties,
+		"deliverAt":            r.DeliverAt,
+		"saga":                 r.Saga,
 	}
 }
 
 // Output of the publish activity
 type Output struct {
-	Msgid string `md:"msgid"`
+	Msgid      string                 `md:"msgid"`
+	Suppressed bool                   `md:"suppressed"`
+	Saga       map[string]interface{} `md:"saga"`
 }
 
 //FromMap frommap
@@ -52,12 +98,22 @@ func (o *Output) FromMap(values map[string]interface{}) (err error) {
 	if err != nil {
 		return
 	}
+	o.Suppressed, err = coerce.ToBool(values["suppressed"])
+	if err != nil {
+		return
+	}
+	o.Saga, err = coerce.ToObject(values["saga"])
+	if err != nil {
+		return
+	}
 	return
 }
 
 //ToMap tomap
 func (o *Output) ToMap() map[string]interface{} {
 	return map[string]interface{}{
-		"msgid": o.Msgid,
+		"msgid":      o.Msgid,
+		"suppressed": o.Suppressed,
+		"saga":       o.Saga,
 	}
 }
@@ -0,0 +1,29 @@
+package publish
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are registered with the default prometheus registry, which is also used by the pulsar client
+var (
+	spoolDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pulsar_publish_spool_messages",
+		Help: "Number of messages in the local spool file waiting to be sent",
+	}, []string{"topic"})
+	spoolAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pulsar_publish_spool_age_seconds",
+		Help: "Age of the oldest message in the local spool file",
+	}, []string{"topic"})
+	rejectedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_publish_spool_rejected_total",
+		Help: "Number of spooled messages rejected by the broker and moved to the rejected file",
+	}, []string{"topic"})
+	suppressedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_publish_suppressed_messages_total",
+		Help: "Number of messages not sent by the replay protection or the content deduplication",
+	}, []string{"topic"})
+)
+
+func init() {
+	prometheus.MustRegister(spoolDepth, spoolAge, rejectedMessages, suppressedMessages)
+}
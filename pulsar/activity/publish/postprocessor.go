@@ -0,0 +1,60 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// PostProcessor transforms messages (e.g. compress, sign, redact) after they are built from the
+// activity input and before they are sent
+type PostProcessor interface {
+	Process(ctx context.Context, msg *pulsar.ProducerMessage) error
+}
+
+// PostProcessorFunc adapts a function to a PostProcessor
+type PostProcessorFunc func(ctx context.Context, msg *pulsar.ProducerMessage) error
+
+// Process implements PostProcessor.Process
+func (f PostProcessorFunc) Process(ctx context.Context, msg *pulsar.ProducerMessage) error {
+	return f(ctx, msg)
+}
+
+var (
+	postProcessorsLock sync.RWMutex
+	postProcessors     = make(map[string]PostProcessor)
+)
+
+// RegisterPostProcessor registers a post-processor which activities can reference by name in their
+// postProcessors setting. It is typically called from the init function of the registering package.
+func RegisterPostProcessor(name string, p PostProcessor) error {
+	postProcessorsLock.Lock()
+	defer postProcessorsLock.Unlock()
+	if _, exists := postProcessors[name]; exists {
+		return fmt.Errorf("post-processor [%s] already registered", name)
+	}
+	postProcessors[name] = p
+	return nil
+}
+
+// getPostProcessors resolves a comma separated list of post-processor names
+func getPostProcessors(names string) ([]PostProcessor, error) {
+	postProcessorsLock.RLock()
+	defer postProcessorsLock.RUnlock()
+	var result []PostProcessor
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, ok := postProcessors[name]
+		if !ok {
+			return nil, fmt.Errorf("post-processor [%s] is not registered", name)
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package publish
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ReplayActionDrop = "Drop"
+	ReplayActionFail = "Fail"
+)
+
+// replayGuard limits the messages sent per message key with a token bucket per key: a key may send up to burst
+// messages at once, then one message per window. It keeps upstream events triggering a flow repeatedly from
+// flooding the topic with identical messages.
+type replayGuard struct {
+	window time.Duration
+	burst  float64
+	action string
+
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newReplayGuard(windowMs, burst int, action string) *replayGuard {
+	if windowMs <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &replayGuard{
+		window:    time.Duration(windowMs) * time.Millisecond,
+		burst:     float64(burst),
+		action:    action,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token of the key, it returns false if the key has none left
+func (g *replayGuard) allow(key string, now time.Time) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.sweep(now)
+	b, ok := g.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: g.burst, updated: now}
+		g.buckets[key] = b
+	}
+	g.refill(b, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (g *replayGuard) refill(b *tokenBucket, now time.Time) {
+	b.tokens += float64(now.Sub(b.updated)) / float64(g.window)
+	if b.tokens > g.burst {
+		b.tokens = g.burst
+	}
+	b.updated = now
+}
+
+// sweep forgets the keys whose bucket is full again, at most once per refill time of a bucket
+func (g *replayGuard) sweep(now time.Time) {
+	full := time.Duration(g.burst) * g.window
+	if now.Sub(g.lastSweep) < full {
+		return
+	}
+	g.lastSweep = now
+	for key, b := range g.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(g.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package publish
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewReplayGuard(t *testing.T) {
+	if g := newReplayGuard(0, 5, ReplayActionDrop); g != nil {
+		t.Errorf("newReplayGuard(0) = %+v, want nil when disabled", g)
+	}
+	if g := newReplayGuard(1000, 0, ReplayActionFail); g.burst != 1 || g.window != time.Second || g.action != ReplayActionFail {
+		t.Errorf("newReplayGuard(1000, 0) = %+v, want a burst of 1 per second", g)
+	}
+}
+
+func TestReplayGuardAllow(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name  string
+		burst int
+		// offsets from the start at which key "a" sends, and whether each send is allowed
+		sends []time.Duration
+		want  []bool
+	}{
+		{"single message per window", 1, []time.Duration{0, 0, time.Second}, []bool{true, false, true}},
+		{"burst", 3, []time.Duration{0, 0, 0, 0}, []bool{true, true, true, false}},
+		{"partial refill", 2, []time.Duration{0, 0, 500 * time.Millisecond, time.Second}, []bool{true, true, false, true}},
+		{"refill capped at the burst", 2, []time.Duration{0, 0, 10 * time.Second, 10 * time.Second, 10 * time.Second}, []bool{true, true, true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newReplayGuard(1000, tt.burst, ReplayActionDrop)
+			for i, offset := range tt.sends {
+				if got := g.allow("a", start.Add(offset)); got != tt.want[i] {
+					t.Errorf("send %d at %v allowed = %v, want %v", i, offset, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplayGuardKeys(t *testing.T) {
+	now := time.Now()
+	g := newReplayGuard(1000, 1, ReplayActionDrop)
+	if !g.allow("a", now) || g.allow("a", now) {
+		t.Fatal("second message of key a within the window allowed")
+	}
+	if !g.allow("b", now) {
+		t.Error("key b limited by the messages of key a")
+	}
+}
+
+func TestReplayGuardSweep(t *testing.T) {
+	start := time.Now()
+	g := newReplayGuard(1000, 2, ReplayActionDrop)
+	g.lastSweep = start
+	g.allow("a", start)
+	g.allow("b", start.Add(1500*time.Millisecond))
+	// the buckets refill within burst * window, a is full again while b is not
+	g.allow("c", start.Add(2*time.Second))
+	if _, ok := g.buckets["a"]; ok {
+		t.Error("full bucket of key a not forgotten")
+	}
+	if _, ok := g.buckets["b"]; !ok {
+		t.Error("bucket of key b forgotten before it refilled")
+	}
+	// at most one sweep per refill time
+	g.allow("d", start.Add(3500*time.Millisecond))
+	if _, ok := g.buckets["b"]; !ok {
+		t.Error("buckets swept again before the refill time elapsed")
+	}
+}
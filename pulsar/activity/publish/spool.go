@@ -0,0 +1,242 @@
+package publish
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// rejectedSuffix is appended to the path of the spool file to name the file receiving the rejected messages
+const rejectedSuffix = ".rejected"
+
+// spooledMessage is a message appended to the spool file, one JSON document per line
+type spooledMessage struct {
+	Key        string            `json:"key,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	Payload    []byte            `json:"payload"`
+	EventTime  time.Time         `json:"eventTime,omitempty"`
+	DeliverAt  time.Time         `json:"deliverAt,omitempty"`
+	SpooledAt  time.Time         `json:"spooledAt"`
+}
+
+// spool is a local append-only file holding the messages which could not be sent to the broker
+type spool struct {
+	path  string
+	topic string // label of the spool metrics
+	max   int
+
+	replaying sync.Mutex // held by the running replay
+	lock      sync.Mutex
+	loaded    bool
+	depth     int
+	oldest    time.Time
+}
+
+func newSpool(path, topic string, max int) *spool {
+	return &spool{path: path, topic: topic, max: max}
+}
+
+// size returns the number of spooled messages
+func (s *spool) size() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.load(); err != nil {
+		return 0
+	}
+	return s.depth
+}
+
+// load counts the messages left in the file by a previous run
+func (s *spool) load() error {
+	if s.loaded {
+		return nil
+	}
+	lines, err := s.read()
+	if err != nil {
+		return err
+	}
+	s.loaded = true
+	s.reset(lines)
+	return nil
+}
+
+func (s *spool) append(msg *pulsar.ProducerMessage) error {
+	now := time.Now()
+	data, err := json.Marshal(spooledMessage{Key: msg.Key, Properties: msg.Properties, Payload: msg.Payload, EventTime: msg.EventTime, DeliverAt: msg.DeliverAt, SpooledAt: now})
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = s.load(); err != nil {
+		return err
+	}
+	if s.max > 0 && s.depth >= s.max {
+		return fmt.Errorf("spool file [%s] is full (%d messages)", s.path, s.depth)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if s.depth == 0 {
+		s.oldest = now
+	}
+	s.depth++
+	s.updateMetrics()
+	return nil
+}
+
+// replay sends the spooled messages in order and removes them from the file. It stops at the first message which
+// could not be sent because the broker is unavailable, messages rejected by the broker are moved to the rejected file
+// so they do not block the spool. The file is not locked while sending, messages spooled meanwhile are kept for the
+// next replay. A replay started while another one is running returns immediately.
+func (s *spool) replay(send func(*pulsar.ProducerMessage) error) (sent, rejected int, err error) {
+	if !s.replaying.TryLock() {
+		return 0, 0, nil
+	}
+	defer s.replaying.Unlock()
+
+	s.lock.Lock()
+	lines, err := s.read()
+	s.lock.Unlock()
+	if err != nil || len(lines) == 0 {
+		return 0, 0, err
+	}
+
+	count := 0
+	for _, line := range lines {
+		var msg spooledMessage
+		if err = json.Unmarshal(line, &msg); err == nil {
+			err = send(&pulsar.ProducerMessage{Key: msg.Key, Properties: msg.Properties, Payload: msg.Payload, EventTime: msg.EventTime, DeliverAt: msg.DeliverAt})
+			if isOutage(err) {
+				break
+			}
+			if err == nil {
+				sent++
+			} else if err = s.reject(line); err != nil {
+				break
+			} else {
+				rejected++
+			}
+		}
+		// corrupted entries, e.g. a partial write on crash, are dropped
+		err = nil
+		count++
+	}
+	if count == 0 {
+		return 0, 0, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if wErr := s.truncate(count); wErr != nil {
+		return sent, rejected, wErr
+	}
+	return sent, rejected, err
+}
+
+// reject appends a message rejected by the broker to the rejected file, next to the spool file
+func (s *spool) reject(line []byte) error {
+	f, err := os.OpenFile(s.path+rejectedSuffix, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	rejectedMessages.WithLabelValues(s.topic).Inc()
+	return nil
+}
+
+// truncate removes the first count messages from the file
+func (s *spool) truncate(count int) error {
+	lines, err := s.read()
+	if err != nil {
+		return err
+	}
+	if count > len(lines) {
+		count = len(lines)
+	}
+	lines = lines[count:]
+	defer s.reset(lines)
+	if len(lines) == 0 {
+		return os.Remove(s.path)
+	}
+	tmp := s.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err = out.Write(append(line, '\n')); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *spool) read() ([][]byte, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// reset updates the depth and the age of the oldest message from the lines left in the file
+func (s *spool) reset(lines [][]byte) {
+	s.depth = len(lines)
+	s.oldest = time.Time{}
+	if len(lines) > 0 {
+		var first spooledMessage
+		if json.Unmarshal(lines[0], &first) == nil {
+			s.oldest = first.SpooledAt
+		}
+	}
+	s.updateMetrics()
+}
+
+func (s *spool) updateMetrics() {
+	spoolDepth.WithLabelValues(s.topic).Set(float64(s.depth))
+	age := 0.0
+	if s.depth > 0 && !s.oldest.IsZero() {
+		age = time.Since(s.oldest).Seconds()
+	}
+	spoolAge.WithLabelValues(s.topic).Set(age)
+}
@@ -0,0 +1,179 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// authProvider is implemented by the authentications of the pulsar client
+type authProvider interface {
+	Name() string
+	GetData() ([]byte, error)
+	GetTLSCertificate() (*tls.Certificate, error)
+}
+
+// AdminError is returned when the admin API responds with an error status
+type AdminError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e *AdminError) Error() string {
+	return fmt.Sprintf("pulsar admin API error %d: %s", e.StatusCode, e.Reason)
+}
+
+// AdminClient calls the Pulsar admin REST API, e.g. "/admin/v2/persistent/public/default/orders/stats", with the
+// credentials of the connection, so that admin activities (topic creation, stats, cursor reset) do not need a
+// connection of their own
+type AdminClient struct {
+	baseURL string
+	auth    pulsar.Authentication
+	client  *http.Client
+}
+
+func newAdminClient(s *Settings, ks *keystore, auth pulsar.Authentication, timeout time.Duration) (*AdminClient, error) {
+	if auth != nil {
+		if p, ok := auth.(authProvider); !ok || (p.Name() != "token" && p.Name() != "basic" && p.Name() != "tls") {
+			return nil, fmt.Errorf("the admin client does not support the %s authentication", s.Auth)
+		}
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: s.AllowInsecure}
+	pem, err := getAdminTrustCerts(s, ks)
+	if err != nil {
+		return nil, err
+	}
+	if pem != nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(pem)
+	}
+	if p, ok := auth.(authProvider); ok && p.Name() == "tls" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return p.GetTLSCertificate()
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &AdminClient{
+		baseURL: strings.TrimSuffix(s.AdminURL, "/"),
+		auth:    auth,
+		client:  &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+// getAdminTrustCerts returns the trusted certificates of the admin client, read from the keystore in memory or
+// from the caCert file
+func getAdminTrustCerts(s *Settings, ks *keystore) ([]byte, error) {
+	if !strings.HasPrefix(s.AdminURL, "https") || s.AllowInsecure {
+		return nil, nil
+	}
+	if ks != nil {
+		return ks.caCert, nil
+	}
+	if s.CaCert == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(s.CaCert)
+}
+
+// URL returns the base URL of the admin API
+func (c *AdminClient) URL() string {
+	return c.baseURL
+}
+
+// Get decodes the JSON response of the path into result, unless result is nil
+func (c *AdminClient) Get(path string, result interface{}) error {
+	return c.Do(http.MethodGet, path, nil, result)
+}
+
+// Put sends the body as JSON, unless it is nil
+func (c *AdminClient) Put(path string, body, result interface{}) error {
+	return c.Do(http.MethodPut, path, body, result)
+}
+
+// Post sends the body as JSON, unless it is nil
+func (c *AdminClient) Post(path string, body, result interface{}) error {
+	return c.Do(http.MethodPost, path, body, result)
+}
+
+// Delete deletes the resource of the path
+func (c *AdminClient) Delete(path string) error {
+	return c.Do(http.MethodDelete, path, nil, nil)
+}
+
+// Do calls the admin API, errors reported by the brokers are returned as *AdminError
+func (c *AdminClient) Do(method, path string, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+"/"+strings.TrimPrefix(path, "/"), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err = c.authorize(req); err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reason := struct {
+			Reason string `json:"reason"`
+		}{}
+		if json.Unmarshal(data, &reason) != nil || reason.Reason == "" {
+			reason.Reason = strings.TrimSpace(string(data))
+		}
+		return &AdminError{StatusCode: resp.StatusCode, Reason: reason.Reason}
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// authorize adds the credentials of the connection to the request, tokens are supplied by the authentication so
+// that refreshed OAuth2 tokens are used
+func (c *AdminClient) authorize(req *http.Request) error {
+	p, ok := c.auth.(authProvider)
+	if !ok {
+		return nil
+	}
+	switch p.Name() {
+	case "token":
+		token, err := p.GetData()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	case "basic":
+		credentials, err := p.GetData()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString(credentials))
+	}
+	return nil
+}
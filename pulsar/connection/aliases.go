@@ -0,0 +1,286 @@
+package connection
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsAliasTTL = 60
+)
+
+// hostAliases resolves the advertised addresses of brokers which are not resolvable from the runtime, e.g. with
+// split-horizon DNS or port-forwarded dev clusters. The pulsar client has no dialer hook, so the aliases are
+// answered by the resolver of the process for all connections.
+var hostAliases = struct {
+	lock      sync.RWMutex
+	ips       map[string][]net.IP
+	installed sync.Once
+}{ips: make(map[string][]net.IP)}
+
+// RegisterHostAlias resolves the host name to the IP addresses instead of looking it up in DNS, for the
+// connections of all pulsar clients of the process
+func RegisterHostAlias(host string, ips ...net.IP) error {
+	host = normalizeHostName(host)
+	if host == "" || len(ips) == 0 {
+		return fmt.Errorf("host alias requires a host name and an IP address")
+	}
+	hostAliases.lock.Lock()
+	hostAliases.ips[host] = ips
+	hostAliases.lock.Unlock()
+	hostAliases.installed.Do(installAliasResolver)
+	return nil
+}
+
+// registerHostAliases registers the aliases of the hostAliases setting, a comma separated list of host=ip, e.g.
+// "broker-1.internal=10.0.0.5,broker-2.internal=10.0.0.6". A host listed more than once resolves to all its IPs.
+func registerHostAliases(spec string) error {
+	aliases := make(map[string][]net.IP)
+	var hosts []string
+	for _, alias := range strings.Split(spec, ",") {
+		alias = strings.TrimSpace(alias)
+		if alias == "" {
+			continue
+		}
+		i := strings.Index(alias, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid host alias [%s]: expected host=ip", alias)
+		}
+		host, ip := strings.TrimSpace(alias[:i]), net.ParseIP(strings.TrimSpace(alias[i+1:]))
+		if ip == nil {
+			return fmt.Errorf("invalid host alias [%s]: expected host=ip", alias)
+		}
+		if _, ok := aliases[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		aliases[host] = append(aliases[host], ip)
+	}
+	for _, host := range hosts {
+		if err := RegisterHostAlias(host, aliases[host]...); err != nil {
+			return err
+		}
+		logger.Infof("Resolving host [%s] to %v", host, aliases[host])
+	}
+	return nil
+}
+
+func lookupHostAlias(host string) []net.IP {
+	hostAliases.lock.RLock()
+	defer hostAliases.lock.RUnlock()
+	return hostAliases.ips[host]
+}
+
+func normalizeHostName(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// installAliasResolver makes the Go resolver, which the pulsar client resolves broker addresses with, send its
+// queries through aliasConn
+func installAliasResolver() {
+	dial := net.DefaultResolver.Dial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	net.DefaultResolver.PreferGo = true
+	net.DefaultResolver.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		c := &aliasConn{stream: strings.HasPrefix(network, "tcp"), dial: func() (net.Conn, error) {
+			return dial(ctx, network, address)
+		}}
+		if c.stream {
+			return c, nil
+		}
+		// the resolver frames the messages depending on whether the connection is a packet connection
+		return &aliasPacketConn{c}, nil
+	}
+}
+
+// aliasConn answers the DNS queries for aliased hosts and passes the others on to the name server, which is only
+// dialed once a query needs it
+type aliasConn struct {
+	stream bool
+	dial   func() (net.Conn, error)
+
+	lock     sync.Mutex
+	conn     net.Conn
+	deadline time.Time
+	written  []byte
+	answers  []byte
+}
+
+func (c *aliasConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	query := b
+	if c.stream {
+		// messages over TCP are prefixed with their length
+		c.written = append(c.written, b...)
+		if len(c.written) < 2 || len(c.written) < 2+int(binary.BigEndian.Uint16(c.written)) {
+			return len(b), nil
+		}
+		query, c.written = c.written, nil
+	}
+	if c.conn == nil {
+		msg := query
+		if c.stream {
+			msg = query[2:]
+		}
+		if answer := answerHostAlias(msg); answer != nil {
+			if c.stream {
+				c.answers = append(c.answers, byte(len(answer)>>8), byte(len(answer)))
+			}
+			c.answers = append(c.answers, answer...)
+			return len(b), nil
+		}
+		conn, err := c.dial()
+		if err != nil {
+			return 0, err
+		}
+		if !c.deadline.IsZero() {
+			_ = conn.SetDeadline(c.deadline)
+		}
+		c.conn = conn
+	}
+	if _, err := c.conn.Write(query); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *aliasConn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	if len(c.answers) > 0 {
+		defer c.lock.Unlock()
+		n := copy(b, c.answers)
+		if c.stream {
+			c.answers = c.answers[n:]
+		} else {
+			// one datagram per read
+			c.answers = nil
+		}
+		return n, nil
+	}
+	conn := c.conn
+	c.lock.Unlock()
+	if conn == nil {
+		return 0, io.EOF
+	}
+	return conn.Read(b)
+}
+
+func (c *aliasConn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *aliasConn) LocalAddr() net.Addr {
+	return &net.IPAddr{}
+}
+
+func (c *aliasConn) RemoteAddr() net.Addr {
+	return &net.IPAddr{}
+}
+
+func (c *aliasConn) SetDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deadline = t
+	if c.conn != nil {
+		return c.conn.SetDeadline(t)
+	}
+	return nil
+}
+
+func (c *aliasConn) SetReadDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+func (c *aliasConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// answerHostAlias returns the response to an A or AAAA query for an aliased host, nil if the query is for
+// another host
+func answerHostAlias(query []byte) []byte {
+	if len(query) < 12 || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return nil
+	}
+	// the question: labels terminated by an empty label, then type and class
+	var labels []string
+	i := 12
+	for {
+		if i >= len(query) {
+			return nil
+		}
+		length := int(query[i])
+		if length == 0 {
+			i++
+			break
+		}
+		if length > 63 || i+1+length > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+length]))
+		i += 1 + length
+	}
+	if i+4 > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[i:])
+	question := query[12 : i+4]
+	ips := lookupHostAlias(normalizeHostName(strings.Join(labels, ".")))
+	if ips == nil {
+		return nil
+	}
+	var records [][]byte
+	for _, ip := range ips {
+		switch {
+		case qtype == dnsTypeA && ip.To4() != nil:
+			records = append(records, ip.To4())
+		case qtype == dnsTypeAAAA && ip.To4() == nil:
+			records = append(records, ip.To16())
+		}
+	}
+
+	flags := binary.BigEndian.Uint16(query[2:])
+	answer := make([]byte, 12, 12+len(question)+len(records)*28)
+	copy(answer, query[:2])
+	// response, recursion desired as queried, recursion available, no error
+	binary.BigEndian.PutUint16(answer[2:], 0x8080|flags&0x0100)
+	binary.BigEndian.PutUint16(answer[4:], 1)
+	binary.BigEndian.PutUint16(answer[6:], uint16(len(records)))
+	answer = append(answer, question...)
+	for _, rdata := range records {
+		// the name points to the question
+		answer = append(answer, 0xc0, 12, byte(qtype>>8), byte(qtype), 0, 1)
+		answer = append(answer, 0, 0, 0, dnsAliasTTL)
+		answer = append(answer, byte(len(rdata)>>8), byte(len(rdata)))
+		answer = append(answer, rdata...)
+	}
+	return answer
+}
+
+type aliasPacketConn struct {
+	*aliasConn
+}
+
+func (c *aliasPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *aliasPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
@@ -0,0 +1,32 @@
+package connection
+
+import (
+	"sync"
+	"time"
+)
+
+// Backpressure is raised by producers whose pending queue is full and honoured by
+// consumers, which slow down their receive rate while it is active
+type Backpressure struct {
+	lock  sync.RWMutex
+	until time.Time
+}
+
+// Signal asks consumers to hold off receiving messages for the given duration
+func (b *Backpressure) Signal(d time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if until := time.Now().Add(d); until.After(b.until) {
+		b.until = until
+	}
+}
+
+// Delay returns how long consumers should still hold off, zero if there is no backpressure
+func (b *Backpressure) Delay() time.Duration {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if d := time.Until(b.until); d > 0 {
+		return d
+	}
+	return 0
+}
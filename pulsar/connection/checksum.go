@@ -0,0 +1,91 @@
+package connection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+)
+
+// PropertyPayloadChecksum holds the checksum of the payload stamped by the publisher, e.g. "CRC32C:5c1a7f3e"
+const PropertyPayloadChecksum = "PAYLOAD_CHECKSUM"
+
+// Checksum computes the checksum of a payload as a string
+type Checksum func(payload []byte) string
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	checksumsLock sync.RWMutex
+	checksums     = map[string]Checksum{
+		"CRC32C": func(payload []byte) string {
+			return fmt.Sprintf("%08x", crc32.Checksum(payload, crc32cTable))
+		},
+		"SHA256": func(payload []byte) string {
+			sum := sha256.Sum256(payload)
+			return hex.EncodeToString(sum[:])
+		},
+	}
+)
+
+// RegisterChecksum registers a checksum algorithm which publishers can reference by name in their checksum setting,
+// e.g. "XXH64". Registering an algorithm with an existing name replaces it.
+func RegisterChecksum(name string, checksum Checksum) error {
+	if name == "" || strings.Contains(name, ":") || checksum == nil {
+		return fmt.Errorf("a name without colon and a checksum are required")
+	}
+	checksumsLock.Lock()
+	defer checksumsLock.Unlock()
+	checksums[name] = checksum
+	return nil
+}
+
+func getChecksum(name string) (Checksum, error) {
+	checksumsLock.RLock()
+	defer checksumsLock.RUnlock()
+	checksum, ok := checksums[name]
+	if !ok {
+		return nil, fmt.Errorf("checksum algorithm [%s] is not registered", name)
+	}
+	return checksum, nil
+}
+
+// ValidateChecksum returns an error if the checksum algorithm is not registered, so that misconfigurations are
+// reported at startup
+func ValidateChecksum(name string) error {
+	_, err := getChecksum(name)
+	return err
+}
+
+// StampChecksum sets the checksum property of the payload computed with the given algorithm
+func StampChecksum(name string, payload []byte, properties map[string]string) error {
+	checksum, err := getChecksum(name)
+	if err != nil {
+		return err
+	}
+	properties[PropertyPayloadChecksum] = name + ":" + checksum(payload)
+	return nil
+}
+
+// VerifyChecksum verifies the payload against its checksum property. It returns false if the message has none,
+// and an error if the checksum does not match or its algorithm is not registered.
+func VerifyChecksum(payload []byte, properties map[string]string) (bool, error) {
+	value, ok := properties[PropertyPayloadChecksum]
+	if !ok {
+		return false, nil
+	}
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return true, fmt.Errorf("malformed checksum [%s]", value)
+	}
+	checksum, err := getChecksum(value[:i])
+	if err != nil {
+		return true, err
+	}
+	if actual := checksum(payload); actual != value[i+1:] {
+		return true, fmt.Errorf("payload %s checksum %s does not match %s", value[:i], actual, value[i+1:])
+	}
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package connection
+
+import (
+	"sync"
+)
+
+// CircuitState is the state of a circuit breaker guarding a downstream system
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "Open"
+	case CircuitHalfOpen:
+		return "HalfOpen"
+	default:
+		return "Closed"
+	}
+}
+
+type circuit struct {
+	state   CircuitState
+	changed chan struct{}
+}
+
+var (
+	circuitsLock sync.Mutex
+	circuits     = make(map[string]*circuit)
+)
+
+func getCircuit(name string) *circuit {
+	c, ok := circuits[name]
+	if !ok {
+		c = &circuit{state: CircuitClosed, changed: make(chan struct{})}
+		circuits[name] = c
+	}
+	return c
+}
+
+// SetCircuitState publishes the state of the named circuit breaker. Activities or app code guarding
+// a downstream system report state changes here, handlers referencing the breaker pause while it is open.
+func SetCircuitState(name string, state CircuitState) {
+	circuitsLock.Lock()
+	defer circuitsLock.Unlock()
+	c := getCircuit(name)
+	if c.state == state {
+		return
+	}
+	logger.Infof("Circuit breaker [%s] changed from %s to %s", name, c.state, state)
+	c.state = state
+	// wake up all watchers
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// GetCircuitState returns the current state of the named circuit breaker along with a channel which is
+// closed on the next state change
+func GetCircuitState(name string) (CircuitState, <-chan struct{}) {
+	circuitsLock.Lock()
+	defer circuitsLock.Unlock()
+	c := getCircuit(name)
+	return c.state, c.changed
+}
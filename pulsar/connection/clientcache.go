@@ -0,0 +1,156 @@
+package connection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// nonClientSettings do not affect the pulsar client, connections which only differ in these share a client
+var nonClientSettings = map[string]bool{
+	"name":                 true,
+	"description":          true,
+	"maskProperties":       true,
+	"maskPaths":            true,
+	"memoryLimitBytes":     true,
+	"qualifyTopics":        true,
+	"healthCheckTopic":     true,
+	"propagateContext":     true,
+	"propertyEncoding":     true,
+	"labels":               true,
+	"maxRetries":           true,
+	"initialBackoff":       true,
+	"maxBackoff":           true,
+	"exposeIdentity":       true,
+	"metricDropPartitions": true,
+	"metricTopicPatterns":  true,
+	"metricTopicLimit":     true,
+}
+
+// sharedClient owns the pulsar client of all connections with identical client settings, along with the
+// reconnect and the resources of its authentication. It is started by the first connection started and
+// stopped by the last one stopped, it is closed once all connections released it.
+type sharedClient struct {
+	key       string
+	reconnect *reconnector
+	failover  *urlFailover
+
+	lock       sync.Mutex
+	clientOpts pulsar.ClientOptions
+	tokens     *tokenManager
+	keystore   *keystore
+	admin      *AdminClient
+	forwarders []*proxyForwarder
+	refs       int
+	started    int
+}
+
+var clientCache = struct {
+	lock    sync.Mutex
+	clients map[string]*sharedClient
+}{clients: make(map[string]*sharedClient)}
+
+// clientKey identifies the client settings of a connection, secrets are hashed along with the other settings
+func clientKey(settings map[string]interface{}) (string, error) {
+	values := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		if !nonClientSettings[k] {
+			values[k] = v
+		}
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// acquireClient returns the client cached for the key, the client is created and cached if there is none
+func acquireClient(key string, create func() (*sharedClient, error)) (*sharedClient, error) {
+	clientCache.lock.Lock()
+	defer clientCache.lock.Unlock()
+	if c, ok := clientCache.clients[key]; ok {
+		c.lock.Lock()
+		c.refs++
+		logger.Debugf("Sharing pulsar client with %d other connection(s) with identical settings", c.refs-1)
+		c.lock.Unlock()
+		return c, nil
+	}
+	c, err := create()
+	if err != nil {
+		return nil, err
+	}
+	c.key = key
+	c.refs = 1
+	clientCache.clients[key] = c
+	return c, nil
+}
+
+// adminClient returns the admin client, nil if no adminUrl is configured
+func (c *sharedClient) adminClient() *AdminClient {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.admin
+}
+
+// uncache stops sharing the client with connections created afterwards, e.g. once its credentials were
+// refreshed and no longer match the settings it was cached for
+func (c *sharedClient) uncache() {
+	clientCache.lock.Lock()
+	defer clientCache.lock.Unlock()
+	if clientCache.clients[c.key] == c {
+		delete(clientCache.clients, c.key)
+	}
+}
+
+// start returns true for the first connection started, which connects the client
+func (c *sharedClient) start() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.started++
+	return c.started == 1
+}
+
+// startFailed undoes start when the client could not be connected
+func (c *sharedClient) startFailed() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.started--
+}
+
+// stop stops reconnecting once the last connection is stopped. The authentication resources are kept for the
+// next start, they are freed when the client is released.
+func (c *sharedClient) stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.started > 0 {
+		c.started--
+	}
+	if c.started > 0 {
+		return
+	}
+	c.reconnect.close()
+}
+
+// release closes the client once the last connection released it and all its producers and consumers are closed
+func (c *sharedClient) release() {
+	c.lock.Lock()
+	c.refs--
+	refs, ks, tokens, forwarders := c.refs, c.keystore, c.tokens, c.forwarders
+	c.lock.Unlock()
+	if refs > 0 {
+		return
+	}
+	c.uncache()
+	c.reconnect.closeWhenReleased(func() {
+		closeProxyForwarders(forwarders)
+		if tokens != nil {
+			tokens.Close()
+		}
+		ks.close()
+	})
+}
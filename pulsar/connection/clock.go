@@ -0,0 +1,86 @@
+package connection
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EnvClockSkewTolerance sets the tolerated difference (in milliseconds) between the clock of the host and the clocks
+// of the brokers and of the other hosts, whose timestamps are compared with the time of the host
+const EnvClockSkewTolerance = "FLOGO_PULSAR_CLOCK_SKEW_TOLERANCE"
+
+// Clock is the time source of the time based checks, i.e. delayed delivery, event time validation and message age
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	clockLock     sync.RWMutex
+	clock         Clock = systemClock{}
+	skewTolerance       = getClockSkewTolerance()
+)
+
+// SetClock replaces the time source, e.g. with a fake clock in tests or a clock synchronized with a time service.
+// A nil clock restores the system clock.
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	clock = c
+}
+
+// SetClockSkewTolerance overrides the tolerance set with FLOGO_PULSAR_CLOCK_SKEW_TOLERANCE
+func SetClockSkewTolerance(tolerance time.Duration) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	skewTolerance = tolerance
+}
+
+// Now returns the current time of the clock
+func Now() time.Time {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return clock.Now()
+}
+
+// ClockSkewTolerance returns the tolerated clock difference, 0 when not set
+func ClockSkewTolerance() time.Duration {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return skewTolerance
+}
+
+// Age returns the time elapsed since a timestamp of another host. Timestamps ahead of the clock within the skew
+// tolerance have an age of 0.
+func Age(t time.Time) time.Duration {
+	age := Now().Sub(t)
+	if age < 0 && -age <= ClockSkewTolerance() {
+		return 0
+	}
+	return age
+}
+
+// InFuture returns true if a timestamp of another host is ahead of the clock by more than the skew tolerance
+func InFuture(t time.Time) bool {
+	return t.Sub(Now()) > ClockSkewTolerance()
+}
+
+func getClockSkewTolerance() time.Duration {
+	if v := os.Getenv(EnvClockSkewTolerance); v != "" {
+		if ms, err := strconv.Atoi(v); err == nil && ms >= 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		logger.Warnf("Invalid value [%s] for %s, ignoring it", v, EnvClockSkewTolerance)
+	}
+	return 0
+}
@@ -2,7 +2,6 @@ package connection
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -38,13 +37,56 @@ type Settings struct {
 	PrivateKey           string            `md:"privateKey"`
 	Scope                string            `md:"scope"`
 	IssuerUrl            string            `md:"issuerUrl"`
+	ListenerName         string            `md:"listenerName"`
+	ValidateHostname     bool              `md:"validateHostname"`
+	ClientId             string            `md:"clientId"`
+	ClientSecret         string            `md:"clientSecret"`
+	TokenRefreshMargin   int               `md:"tokenRefreshMargin"`
+	MaskProperties       string            `md:"maskProperties"`
+	MaskPaths            string            `md:"maskPaths"`
+	Username             string            `md:"username"`
+	Password             string            `md:"password"`
+	MaxConnsPerBroker    int               `md:"maxConnectionsPerBroker"`
+	MemoryLimitBytes     int64             `md:"memoryLimitBytes"`
+	KeepAliveInterval    int               `md:"keepAliveInterval"`
+	ReconnectBackoff     int               `md:"reconnectBackoff"`
+	ReconnectMaxBackoff  int               `md:"reconnectMaxBackoff"`
+	ReconnectJitter      int               `md:"reconnectJitter"`
+	ReconnectMaxAttempts int               `md:"reconnectMaxAttempts"`
+	QualifyTopics        bool              `md:"qualifyTopics"`
+	HealthCheckTopic     string            `md:"healthCheckTopic"`
+	PropagateContext     string            `md:"propagateContext"`
+	KeyPassword          string            `md:"keyPassword"`
+	PropertyEncoding     string            `md:"propertyEncoding"`
+	AdminURL             string            `md:"adminUrl"`
+	ProxyURL             string            `md:"proxyUrl"`
+	ProxyUser            string            `md:"proxyUser"`
+	ProxyPassword        string            `md:"proxyPassword"`
+	HostAliases          string            `md:"hostAliases"`
+	Labels               map[string]string `md:"labels"`
+	MaxRetries           int               `md:"maxRetries"`
+	InitialBackoff       int               `md:"initialBackoff"`
+	MaxBackoff           int               `md:"maxBackoff"`
+	ExposeIdentity       bool              `md:"exposeIdentity"`
+	MetricDropPartitions bool              `md:"metricDropPartitions"`
+	MetricTopicPatterns  string            `md:"metricTopicPatterns"`
+	MetricTopicLimit     int               `md:"metricTopicLimit"`
 }
 
 type PulsarConnection struct {
-	client      pulsar.Client
-	keystoreDir string
-	clientOpts  pulsar.ClientOptions
-	connected   bool
+	client       *sharedClient
+	backpressure *Backpressure
+	masker       *Masker
+	memory       *MemoryLimiter
+	qualify      bool
+	healthTopic  string
+	propagation  *ContextPropagation
+	properties   *PropertyCodec
+	labels       *Labels
+	retry        operationRetry
+	identity     bool
+	metricTopics *MetricTopics
+	started      bool
 }
 
 type Factory struct {
@@ -56,40 +98,55 @@ func (*Factory) Type() string {
 }
 
 func (*Factory) NewManager(settings map[string]interface{}) (connection.Manager, error) {
+	settings, err := expandEnv(settings)
+	if err != nil {
+		return nil, err
+	}
 	s := &Settings{}
-	err := metadata.MapToStruct(settings, s, true)
+	err = metadata.MapToStruct(settings, s, true)
 	if err != nil {
 		return nil, err
 	}
+	if err = resolveSecrets(s); err != nil {
+		return nil, err
+	}
 
-	var auth pulsar.Authentication
-	keystoreDir, err := createTempKeystoreDir(s)
+	switch s.PropertyEncoding {
+	case "", PropertyEncodingFlat, PropertyEncodingJSON, PropertyEncodingPrefixed:
+	default:
+		return nil, fmt.Errorf("unsupported property encoding [%s]", s.PropertyEncoding)
+	}
+	if err = registerHostAliases(s.HostAliases); err != nil {
+		return nil, err
+	}
+	labels, err := NewLabels(s.Labels)
+	if err != nil {
+		return nil, err
+	}
+	metricTopics, err := NewMetricTopics(s.MetricDropPartitions, s.MetricTopicPatterns, s.MetricTopicLimit)
 	if err != nil {
 		return nil, err
 	}
-	if s.Auth == "" {
 
-	} else {
-		if s.Auth == "TLS" {
-			auth, err = getTLSAuthentication(keystoreDir, s)
-			if err != nil {
-				return nil, err
-			}
-		} else if s.Auth == "JWT" {
-			auth, err = getJWTAuthentication(s)
-			if err != nil {
-				return nil, err
-			}
-		} else if s.Auth == "Athenz" {
-			auth = getAthenzAuthentication(s)
-		} else if s.Auth == "OAuth2" {
-			auth = getOAuth2Authentication(s, keystoreDir)
-			if auth == nil {
-				return nil, fmt.Errorf("Authentication error")
-			}
-		}
+	// connections with identical client settings share one client
+	key, err := clientKey(settings)
+	if err != nil {
+		return nil, err
 	}
+	client, err := acquireClient(key, func() (*sharedClient, error) {
+		return newSharedClient(s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pulsarCnn := &PulsarConnection{client: client, backpressure: &Backpressure{}, masker: NewMasker(s.MaskProperties, s.MaskPaths), memory: NewMemoryLimiter(s.MemoryLimitBytes), qualify: s.QualifyTopics, healthTopic: s.HealthCheckTopic, propagation: NewContextPropagation(s.PropagateContext), properties: NewPropertyCodec(s.PropertyEncoding), labels: labels, retry: newOperationRetry(s.MaxRetries, s.InitialBackoff, s.MaxBackoff), identity: s.ExposeIdentity, metricTopics: metricTopics}
+
+	return pulsarCnn, nil
+
+}
 
+func newSharedClient(s *Settings) (*sharedClient, error) {
 	engineLogLevel = os.Getenv(log.EnvKeyLogLevel)
 
 	customLogger := zapLoggerWrapper{logger: logger}
@@ -106,29 +163,75 @@ func (*Factory) NewManager(settings map[string]interface{}) (connection.Manager,
 		opTimeout = 30
 	}
 
+	failover := newURLFailover(s.URL)
+	if failover.size() == 0 {
+		return nil, fmt.Errorf("no service URL specified")
+	}
+	var dialer *proxyDialer
+	if s.ProxyURL != "" {
+		if s.ValidateHostname {
+			return nil, fmt.Errorf("validateHostname is not supported with a proxy, the client connects to a local port")
+		}
+		var err error
+		if dialer, err = newProxyDialer(s.ProxyURL, s.ProxyUser, s.ProxyPassword, time.Duration(connTimeout)*time.Second); err != nil {
+			return nil, err
+		}
+	}
+
+	// the keystore watcher and the token manager run in the background, they are closed if the client is not created
+	ks, err := newKeystore(s)
+	if err != nil {
+		return nil, err
+	}
+	auth, tokens, err := getAuthentication(s, ks)
+	if err != nil {
+		ks.close()
+		return nil, err
+	}
+	closeAuth := func() {
+		if tokens != nil {
+			tokens.Close()
+		}
+		ks.close()
+	}
+
+	var forwarders []*proxyForwarder
+	if dialer != nil {
+		urls, f, err := startProxyForwarders(failover.serviceURLs(), dialer)
+		if err != nil {
+			closeAuth()
+			return nil, err
+		}
+		failover, forwarders = newURLFailover(strings.Join(urls, ",")), f
+	}
+
 	clientOpts := pulsar.ClientOptions{
-		URL:                        s.URL,
+		URL:                        failover.current(),
 		Authentication:             auth,
-		TLSValidateHostname:        false,
+		TLSValidateHostname:        s.ValidateHostname,
 		TLSAllowInsecureConnection: s.AllowInsecure,
 		Logger:                     &customLogger,
 		ConnectionTimeout:          time.Duration(connTimeout) * time.Second,
 		OperationTimeout:           time.Duration(opTimeout) * time.Second,
+		ListenerName:               s.ListenerName,
+		MaxConnectionsPerBroker:    s.MaxConnsPerBroker,
+		KeepAliveInterval:          time.Duration(s.KeepAliveInterval) * time.Second,
 	}
 
-	if strings.Index(s.URL, "pulsar+ssl") >= 0 {
-		if keystoreDir == "" {
-			clientOpts.TLSTrustCertsFilePath = s.CaCert
-		} else if !s.AllowInsecure {
-			clientOpts.TLSTrustCertsFilePath = keystoreDir + string(os.PathSeparator) + "cacert.pem"
-		}
-	}
+	clientOpts.TLSTrustCertsFilePath = getTLSTrustCertsFilePath(s, ks)
 	logger.Debugf("pulsar.ClientOptions: %v", clientOpts)
 
-	pulsarCnn := &PulsarConnection{keystoreDir: keystoreDir, clientOpts: clientOpts}
-
-	return pulsarCnn, nil
+	var admin *AdminClient
+	if s.AdminURL != "" {
+		if admin, err = newAdminClient(s, ks, auth, clientOpts.OperationTimeout); err != nil {
+			closeProxyForwarders(forwarders)
+			closeAuth()
+			return nil, err
+		}
+	}
 
+	reconnect := newReconnector(clientOpts, failover, newBackoff(s.ReconnectBackoff, s.ReconnectMaxBackoff, s.ReconnectJitter), s.ReconnectMaxAttempts)
+	return &sharedClient{keystore: ks, clientOpts: clientOpts, reconnect: reconnect, failover: failover, tokens: tokens, admin: admin, forwarders: forwarders}, nil
 }
 
 func (p *PulsarConnection) Type() string {
@@ -137,185 +240,255 @@ func (p *PulsarConnection) Type() string {
 }
 
 func (p *PulsarConnection) GetConnection() interface{} {
+	client, _ := p.client.reconnect.Client()
+	p.client.lock.Lock()
+	clientOpts := p.client.clientOpts
+	p.client.lock.Unlock()
 	return PulsarConnManager{
-		Client:     p.client,
-		ClientOpts: p.clientOpts,
-		Connected:  p.connected,
-		Lock:       &sync.RWMutex{}}
+		Client:           client,
+		ClientOpts:       clientOpts,
+		Connected:        client != nil,
+		Lock:             &sync.RWMutex{},
+		Backpressure:     p.backpressure,
+		Masker:           p.masker,
+		Memory:           p.memory,
+		QualifyTopics:    p.qualify,
+		HealthCheckTopic: p.healthTopic,
+		Propagation:      p.propagation,
+		Labels:           p.labels,
+		ExposeIdentity:   p.identity,
+		MetricTopics:     p.metricTopics,
+		retry:            p.retry,
+		PropertyCodec:    p.properties,
+		failover:         p.client.failover,
+		reconnect:        p.client.reconnect,
+		shared:           p.client}
 }
 
 func (p *PulsarConnection) Stop() error {
 	logger.Debug("Stop Pulsar Connection")
-	if p.keystoreDir != "" {
-		os.RemoveAll(p.keystoreDir)
+	if p.started {
+		p.started = false
+		// the last connection stopped flushes and closes the producers left by the activities
+		connectionStopped()
 	}
+	p.client.stop()
 	return nil
 }
 
 func (p *PulsarConnection) Start() error {
-	logger.Info("attempting to create client")
-	type ClientInfo struct {
-		client pulsar.Client
-		err    error
-	}
-	infoChan := make(chan ClientInfo)
-	go func() {
-		client, err := pulsar.NewClient(p.clientOpts)
-		infoChan <- ClientInfo{client: client, err: err}
-	}()
-
-	var data ClientInfo
-	select {
-	case data = <-infoChan:
-		p.client = data.client
-	case <-time.After(30 * time.Second):
-		data.client = nil
-		data.err = fmt.Errorf("client creation has timedout after 30 seconds")
+	if !p.client.start() {
+		// started by another connection with identical client settings
+		p.setStarted()
+		return nil
 	}
-	if data.err != nil {
-		if strings.Contains(strings.ToLower(data.err.Error()), "authentication error") || strings.Contains(strings.ToLower(data.err.Error()), "empty token credentials") || strings.Contains(strings.ToLower(data.err.Error()), "missing configuration for token auth") || strings.Contains(strings.ToLower(data.err.Error()), "unsupported authentication type") {
-			return data.err
+	p.client.reconnect.open()
+	logger.Info("attempting to create client")
+	_, err := p.client.reconnect.connect()
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "authentication error") || strings.Contains(strings.ToLower(err.Error()), "empty token credentials") || strings.Contains(strings.ToLower(err.Error()), "missing configuration for token auth") || strings.Contains(strings.ToLower(err.Error()), "unsupported authentication type") {
+			p.client.startFailed()
+			return err
 		} else {
-			logger.Warnf("%v", data.err)
+			logger.Warnf("%v", err)
+			// keep trying in the background, triggers and activities are notified once connected
+			p.client.reconnect.Failed(nil)
 		}
 	} else {
-		p.connected = true
 		logger.Info("new client created")
 	}
-
+	p.setStarted()
 	return nil
 }
 
-// ReleaseConnection clean up connection resources
+func (p *PulsarConnection) setStarted() {
+	if !p.started {
+		p.started = true
+		connectionStarted()
+	}
+}
+
+// ReleaseConnection clean up connection resources. The client is closed once all connections sharing it were
+// released and all producers and consumers created with it are closed.
 func (p *PulsarConnection) ReleaseConnection(connection interface{}) {
 	logger.Debug("ReleaseConnection")
-	if p.keystoreDir != "" {
-		os.RemoveAll(p.keystoreDir)
-	}
+	p.client.release()
 }
 
-func getAthenzAuthentication(s *Settings) pulsar.Authentication {
-	if len(s.AthenzAuthentication) != 0 {
-		return pulsar.NewAuthenticationAthenz(s.AthenzAuthentication)
+// Refresh rebuilds the authentication from the given connection settings, e.g. with renewed certificates, a new
+// JWT or OAuth2 key, and swaps the client without restarting the engine. Triggers and activities re-create their
+// producers and consumers with the new client, the previous client is closed once they are all closed.
+// Connections sharing the client are refreshed along with it.
+func (p *PulsarConnection) Refresh(settings map[string]interface{}) error {
+	settings, err := expandEnv(settings)
+	if err != nil {
+		return err
+	}
+	s := &Settings{}
+	err = metadata.MapToStruct(settings, s, true)
+	if err != nil {
+		return err
+	}
+	if err = resolveSecrets(s); err != nil {
+		return err
+	}
+	if err = registerHostAliases(s.HostAliases); err != nil {
+		return err
+	}
+	ks, err := newKeystore(s)
+	if err != nil {
+		return err
+	}
+	auth, tokens, err := getAuthentication(s, ks)
+	if err != nil {
+		ks.close()
+		return err
 	}
-	return nil
-}
 
-func getOAuth2Authentication(s *Settings, keystoreDir string) pulsar.Authentication {
-	return pulsar.NewAuthenticationOAuth2(map[string]string{
-		"type":       "client_credentials",
-		"privateKey": keystoreDir + string(os.PathSeparator) + "privateKey.json",
-		"issuerUrl":  s.IssuerUrl,
-		"audience":   s.Audience,
-		"scope":      s.Scope,
+	c := p.client
+	c.lock.Lock()
+	clientOpts := c.clientOpts
+	c.lock.Unlock()
+	clientOpts.Authentication = auth
+	clientOpts.TLSValidateHostname = s.ValidateHostname
+	clientOpts.TLSAllowInsecureConnection = s.AllowInsecure
+	clientOpts.TLSTrustCertsFilePath = getTLSTrustCertsFilePath(s, ks)
+	var admin *AdminClient
+	if s.AdminURL != "" {
+		if admin, err = newAdminClient(s, ks, auth, clientOpts.OperationTimeout); err != nil {
+			if tokens != nil {
+				tokens.Close()
+			}
+			ks.close()
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	previousTokens, previousKeystore := c.tokens, c.keystore
+	c.lock.Unlock()
+	// the certificates of the previous client are kept until it is closed
+	err = c.reconnect.swap(clientOpts, func() {
+		if previousTokens != nil {
+			previousTokens.Close()
+		}
+		previousKeystore.close()
 	})
+	if err != nil {
+		if tokens != nil {
+			tokens.Close()
+		}
+		ks.close()
+		recordAuthRefresh("credentials", err)
+		return fmt.Errorf("unable to refresh pulsar connection: %v", err)
+	}
+	recordAuthRefresh("credentials", nil)
+
+	c.lock.Lock()
+	c.clientOpts, c.tokens, c.keystore, c.admin = clientOpts, tokens, ks, admin
+	c.lock.Unlock()
+	// the client no longer matches the settings it was shared for
+	c.uncache()
+	logger.Info("Pulsar connection refreshed")
+	return nil
 }
 
-func getTLSAuthentication(keystoreDir string, s *Settings) (auth pulsar.Authentication, err error) {
-	if keystoreDir == "" {
-		auth = pulsar.NewAuthenticationTLS(s.CertFile, s.KeyFile)
-	} else {
-		auth = pulsar.NewAuthenticationTLS(keystoreDir+string(os.PathSeparator)+"certfile.pem",
-			keystoreDir+string(os.PathSeparator)+"keyfile.pem")
+// getAuthentication creates the authentication configured by the auth setting, with the certificates and keys of
+// the keystore if the file settings are given as content
+func getAuthentication(s *Settings, ks *keystore) (auth pulsar.Authentication, tokens *tokenManager, err error) {
+	switch s.Auth {
+	case "TLS":
+		auth, err = getTLSAuthentication(ks, s)
+	case "JWT":
+		auth, err = getJWTAuthentication(s)
+	case "Basic":
+		auth, err = getBasicAuthentication(s)
+		if err != nil {
+			err = fmt.Errorf("Authentication error: %v", err)
+		}
+	case "Athenz":
+		auth = getAthenzAuthentication(s)
+	case "OAuth2":
+		auth, tokens, err = getOAuth2Authentication(s, ks)
+		if err != nil {
+			err = fmt.Errorf("Authentication error: %v", err)
+		}
 	}
-	return
+	return auth, tokens, err
 }
-func getJWTAuthentication(s *Settings) (auth pulsar.Authentication, err error) {
-	auth = pulsar.NewAuthenticationToken(s.JWT)
-	return
+
+func getTLSTrustCertsFilePath(s *Settings, ks *keystore) string {
+	if strings.Index(s.URL, "pulsar+ssl") < 0 {
+		return ""
+	}
+	if ks == nil {
+		return s.CaCert
+	} else if !s.AllowInsecure {
+		return ks.caCertFile()
+	}
+	return ""
 }
 
-func createTempKeystoreDir(s *Settings) (keystoreDir string, err error) {
-	var certObj, keyObj, cacertObj, prikeyObj map[string]interface{}
-	var flogoFileValue = true
-	logger.Debugf("createTempCertificateDir:  %v", *s)
-	if s.CertFile != "" || s.KeyFile != "" || s.CaCert != "" || s.PrivateKey != "" {
-		keystoreDir, err = ioutil.TempDir(os.TempDir(), "pulsar")
-		if err != nil {
-			return
-		}
-	} else {
-		return "", nil
+func getAthenzAuthentication(s *Settings) pulsar.Authentication {
+	if len(s.AthenzAuthentication) != 0 {
+		return pulsar.NewAuthenticationAthenz(s.AthenzAuthentication)
 	}
-	if s.CaCert != "" {
-		err = json.Unmarshal([]byte(s.CaCert), &cacertObj)
-		if err != nil { //if its not a json string, then its an OSS file spec
-			flogoFileValue = false
+	return nil
+}
+
+// getOAuth2Authentication supplies the client with tokens cached and refreshed ahead of expiry by a token manager.
+// When clientId and clientSecret are not set, the client credentials are read from the privateKey setting.
+func getOAuth2Authentication(s *Settings, ks *keystore) (pulsar.Authentication, *tokenManager, error) {
+	var key []byte
+	if s.ClientId == "" || s.ClientSecret == "" {
+		if ks != nil {
+			key = ks.privateKey
 		} else {
-			var certBytes []byte
-			certBytes, err = getBytesFromFileSetting(cacertObj)
-			if err != nil {
-				return
-			}
-			if certBytes != nil {
-				err = ioutil.WriteFile(keystoreDir+string(os.PathSeparator)+"cacert.pem", certBytes, 0644)
-				if err != nil {
-					return
-				}
+			var err error
+			if key, err = ioutil.ReadFile(strings.TrimPrefix(s.PrivateKey, "file://")); err != nil {
+				return nil, nil, fmt.Errorf("unable to read OAuth2 key file: %v", err)
 			}
 		}
 	}
-	if s.CertFile != "" {
-		err = json.Unmarshal([]byte(s.CertFile), &certObj)
-		if err != nil { //if its not a json string, then its an OSS file spec
-			flogoFileValue = false
-		} else {
-			var certBytes []byte
-			certBytes, err = getBytesFromFileSetting(certObj)
-			if err != nil {
-				return
-			}
-			if certBytes != nil {
-				err = ioutil.WriteFile(keystoreDir+string(os.PathSeparator)+"certfile.pem", certBytes, 0644)
-				if err != nil {
-					return
-				}
-			}
-		}
+	tokens, err := newTokenManager(s, key)
+	if err != nil {
+		return nil, nil, err
 	}
-	if s.KeyFile != "" {
-		err = json.Unmarshal([]byte(s.KeyFile), &keyObj)
-		if err != nil { //if its not a json string, then its an OSS file spec
-			flogoFileValue = false
-		} else {
-			var keyBytes []byte
-			keyBytes, err = getBytesFromFileSetting(keyObj)
-			if err != nil {
-				return
-			}
-			if keyBytes != nil {
-				err = ioutil.WriteFile(keystoreDir+string(os.PathSeparator)+"keyfile.pem", keyBytes, 0644)
-				if err != nil {
-					return
-				}
-			}
+	return pulsar.NewAuthenticationTokenFromSupplier(tokens.Token), tokens, nil
+}
+
+func getTLSAuthentication(ks *keystore, s *Settings) (auth pulsar.Authentication, err error) {
+	if ks != nil {
+		// the certificate and key never touch the disk
+		auth, err = ks.tlsAuthentication(s.KeyPassword)
+		if err != nil {
+			err = fmt.Errorf("Authentication error: %v", err)
 		}
+		return
 	}
-	if s.PrivateKey != "" {
-		err = json.Unmarshal([]byte(s.PrivateKey), &prikeyObj)
-		if err != nil { //if its not a json string, then its an OSS file spec
-			flogoFileValue = false
-		} else {
-			var keyBytes []byte
-			keyBytes, err = getBytesFromFileSetting(prikeyObj)
-			if err != nil {
-				return
-			}
-			if keyBytes != nil {
-				err = ioutil.WriteFile(keystoreDir+string(os.PathSeparator)+"privateKey.json", keyBytes, 0644)
-				if err != nil {
-					return
-				}
-			}
+	certFile, keyFile := s.CertFile, s.KeyFile
+	if s.KeyPassword != "" {
+		auth, err = getEncryptedKeyTLSAuthentication(certFile, keyFile, s.KeyPassword)
+		if err != nil {
+			err = fmt.Errorf("Authentication error: %v", err)
 		}
+		return
 	}
-	if !flogoFileValue {
-		os.RemoveAll(keystoreDir)
-		return "", nil
-	}
+	auth = pulsar.NewAuthenticationTLS(certFile, keyFile)
+	return
+}
+func getJWTAuthentication(s *Settings) (auth pulsar.Authentication, err error) {
+	auth = pulsar.NewAuthenticationToken(s.JWT)
 	return
 }
 
+func getBasicAuthentication(s *Settings) (auth pulsar.Authentication, err error) {
+	if s.Username == "" {
+		return nil, fmt.Errorf("username is required for basic authentication")
+	}
+	return pulsar.NewAuthenticationBasic(s.Username, s.Password)
+}
+
 func getBytesFromFileSetting(fileSetting map[string]interface{}) (destArray []byte, err error) {
 	var header = "base64,"
 	value := fileSetting["content"].(string)
@@ -347,53 +520,156 @@ type PulsarConnManager struct {
 	ClientOpts pulsar.ClientOptions
 	Connected  bool
 	Lock       *sync.RWMutex
+	// Backpressure is shared by all producers and consumers of the connection
+	Backpressure *Backpressure
+	// Masker masks sensitive properties and payload fields in debug logs
+	Masker *Masker
+	// Memory bounds the payload size of the messages waiting for an acknowledgment from the broker
+	Memory *MemoryLimiter
+	// QualifyTopics expands the topic names of triggers and activities to fully qualified names
+	QualifyTopics bool
+	// HealthCheckTopic is looked up by Ping
+	HealthCheckTopic string
+	// Propagation carries allowlisted context values as message properties, nil if disabled
+	Propagation *ContextPropagation
+	// PropertyCodec encodes structured values into message properties and decodes them
+	PropertyCodec *PropertyCodec
+	// Labels attribute the logs, metrics and trace spans of the triggers and activities, nil if none
+	Labels *Labels
+	// ExposeIdentity hands the identity of the connection to the flows, see Identity
+	ExposeIdentity bool
+	// MetricTopics maps topics to the topic label of the metrics, see Metrics cardinality
+	MetricTopics *MetricTopics
+
+	failover  *urlFailover
+	reconnect *reconnector
+	shared    *sharedClient
+	retry     operationRetry
 }
 
+// Connect waits until the connection is established by the background reconnect, at most for the
+// connection timeout
 func (p *PulsarConnManager) Connect() error {
 
 	if p.Connected {
 		return nil
 	}
 
-	logger.Debugf("Acquiring lock for client creation")
-	p.Lock.Lock()
-	logger.Debugf("lock acquired for creating client")
-	defer p.Lock.Unlock()
-	logger.Info("attempting to create client")
-	type ClientInfo struct {
-		client pulsar.Client
-		err    error
+	timeout := p.ClientOpts.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = clientCreationTimeout
+	}
+	select {
+	case <-p.reconnect.Connected():
+	case <-time.After(timeout):
+		return fmt.Errorf("not connected to [%s] after %v, reconnecting in the background", p.currentURL(), timeout)
 	}
-	infoChan := make(chan ClientInfo)
+	client, err := p.reconnect.Client()
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return fmt.Errorf("not connected to [%s], reconnecting in the background", p.currentURL())
+	}
+	p.Client = client
+	p.Connected = true
+	return nil
+}
 
-	go func() {
-		client, err := pulsar.NewClient(p.ClientOpts)
-		infoChan <- ClientInfo{client: client, err: err}
-	}()
+// NotifyConnected returns a channel which is closed once the connection is established (or reconnecting was
+// given up), so triggers and activities can wait for it instead of polling
+func (p *PulsarConnManager) NotifyConnected() <-chan struct{} {
+	return p.reconnect.Connected()
+}
 
-	select {
-	case data := <-infoChan:
-		if data.err != nil {
-			return data.err
-		}
-		logger.Info("new client created")
-		p.Client = data.client
-		p.Connected = true
-		return nil
-	case <-time.After(30 * time.Second):
-		return fmt.Errorf("client creation has timedout after 30 seconds")
+// NotifyRefreshed returns a channel which is closed when the client is swapped by the next credential refresh
+func (p *PulsarConnManager) NotifyRefreshed() <-chan struct{} {
+	return p.reconnect.Refreshed()
+}
+
+// Refreshed returns true if the producer or consumer was created with a client replaced by a credential
+// refresh. It should be closed and created again to use the new credentials.
+func (p *PulsarConnManager) Refreshed(producerOrConsumer interface{}) bool {
+	return p.reconnect.isRetired(producerOrConsumer)
+}
+
+// AdminClient returns a client of the admin REST API at the adminUrl of the connection, authenticated with the
+// credentials of the connection
+func (p *PulsarConnManager) AdminClient() (*AdminClient, error) {
+	var admin *AdminClient
+	if p.shared != nil {
+		admin = p.shared.adminClient()
+	}
+	if admin == nil {
+		return nil, fmt.Errorf("no adminUrl configured for the pulsar connection")
 	}
+	return admin, nil
+}
 
+// failed reports that the client could not be used, it is reconnected in the background with backoff and
+// failed over to the next service URL. It returns true if the operation should be attempted again.
+func (p *PulsarConnManager) failed() bool {
+	p.reconnect.Failed(p.Client)
+	p.Connected = false
+	return p.failover != nil && p.failover.size() > 1
 }
 
-func (p *PulsarConnManager) GetProducer(producerOptions pulsar.ProducerOptions) (pulsar.Producer, error) {
+// attempts returns how often operations are attempted, once per service URL
+func (p *PulsarConnManager) attempts() int {
+	if p.failover == nil || p.failover.size() == 0 {
+		return 1
+	}
+	return p.failover.size()
+}
 
-	if !p.Connected {
-		err := p.Connect()
-		if err != nil {
-			return nil, err
+func (p *PulsarConnManager) currentURL() string {
+	if p.failover == nil {
+		return p.ClientOpts.URL
+	}
+	return p.failover.current()
+}
+
+// GetProducer creates a producer, transient failures are retried with backoff according to the maxRetries,
+// initialBackoff and maxBackoff settings
+func (p *PulsarConnManager) GetProducer(producerOptions pulsar.ProducerOptions) (producer pulsar.Producer, err error) {
+	err = p.retry.do("producer creation for topic ["+producerOptions.Topic+"]", func() error {
+		producer, err = p.getProducer(producerOptions)
+		return err
+	})
+	return
+}
+
+func (p *PulsarConnManager) getProducer(producerOptions pulsar.ProducerOptions) (producer pulsar.Producer, err error) {
+	for attempt := 0; attempt < p.attempts(); attempt++ {
+		if p.Connected && !p.reconnect.isCurrent(p.Client) {
+			// the client was replaced by a refresh
+			p.Connected = false
+		}
+		if !p.Connected {
+			if err = p.Connect(); err != nil {
+				continue
+			}
+		}
+		url := p.currentURL()
+		producer, err = p.createProducer(producerOptions)
+		if err == nil {
+			p.reconnect.Succeeded()
+			p.reconnect.acquire(producer, p.Client)
+			return
+		}
+		if !IsConnectivityError(err) {
+			// e.g. missing permissions or an incompatible schema, the client is fine
+			return
+		}
+		if !p.failed() {
+			return
 		}
+		logger.Warnf("producer creation failed on [%s]: %v", url, err)
 	}
+	return
+}
+
+func (p *PulsarConnManager) createProducer(producerOptions pulsar.ProducerOptions) (pulsar.Producer, error) {
 
 	logger.Debugf("Acquiring lock for producer creation")
 	p.Lock.Lock()
@@ -418,20 +694,73 @@ func (p *PulsarConnManager) GetProducer(producerOptions pulsar.ProducerOptions)
 			return nil, data.err
 		}
 		logger.Info("producer created")
+		registerProducer(data.producer, p.reconnect)
 		return data.producer, nil
 	case <-time.After(30 * time.Second):
-		return nil, fmt.Errorf("producer creation has timedout after 30 seconds")
+		return nil, fmt.Errorf("producer creation has %w after 30 seconds", errTimedOut)
 	}
 }
 
-func (p *PulsarConnManager) GetSubscriber(consumerOptions pulsar.ConsumerOptions) (pulsar.Consumer, error) {
+// CloseProducer closes a producer created with GetProducer and releases its reference on the client
+func (p *PulsarConnManager) CloseProducer(producer pulsar.Producer) {
+	producer.Close()
+	unregisterProducer(producer)
+	p.reconnect.release(producer)
+}
 
-	if !p.Connected {
-		err := p.Connect()
-		if err != nil {
-			return nil, err
+// CloseSubscriber closes a consumer created with GetSubscriber and releases its reference on the client
+func (p *PulsarConnManager) CloseSubscriber(consumer pulsar.Consumer) {
+	consumer.Close()
+	p.reconnect.release(consumer)
+}
+
+// GetSubscriber creates a consumer, transient failures are retried with backoff according to the maxRetries,
+// initialBackoff and maxBackoff settings
+func (p *PulsarConnManager) GetSubscriber(consumerOptions pulsar.ConsumerOptions) (consumer pulsar.Consumer, err error) {
+	err = p.retry.do("subscriber creation for subscription ["+consumerOptions.SubscriptionName+"]", func() error {
+		consumer, err = p.getSubscriber(consumerOptions)
+		return err
+	})
+	return
+}
+
+// RetryDelay returns the backoff before the given retry, starting at 0, of operations which are retried
+// beyond GetProducer and GetSubscriber
+func (p *PulsarConnManager) RetryDelay(retry int) time.Duration {
+	return p.retry.backoff.delay(retry)
+}
+
+func (p *PulsarConnManager) getSubscriber(consumerOptions pulsar.ConsumerOptions) (consumer pulsar.Consumer, err error) {
+	for attempt := 0; attempt < p.attempts(); attempt++ {
+		if p.Connected && !p.reconnect.isCurrent(p.Client) {
+			// the client was replaced by a refresh
+			p.Connected = false
+		}
+		if !p.Connected {
+			if err = p.Connect(); err != nil {
+				continue
+			}
 		}
+		url := p.currentURL()
+		consumer, err = p.createSubscriber(consumerOptions)
+		if err == nil {
+			p.reconnect.Succeeded()
+			p.reconnect.acquire(consumer, p.Client)
+			return
+		}
+		if !IsConnectivityError(err) {
+			// e.g. a busy Exclusive subscription or a missing topic, the client is fine
+			return
+		}
+		if !p.failed() {
+			return
+		}
+		logger.Warnf("subscriber creation failed on [%s]: %v", url, err)
 	}
+	return
+}
+
+func (p *PulsarConnManager) createSubscriber(consumerOptions pulsar.ConsumerOptions) (pulsar.Consumer, error) {
 
 	logger.Debugf("Acquiring lock for subscriber creation")
 	p.Lock.Lock()
@@ -458,7 +787,7 @@ func (p *PulsarConnManager) GetSubscriber(consumerOptions pulsar.ConsumerOptions
 		logger.Info("subscriber created")
 		return data.consumer, nil
 	case <-time.After(30 * time.Second):
-		return nil, fmt.Errorf("subscriber creation has timedout after 30 seconds")
+		return nil, fmt.Errorf("subscriber creation has %w after 30 seconds", errTimedOut)
 	}
 
 }
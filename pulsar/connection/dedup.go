@@ -0,0 +1,93 @@
+package connection
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deduplicator detects messages with the same content within a window, keyed on a hash of the payload and of
+// selected properties rather than on the message id. It catches the duplicates of upstream retries which publish
+// the same content again under a new message id, which broker deduplication does not.
+type Deduplicator struct {
+	window     time.Duration
+	checksum   Checksum
+	properties []string
+
+	lock      sync.Mutex
+	seen      map[string]dedupEntry
+	lastSweep time.Time
+}
+
+type dedupEntry struct {
+	id string
+	at time.Time
+}
+
+// NewDeduplicator creates a deduplicator remembering hashes for the window, computed with the checksum algorithm,
+// SHA256 if empty, over the payload and the comma separated properties. It returns nil if the window is not positive.
+func NewDeduplicator(window time.Duration, algorithm, properties string) (*Deduplicator, error) {
+	if window <= 0 {
+		return nil, nil
+	}
+	if algorithm == "" {
+		algorithm = "SHA256"
+	}
+	checksum, err := getChecksum(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	d := &Deduplicator{window: window, checksum: checksum, seen: make(map[string]dedupEntry), lastSweep: Now()}
+	for _, p := range strings.Split(properties, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			d.properties = append(d.properties, p)
+		}
+	}
+	sort.Strings(d.properties)
+	return d, nil
+}
+
+// Hash returns the hash of the payload and the selected properties, a missing property hashes as an empty one
+func (d *Deduplicator) Hash(payload []byte, properties map[string]string) string {
+	content := payload
+	if len(d.properties) > 0 {
+		content = make([]byte, 0, len(payload)+64*len(d.properties))
+		content = append(content, payload...)
+		for _, p := range d.properties {
+			content = append(content, 0)
+			content = append(content, p...)
+			content = append(content, '=')
+			content = append(content, properties[p]...)
+		}
+	}
+	return d.checksum(content)
+}
+
+// Duplicate records the hash of a message and returns true if a message with another id had the same hash within
+// the window. Messages with the same id, e.g. redeliveries of a message which failed, are not duplicates; an empty
+// id matches any other message.
+func (d *Deduplicator) Duplicate(hash, id string) bool {
+	now := Now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.sweep(now)
+	if entry, ok := d.seen[hash]; ok && now.Sub(entry.at) < d.window {
+		return id == "" || entry.id != id
+	}
+	d.seen[hash] = dedupEntry{id: id, at: now}
+	return false
+}
+
+// sweep forgets the hashes older than the window, at most once per window
+func (d *Deduplicator) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.window {
+		return
+	}
+	d.lastSweep = now
+	for hash, entry := range d.seen {
+		if now.Sub(entry.at) >= d.window {
+			delete(d.seen, hash)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package connection
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// Diagnosable is implemented by triggers and activities reporting their state in the diagnostics dump
+type Diagnosable interface {
+	// DiagnosticsName returns the unique name the state is reported under
+	DiagnosticsName() string
+	// Diagnostics returns the state, it must be serializable to JSON
+	Diagnostics() interface{}
+}
+
+// ConnectionDiagnostics is the state of a connection as seen by a trigger or activity
+type ConnectionDiagnostics struct {
+	URL               string `json:"url"`
+	Connected         bool   `json:"connected"`
+	ReconnectAttempts int    `json:"reconnectAttempts"`
+	Producers         int    `json:"producers"`
+	Consumers         int    `json:"consumers"`
+}
+
+var diagnostics = struct {
+	lock  sync.Mutex
+	items map[Diagnosable]struct{}
+}{items: make(map[Diagnosable]struct{})}
+
+// RegisterDiagnostics adds a trigger handler or activity to the diagnostics dump
+func RegisterDiagnostics(d Diagnosable) {
+	diagnostics.lock.Lock()
+	defer diagnostics.lock.Unlock()
+	diagnostics.items[d] = struct{}{}
+}
+
+// UnregisterDiagnostics removes a trigger handler or activity from the diagnostics dump
+func UnregisterDiagnostics(d Diagnosable) {
+	diagnostics.lock.Lock()
+	defer diagnostics.lock.Unlock()
+	delete(diagnostics.items, d)
+}
+
+// DumpDiagnostics returns the state of all registered trigger handlers and activities as JSON, for support
+// escalations and bug reports
+func DumpDiagnostics() ([]byte, error) {
+	diagnostics.lock.Lock()
+	items := make([]Diagnosable, 0, len(diagnostics.items))
+	for d := range diagnostics.items {
+		items = append(items, d)
+	}
+	diagnostics.lock.Unlock()
+
+	handlers := make(map[string]interface{}, len(items))
+	for _, d := range items {
+		handlers[d.DiagnosticsName()] = d.Diagnostics()
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"time":     time.Now().Format(time.RFC3339Nano),
+		"handlers": handlers,
+	}, "", "  ")
+}
+
+// Diagnostics returns the state of the connection
+func (p *PulsarConnManager) Diagnostics() ConnectionDiagnostics {
+	d := ConnectionDiagnostics{URL: p.currentURL()}
+	if p.reconnect == nil {
+		return d
+	}
+	p.reconnect.lock.Lock()
+	defer p.reconnect.lock.Unlock()
+	d.Connected = p.reconnect.client != nil
+	d.ReconnectAttempts = p.reconnect.failures
+	for handle := range p.reconnect.owners {
+		switch handle.(type) {
+		case pulsar.Producer:
+			d.Producers++
+		default:
+			d.Consumers++
+		}
+	}
+	return d
+}
@@ -0,0 +1,53 @@
+package connection
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var envReference = regexp.MustCompile(`\$env\{([^}]+)\}`)
+
+// expandEnv returns a copy of the connection settings with references like $env{PULSAR_URL} in string values
+// replaced by the environment variable, so that the same app can be promoted across environments unchanged
+func expandEnv(settings map[string]interface{}) (map[string]interface{}, error) {
+	expanded := make(map[string]interface{}, len(settings))
+	for name, value := range settings {
+		switch v := value.(type) {
+		case string:
+			s, err := expandEnvValue(name, v)
+			if err != nil {
+				return nil, err
+			}
+			expanded[name] = s
+		case map[string]interface{}:
+			m := make(map[string]interface{}, len(v))
+			for k, e := range v {
+				if s, ok := e.(string); ok {
+					var err error
+					if e, err = expandEnvValue(name, s); err != nil {
+						return nil, err
+					}
+				}
+				m[k] = e
+			}
+			expanded[name] = m
+		default:
+			expanded[name] = value
+		}
+	}
+	return expanded, nil
+}
+
+func expandEnvValue(setting, value string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable [%s] referenced by setting [%s] is not set", name, setting)
+		}
+		return v
+	})
+	return expanded, err
+}
@@ -0,0 +1,68 @@
+package connection
+
+import (
+	"strings"
+	"sync"
+)
+
+// urlFailover rotates through the broker service URLs of a connection when client creation or
+// producer/consumer creation fails
+type urlFailover struct {
+	lock  sync.Mutex
+	urls  []string
+	index int
+}
+
+// newURLFailover parses a comma separated list of service URLs. Entries without a scheme inherit the
+// scheme of the first entry, so "pulsar://host1:6650,host2:6650" is accepted as well.
+func newURLFailover(serviceURLs string) *urlFailover {
+	f := &urlFailover{}
+	scheme := ""
+	for _, u := range strings.Split(serviceURLs, ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if i := strings.Index(u, "://"); i >= 0 {
+			if scheme == "" {
+				scheme = u[:i+3]
+			}
+		} else if scheme != "" {
+			u = scheme + u
+		}
+		f.urls = append(f.urls, u)
+	}
+	return f
+}
+
+func (f *urlFailover) current() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.urls) == 0 {
+		return ""
+	}
+	return f.urls[f.index]
+}
+
+// serviceURLs returns a copy of the service URLs
+func (f *urlFailover) serviceURLs() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.urls...)
+}
+
+func (f *urlFailover) size() int {
+	return len(f.urls)
+}
+
+// next moves on to the next URL if the failed one is still the current one, so concurrent failures
+// against the same URL rotate only once
+func (f *urlFailover) next(failed string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.urls) > 1 && f.urls[f.index] == failed {
+		f.index = (f.index + 1) % len(f.urls)
+		logger.Warnf("Failing over from [%s] to [%s]", failed, f.urls[f.index])
+	}
+	return f.urls[f.index]
+}
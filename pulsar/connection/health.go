@@ -0,0 +1,69 @@
+package connection
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const defaultHealthCheckTopic = "persistent://public/default/flogo-healthcheck"
+
+// HealthStatus is the result of a connection health check
+type HealthStatus struct {
+	Healthy bool          `json:"healthy"`
+	URL     string        `json:"url"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Ping verifies that the brokers are reachable and accept the credentials and TLS settings of the connection,
+// by looking up the partitions of the health check topic. Without a client, e.g. at design time, a temporary
+// client is created so that the actual error is reported instead of reconnecting in the background.
+func (p *PulsarConnManager) Ping() error {
+	client := p.Client
+	if client == nil {
+		opts := p.ClientOpts
+		opts.URL = p.currentURL()
+		c, err := pulsar.NewClient(opts)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		client = c
+	}
+
+	timeout := p.ClientOpts.OperationTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.TopicPartitions(p.healthCheckTopic())
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("health check timed out after %v", timeout)
+	}
+}
+
+// CheckHealth pings the brokers and reports the outcome along with the round trip latency, for runtime health probes
+func (p *PulsarConnManager) CheckHealth() HealthStatus {
+	start := time.Now()
+	err := p.Ping()
+	status := HealthStatus{Healthy: err == nil, URL: p.currentURL(), Latency: time.Since(start)}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+func (p *PulsarConnManager) healthCheckTopic() string {
+	if p.HealthCheckTopic != "" {
+		return p.HealthCheckTopic
+	}
+	return defaultHealthCheckTopic
+}
@@ -0,0 +1,66 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+)
+
+// Identity is the identity a connection authenticates to the brokers with. With exposeIdentity it is handed to the
+// flows, so that activities calling services behind the same identity provider can reuse it without separate
+// configuration. It is neither logged nor propagated as a message property.
+type Identity struct {
+	// Token is the current access token, with OAuth2 it is refreshed ahead of expiry
+	Token string
+}
+
+// String does not reveal the token, e.g. when the identity is logged by mistake
+func (i *Identity) String() string {
+	return "pulsar connection identity"
+}
+
+// ToMap returns the identity as provided in the trigger output
+func (i *Identity) ToMap() map[string]string {
+	return map[string]string{"token": i.Token, "authorization": "Bearer " + i.Token}
+}
+
+type identityKey struct{}
+
+// Identity returns the current identity of the connection, nil if exposeIdentity is not set or the connection does
+// not authenticate with a token, e.g. with TLS or Basic authentication
+func (p *PulsarConnManager) Identity() (*Identity, error) {
+	if p.shared == nil || !p.ExposeIdentity {
+		return nil, nil
+	}
+	p.shared.lock.Lock()
+	auth := p.shared.clientOpts.Authentication
+	p.shared.lock.Unlock()
+	provider, ok := auth.(interface {
+		Name() string
+		GetData() ([]byte, error)
+	})
+	if !ok || provider.Name() != "token" {
+		return nil, nil
+	}
+	token, err := provider.GetData()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the token of the connection: %v", err)
+	}
+	return &Identity{Token: string(token)}, nil
+}
+
+// NewContextWithIdentity returns a context carrying the identity of the connection of a consumed message
+func NewContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	if identity == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity stored with NewContextWithIdentity, or nil
+func IdentityFromContext(ctx context.Context) *Identity {
+	if ctx == nil {
+		return nil
+	}
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
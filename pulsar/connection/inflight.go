@@ -0,0 +1,31 @@
+package connection
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Consumed messages are tracked by their msgid while the flow processing them runs, so activities
+// can acknowledge them before the flow completes (e.g. once they were forwarded to another topic)
+var inFlight sync.Map
+
+// TrackMessage registers the ack function of a consumed message until the flow processing it completes
+func TrackMessage(msgID string, ack func()) {
+	inFlight.Store(msgID, ack)
+}
+
+// AckMessage acknowledges an in-flight message, the outcome of the flow no longer affects it
+func AckMessage(msgID string) error {
+	ack, ok := inFlight.LoadAndDelete(msgID)
+	if !ok {
+		return fmt.Errorf("message [%s] is not in flight or was already acknowledged", msgID)
+	}
+	ack.(func())()
+	return nil
+}
+
+// ReleaseMessage stops tracking the message, it returns false if it was already acknowledged by AckMessage
+func ReleaseMessage(msgID string) bool {
+	_, ok := inFlight.LoadAndDelete(msgID)
+	return ok
+}
@@ -0,0 +1,46 @@
+package connection
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LookupPath resolves a simple dotted path such as "$.order.items[0].id" in a parsed JSON document
+func LookupPath(doc interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return doc, true
+	}
+	current := doc
+	for _, segment := range strings.Split(path, ".") {
+		name := segment
+		var indexes []int
+		if i := strings.Index(segment, "["); i >= 0 {
+			name = segment[:i]
+			for _, idx := range strings.Split(strings.TrimSuffix(segment[i+1:], "]"), "][") {
+				n, err := strconv.Atoi(idx)
+				if err != nil {
+					return nil, false
+				}
+				indexes = append(indexes, n)
+			}
+		}
+		if name != "" {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = obj[name]; !ok {
+				return nil, false
+			}
+		}
+		for _, n := range indexes {
+			arr, ok := current.([]interface{})
+			if !ok || n < 0 || n >= len(arr) {
+				return nil, false
+			}
+			current = arr[n]
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// sharedMemoryDir is a tmpfs on Linux, files written there never reach a disk
+const sharedMemoryDir = "/dev/shm"
+
+// keystore holds the certificates and keys of the file settings given as content, i.e. raw PEM or the file
+// setting JSON of the Flogo UI, in memory. Only the CA certificate is written to a file readable by the app
+// user only, preferably on a tmpfs, since the client takes the trusted certificates as a file path.
+type keystore struct {
+	caCert     []byte
+	cert       []byte
+	key        []byte
+	privateKey []byte
+	dir        string
+}
+
+// newKeystore loads the file settings given as content, it returns nil if there are none or the settings are
+// file paths
+func newKeystore(s *Settings) (*keystore, error) {
+	if s.CertFile == "" && s.KeyFile == "" && s.CaCert == "" && s.PrivateKey == "" {
+		return nil, nil
+	}
+	k := &keystore{}
+	settings := []struct {
+		value   string
+		content *[]byte
+	}{
+		{s.CaCert, &k.caCert},
+		{s.CertFile, &k.cert},
+		{s.KeyFile, &k.key},
+		{s.PrivateKey, &k.privateKey},
+	}
+	for _, setting := range settings {
+		if setting.value == "" {
+			continue
+		}
+		content, ok, err := keystoreContent(setting.value)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			//if its neither PEM content nor a json string, then its an OSS file spec
+			return nil, nil
+		}
+		*setting.content = content
+	}
+	if k.caCert != nil {
+		if err := k.writeCACert(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// keystoreContent returns the content of a file setting holding either raw PEM content, e.g. set from an
+// environment variable or a Kubernetes secret, or the file setting JSON of the Flogo UI. It returns false if the
+// setting is a file path.
+func keystoreContent(value string) ([]byte, bool, error) {
+	if pemContent := strings.TrimSpace(value); strings.HasPrefix(pemContent, "-----BEGIN") {
+		return []byte(pemContent + "\n"), true, nil
+	}
+	var fileObj map[string]interface{}
+	if json.Unmarshal([]byte(value), &fileObj) != nil {
+		return nil, false, nil
+	}
+	content, err := getBytesFromFileSetting(fileObj)
+	return content, true, err
+}
+
+// ReadKey returns the key or certificate of a setting of a trigger or activity, given like the file settings of the
+// connection: raw PEM content, the file setting JSON of the Flogo UI, a secret reference like
+// "vault://secret/data/pulsar#key" or a file path
+func ReadKey(value string) ([]byte, error) {
+	secret, ok, err := resolveSecret(value)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return secret, nil
+	}
+	content, ok, err := keystoreContent(value)
+	if err != nil || ok {
+		return content, err
+	}
+	return ioutil.ReadFile(strings.TrimPrefix(value, "file://"))
+}
+
+// writeCACert writes the CA certificate to a directory only the app user can access
+func (k *keystore) writeCACert() error {
+	base := os.TempDir()
+	if info, err := os.Stat(sharedMemoryDir); err == nil && info.IsDir() {
+		base = sharedMemoryDir
+	}
+	dir, err := ioutil.TempDir(base, "pulsar")
+	if err != nil {
+		return err
+	}
+	// TempDir creates the directory with 0700
+	if err = ioutil.WriteFile(filepath.Join(dir, "cacert.pem"), k.caCert, 0600); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	k.dir = dir
+	return nil
+}
+
+// caCertFile returns the path of the CA certificate, empty if there is none
+func (k *keystore) caCertFile() string {
+	if k == nil || k.dir == "" {
+		return ""
+	}
+	return filepath.Join(k.dir, "cacert.pem")
+}
+
+// tlsAuthentication supplies the client certificate from memory, with the private key decrypted if a password is set
+func (k *keystore) tlsAuthentication(password string) (pulsar.Authentication, error) {
+	cert, err := parseTLSCertificate(k.cert, k.key, password)
+	if err != nil {
+		return nil, err
+	}
+	return pulsar.NewAuthenticationFromTLSCertSupplier(func() (*tls.Certificate, error) {
+		return cert, nil
+	}), nil
+}
+
+// close removes the CA certificate file
+func (k *keystore) close() {
+	if k != nil && k.dir != "" {
+		os.RemoveAll(k.dir)
+	}
+}
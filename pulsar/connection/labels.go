@@ -0,0 +1,122 @@
+package connection
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/core/support/trace"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels identify the handler or activity in the labels metrics
+var reservedLabels = map[string]bool{"handler": true, "topic": true}
+
+// Labels attribute the traffic of a connection, e.g. to an environment or business unit, in the logs, metrics and
+// trace spans of the triggers and activities using it
+type Labels struct {
+	keys   []string
+	values map[string]string
+	fields []interface{}
+}
+
+// NewLabels validates the label names, which must be valid Prometheus label names, it returns nil if there are none
+func NewLabels(labels map[string]string) (*Labels, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	l := &Labels{values: make(map[string]string, len(labels))}
+	for k, v := range labels {
+		if !labelNamePattern.MatchString(k) || strings.HasPrefix(k, "__") || reservedLabels[k] {
+			return nil, fmt.Errorf("invalid label name [%s]", k)
+		}
+		l.keys = append(l.keys, k)
+		l.values[k] = v
+	}
+	sort.Strings(l.keys)
+	for _, k := range l.keys {
+		l.fields = append(l.fields, k, l.values[k])
+	}
+	return l, nil
+}
+
+// Map returns a copy of the labels
+func (l *Labels) Map() map[string]string {
+	if l == nil {
+		return nil
+	}
+	labels := make(map[string]string, len(l.values))
+	for k, v := range l.values {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Logger returns a child logger adding the labels as fields to every log line
+func (l *Labels) Logger(logger log.Logger) log.Logger {
+	if l == nil {
+		return logger
+	}
+	return log.ChildLoggerWithFields(logger, l.fields...)
+}
+
+// TagSpan sets the labels as tags of the trace span, if tracing is enabled
+func (l *Labels) TagSpan(tc trace.TracingContext) {
+	if l == nil || tc == nil {
+		return
+	}
+	tags := make(map[string]interface{}, len(l.values))
+	for k, v := range l.values {
+		tags[k] = v
+	}
+	tc.SetTags(tags)
+}
+
+// RegisterMetric exports the labels as an info metric with value 1, e.g.
+// pulsar_trigger_labels{handler="orders",env="prod"}, which is joined with the metrics of the handler or
+// activity on the identifying label. Metric labels are fixed per metric, so the labels cannot be added to them.
+func (l *Labels) RegisterMetric(name, label, value string) {
+	if l == nil {
+		return
+	}
+	labelsInfo.lock.Lock()
+	defer labelsInfo.lock.Unlock()
+	labelsInfo.series[name+"\x00"+value] = labeledSeries{name: name, label: label, value: value, labels: l}
+}
+
+type labeledSeries struct {
+	name, label, value string
+	labels             *Labels
+}
+
+// labelsCollector collects the labels metrics, their label names differ per connection so it is unchecked
+type labelsCollector struct {
+	lock   sync.Mutex
+	series map[string]labeledSeries
+}
+
+var labelsInfo = &labelsCollector{series: make(map[string]labeledSeries)}
+
+// Describe implements prometheus.Collector.Describe
+func (c *labelsCollector) Describe(chan<- *prometheus.Desc) {
+}
+
+// Collect implements prometheus.Collector.Collect
+func (c *labelsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, s := range c.series {
+		names := append([]string{s.label}, s.labels.keys...)
+		values := []string{s.value}
+		for _, k := range s.labels.keys {
+			values = append(values, s.labels.values[k])
+		}
+		desc := prometheus.NewDesc(s.name, "Labels of the pulsar connection, always 1", names, nil)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+	}
+}
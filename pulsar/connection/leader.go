@@ -0,0 +1,163 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const defaultLeaderInterval = 10 * time.Second
+
+// leaderHeartbeat is published on the lock topic by the leader, which only the holder of the lock receives
+type leaderHeartbeat struct {
+	Candidate string `json:"candidate"`
+	At        int64  `json:"at"`
+}
+
+// LeaderElection elects one of the replicas of an app to run singleton work, e.g. schedulers or DLQ drainers, while
+// the others stand by. The lock is an Exclusive subscription on the lock topic: the broker lets one consumer hold it,
+// the standby replicas try to subscribe every interval. The client does not report losing a subscription, the leader
+// publishes a heartbeat on the lock topic every interval and steps down when it does not receive them anymore, e.g.
+// while it is disconnected and another replica took over.
+type LeaderElection struct {
+	connMgr   PulsarConnManager
+	topic     string
+	name      string
+	candidate string
+	interval  time.Duration
+
+	lock    sync.Mutex
+	leading bool
+}
+
+// NewLeaderElection creates the election of the lock name among the replicas, candidate identifies the replica.
+// The interval defaults to 10 seconds, the leader steps down after 3 intervals without heartbeat.
+func NewLeaderElection(connMgr PulsarConnManager, topic, name, candidate string, interval time.Duration) *LeaderElection {
+	if interval <= 0 {
+		interval = defaultLeaderInterval
+	}
+	return &LeaderElection{connMgr: connMgr, topic: topic, name: name, candidate: candidate, interval: interval}
+}
+
+// IsLeader returns true while the replica holds the lock
+func (e *LeaderElection) IsLeader() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.leading
+}
+
+// Run campaigns until done is closed, elected is called when the replica takes the lead and deposed when it steps
+// down. The lock is released when done is closed, deposed is not called then.
+func (e *LeaderElection) Run(done <-chan bool, elected, deposed func()) {
+	for {
+		consumer, err := e.campaign()
+		if err == nil {
+			e.setLeading(true)
+			logger.Infof("Elected leader of [%s] as [%s]", e.name, e.candidate)
+			elected()
+			stopped := e.lead(consumer, done)
+			e.setLeading(false)
+			e.connMgr.CloseSubscriber(consumer)
+			if stopped {
+				return
+			}
+			logger.Warnf("Lost the lead of [%s], standing by", e.name)
+			deposed()
+		} else if !isConsumerBusy(err) {
+			logger.Warnf("Leader election of [%s] failed: %v", e.name, err)
+		}
+		select {
+		case <-time.After(e.interval):
+		case <-done:
+			return
+		}
+	}
+}
+
+func (e *LeaderElection) setLeading(leading bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.leading = leading
+}
+
+// campaign tries to take the lock, it fails with ConsumerBusy while another replica holds it. The subscriber is
+// created without reporting failures to the reconnect of the connection, standby replicas get ConsumerBusy every
+// interval while the client is fine.
+func (e *LeaderElection) campaign() (pulsar.Consumer, error) {
+	p := &e.connMgr
+	if p.Connected && !p.reconnect.isCurrent(p.Client) {
+		// the client was replaced by a refresh or a failover
+		p.Connected = false
+	}
+	if err := p.Connect(); err != nil {
+		return nil, err
+	}
+	consumer, err := p.createSubscriber(pulsar.ConsumerOptions{
+		Topic:                       e.topic,
+		SubscriptionName:            e.name,
+		Type:                        pulsar.Exclusive,
+		Name:                        e.candidate,
+		SubscriptionInitialPosition: pulsar.SubscriptionPositionLatest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.reconnect.acquire(consumer, p.Client)
+	return consumer, nil
+}
+
+// isConsumerBusy returns true if another consumer holds the Exclusive subscription. The client reports the errors
+// of the brokers as text, e.g. "server error: ConsumerBusy: Exclusive consumer is already connected", rather than
+// with the ConsumerBusy result.
+func isConsumerBusy(err error) bool {
+	var pulsarErr *pulsar.Error
+	if errors.As(err, &pulsarErr) {
+		return pulsarErr.Result() == pulsar.ConsumerBusy
+	}
+	return strings.Contains(err.Error(), "server error: ConsumerBusy")
+}
+
+// lead holds the lock while the heartbeats come through, it returns true if done was closed
+func (e *LeaderElection) lead(consumer pulsar.Consumer, done <-chan bool) bool {
+	var producer pulsar.Producer
+	defer func() {
+		if producer != nil {
+			e.connMgr.CloseProducer(producer)
+		}
+	}()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	lastSeen := Now()
+	for {
+		select {
+		case msg := <-consumer.Chan():
+			lastSeen = Now()
+			_ = consumer.Ack(msg)
+		case <-ticker.C:
+			if Now().Sub(lastSeen) > 3*e.interval {
+				return false
+			}
+			if producer == nil {
+				var err error
+				if producer, err = e.connMgr.getProducer(pulsar.ProducerOptions{Topic: e.topic}); err != nil {
+					// without heartbeats the replica steps down, another one may be able to publish
+					logger.Warnf("Unable to publish heartbeats on [%s]: %v", e.topic, err)
+					producer = nil
+					continue
+				}
+			}
+			heartbeat, _ := json.Marshal(leaderHeartbeat{Candidate: e.candidate, At: Now().UnixMilli()})
+			ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+			producer.SendAsync(ctx, &pulsar.ProducerMessage{Payload: heartbeat}, func(pulsar.MessageID, *pulsar.ProducerMessage, error) {
+				cancel()
+			})
+		case <-done:
+			return true
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package connection
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const maskedValue = "****"
+
+// Masker masks sensitive message properties and payload fields before they are logged
+type Masker struct {
+	properties map[string]bool
+	paths      [][]string
+}
+
+// NewMasker creates a masker from comma separated property names and JSON paths (e.g. "$.customer.ssn",
+// "$.cards[*].number"). Property names are matched case insensitively.
+func NewMasker(properties, paths string) *Masker {
+	m := &Masker{properties: make(map[string]bool)}
+	for _, p := range strings.Split(properties, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			m.properties[strings.ToLower(p)] = true
+		}
+	}
+	for _, p := range strings.Split(paths, ",") {
+		p = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(p), "$"), ".")
+		if p == "" {
+			continue
+		}
+		var segments []string
+		for _, s := range strings.Split(p, ".") {
+			// "items[*]" is handled as the segments "items" and "*"
+			if i := strings.Index(s, "["); i >= 0 {
+				if s[:i] != "" {
+					segments = append(segments, s[:i])
+				}
+				for _, idx := range strings.Split(strings.TrimSuffix(s[i+1:], "]"), "][") {
+					segments = append(segments, idx)
+				}
+			} else {
+				segments = append(segments, s)
+			}
+		}
+		m.paths = append(m.paths, segments)
+	}
+	return m
+}
+
+// Enabled returns true if there are masking rules
+func (m *Masker) Enabled() bool {
+	return m != nil && (len(m.properties) > 0 || len(m.paths) > 0)
+}
+
+// MaskProperties returns a copy of the properties with the sensitive ones masked
+func (m *Masker) MaskProperties(props map[string]string) map[string]string {
+	if !m.Enabled() || len(m.properties) == 0 || props == nil {
+		return props
+	}
+	masked := make(map[string]string, len(props))
+	for k, v := range props {
+		if m.properties[strings.ToLower(k)] {
+			v = maskedValue
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+// MaskPayload returns a copy of the payload with the sensitive fields masked. String and byte payloads
+// are masked if they hold a JSON document.
+func (m *Masker) MaskPayload(payload interface{}) interface{} {
+	if !m.Enabled() || len(m.paths) == 0 {
+		return payload
+	}
+	var doc interface{}
+	switch p := payload.(type) {
+	case string:
+		if json.Unmarshal([]byte(p), &doc) != nil {
+			return payload
+		}
+	case []byte:
+		if json.Unmarshal(p, &doc) != nil {
+			return payload
+		}
+	default:
+		// deep copy through JSON so the original payload is left untouched
+		data, err := json.Marshal(p)
+		if err != nil || json.Unmarshal(data, &doc) != nil {
+			return payload
+		}
+	}
+	for _, path := range m.paths {
+		doc = maskPath(doc, path)
+	}
+	return doc
+}
+
+func maskPath(doc interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return maskedValue
+	}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if path[0] == "*" || path[0] == k {
+				v[k] = maskPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				v[i] = maskPath(child, path[1:])
+			}
+		}
+	}
+	return doc
+}
@@ -0,0 +1,69 @@
+package connection
+
+import (
+	"sync"
+)
+
+// MemoryLimiter bounds the memory used by messages waiting for an acknowledgment from the broker across
+// all producers of a connection. pulsar-client-go v0.9.0 has no client wide memory limit, so the publishing
+// activities reserve the payload size here before sending. A nil limiter does not limit anything.
+type MemoryLimiter struct {
+	limit int64
+	lock  sync.Mutex
+	cond  *sync.Cond
+	used  int64
+}
+
+// NewMemoryLimiter returns a limiter for the given number of bytes, nil if limit is not positive
+func NewMemoryLimiter(limit int64) *MemoryLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	m := &MemoryLimiter{limit: limit}
+	m.cond = sync.NewCond(&m.lock)
+	return m
+}
+
+// Acquire reserves n bytes, blocking until they are available
+func (m *MemoryLimiter) Acquire(n int64) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for !m.fits(n) {
+		m.cond.Wait()
+	}
+	m.used += n
+}
+
+// TryAcquire reserves n bytes if they are available, it returns false otherwise
+func (m *MemoryLimiter) TryAcquire(n int64) bool {
+	if m == nil {
+		return true
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.fits(n) {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+// Release frees n bytes reserved with Acquire or TryAcquire
+func (m *MemoryLimiter) Release(n int64) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.used -= n
+	m.lock.Unlock()
+	m.cond.Broadcast()
+}
+
+// fits returns true if n more bytes fit in the limit. A message bigger than the limit is let through
+// when nothing else is pending, so that it does not block forever.
+func (m *MemoryLimiter) fits(n int64) bool {
+	return m.used == 0 || m.used+n <= m.limit
+}
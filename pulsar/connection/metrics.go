@@ -0,0 +1,51 @@
+package connection
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are registered with the default prometheus registry, which is also the registry of the pulsar client
+// metrics, so that the health of the connection is scraped alongside them
+var (
+	clientsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_connection_clients_created_total",
+		Help: "Pulsar clients created, by service URL",
+	}, []string{"url"})
+	reconnectAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pulsar_connection_reconnect_attempts_total",
+		Help: "Attempts to reconnect to pulsar in the background",
+	})
+	connectedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pulsar_connection_connected",
+		Help: "Number of connected pulsar clients",
+	})
+	authRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_connection_auth_refreshes_total",
+		Help: "Refreshes of OAuth2 tokens and connection credentials, by kind and outcome",
+	}, []string{"kind", "outcome"})
+	openHandles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pulsar_connection_open",
+		Help: "Open producers and consumers, by kind",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(clientsCreated, reconnectAttempts, connectedClients, authRefreshes, openHandles, labelsInfo, tlsHandshakes, certificates)
+}
+
+func recordAuthRefresh(kind string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	authRefreshes.WithLabelValues(kind, outcome).Inc()
+}
+
+// handleKind returns the kind label of a producer or consumer
+func handleKind(handle interface{}) string {
+	if _, ok := handle.(pulsar.Producer); ok {
+		return "producer"
+	}
+	return "consumer"
+}
@@ -0,0 +1,84 @@
+package connection
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MetricTopicOther is the topic label of the topics beyond the metricTopicLimit
+const MetricTopicOther = "other"
+
+var partitionSuffix = regexp.MustCompile(`-partition-\d+$`)
+
+// topicAggregation reports the topics matching the pattern under one label
+type topicAggregation struct {
+	pattern *regexp.Regexp
+	label   string
+}
+
+// MetricTopics bounds the cardinality of the topic label of the metrics, which grows with every topic consumed by a
+// topics pattern handler or produced to by a dynamic topic: partition suffixes are dropped, topics are aggregated
+// by pattern and the distinct labels are limited, further topics are reported as "other".
+type MetricTopics struct {
+	dropPartitions bool
+	aggregations   []topicAggregation
+	limit          int
+
+	lock   sync.Mutex
+	labels map[string]bool
+}
+
+// NewMetricTopics parses the comma separated "<regular expression>=<label>" aggregations, e.g.
+// "persistent://public/default/orders-.*=orders". The regular expressions match the whole topic name, the first
+// matching aggregation applies. It returns nil if the topics are reported as is.
+func NewMetricTopics(dropPartitions bool, aggregations string, limit int) (*MetricTopics, error) {
+	m := &MetricTopics{dropPartitions: dropPartitions, limit: limit, labels: make(map[string]bool)}
+	for _, a := range strings.Split(aggregations, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		i := strings.LastIndex(a, "=")
+		if i <= 0 || i == len(a)-1 {
+			return nil, fmt.Errorf("invalid topic aggregation [%s], expected <regular expression>=<label>", a)
+		}
+		pattern, err := regexp.Compile("^(?:" + a[:i] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid topic aggregation [%s]: %v", a, err)
+		}
+		m.aggregations = append(m.aggregations, topicAggregation{pattern: pattern, label: a[i+1:]})
+	}
+	if !m.dropPartitions && len(m.aggregations) == 0 && m.limit <= 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Label returns the topic label of the metrics of a topic
+func (m *MetricTopics) Label(topic string) string {
+	if m == nil {
+		return topic
+	}
+	if m.dropPartitions {
+		topic = partitionSuffix.ReplaceAllString(topic, "")
+	}
+	for _, a := range m.aggregations {
+		if a.pattern.MatchString(topic) {
+			topic = a.label
+			break
+		}
+	}
+	if m.limit <= 0 {
+		return topic
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.labels[topic] {
+		if len(m.labels) >= m.limit {
+			return MetricTopicOther
+		}
+		m.labels[topic] = true
+	}
+	return topic
+}
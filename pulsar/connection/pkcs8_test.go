@@ -0,0 +1,134 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2Key(t *testing.T) {
+	// RFC 6070 test vectors
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 20, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, 20, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, 20, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2Key([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen, sha1.New))
+		if got != tt.want {
+			t.Errorf("pbkdf2Key(%s, %s, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestDecryptPKCS8(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aes256 := asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	hmacWithSHA256 := asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	tests := []struct {
+		name     string
+		der      []byte
+		password string
+		wantErr  string
+	}{
+		{"AES-256 with hmacWithSHA256", encryptPKCS8(t, plain, "secret", aes256, 32, aes.NewCipher, hmacWithSHA256, sha256.New), "secret", ""},
+		{"AES-128 with the default PRF", encryptPKCS8(t, plain, "secret", asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}, 16, aes.NewCipher, nil, sha1.New), "secret", ""},
+		{"3DES", encryptPKCS8(t, plain, "secret", asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}, 24, des.NewTripleDESCipher, oidHMACWithSHA1, sha1.New), "secret", ""},
+		{"wrong password", encryptPKCS8(t, plain, "secret", aes256, 32, aes.NewCipher, hmacWithSHA256, sha256.New), "other", "password is incorrect"},
+		{"unsupported PRF", encryptPKCS8(t, plain, "secret", aes256, 32, aes.NewCipher, asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}, sha256.New), "secret", "unsupported PBKDF2 function"},
+		{"unsupported cipher", encryptPKCS8(t, plain, "secret", asn1.ObjectIdentifier{1, 2, 3}, 32, aes.NewCipher, hmacWithSHA256, sha256.New), "secret", "unsupported cipher"},
+		{"not PBES2", marshalEncryptedKey(t, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 3}, asn1.RawValue{FullBytes: []byte{5, 0}}, []byte{1}), "secret", "only PBES2 is supported"},
+		{"not a key", []byte("not a key"), "secret", "invalid encrypted PKCS#8 key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptPKCS8(tt.der, tt.password)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decryptPKCS8() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Error("decryptPKCS8() did not return the private key")
+			}
+		})
+	}
+}
+
+// encryptPKCS8 encrypts the PKCS#8 private key with PBES2 as OpenSSL does, a nil prf leaves the default hmacWithSHA1
+func encryptPKCS8(t *testing.T, plain []byte, password string, cipherOID asn1.ObjectIdentifier, keyLen int, newBlock func([]byte) (cipher.Block, error), prf asn1.ObjectIdentifier, h func() hash.Hash) []byte {
+	t.Helper()
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+	block, err := newBlock(pbkdf2Key([]byte(password), salt, 2048, keyLen, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err = rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	padding := block.BlockSize() - len(plain)%block.BlockSize()
+	data := append(append([]byte(nil), plain...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdf := pbkdf2Params{Salt: salt, IterationCount: 2048}
+	if prf != nil {
+		kdf.PRF = pkix.AlgorithmIdentifier{Algorithm: prf, Parameters: asn1.NullRawValue}
+	}
+	params := pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: marshalRaw(t, kdf)},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: cipherOID, Parameters: marshalRaw(t, iv)},
+	}
+	return marshalEncryptedKey(t, oidPBES2, marshalRaw(t, params), data)
+}
+
+func marshalEncryptedKey(t *testing.T, algorithm asn1.ObjectIdentifier, params asn1.RawValue, data []byte) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm, Parameters: params}, EncryptedData: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func marshalRaw(t *testing.T, v interface{}) asn1.RawValue {
+	t.Helper()
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asn1.RawValue{FullBytes: der}
+}
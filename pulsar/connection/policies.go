@@ -0,0 +1,159 @@
+package connection
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PolicyViolationFail fails the startup of the trigger or activity when the topic policies are violated
+	PolicyViolationFail = "Fail"
+	// PolicyViolationWarn logs a warning when the topic policies are violated
+	PolicyViolationWarn = "Warn"
+)
+
+// TopicPolicies are the policies a topic is asserted to have at startup, to catch misconfigurations which would
+// silently lose data
+type TopicPolicies struct {
+	// RetentionMinutes is the minimum retention time, -1 for infinite, 0 if not asserted
+	RetentionMinutes int64
+	// RetentionSizeMB is the minimum retention size, -1 for infinite, 0 if not asserted
+	RetentionSizeMB int64
+	// Deduplication requires message deduplication to be enabled
+	Deduplication bool
+	// SchemaEnforced requires the namespace to reject producers without schema
+	SchemaEnforced bool
+}
+
+// ParseTopicPolicies parses a comma separated list of required policies like
+// "retentionMinutes>=1440,retentionSizeMB>=1024,deduplication,schemaEnforced", nil if empty
+func ParseTopicPolicies(spec string) (*TopicPolicies, error) {
+	policies := &TopicPolicies{}
+	empty := true
+	for _, policy := range strings.Split(spec, ",") {
+		policy = strings.TrimSpace(policy)
+		if policy == "" {
+			continue
+		}
+		empty = false
+		name, value := policy, ""
+		if i := strings.Index(policy, ">="); i >= 0 {
+			name, value = strings.TrimSpace(policy[:i]), strings.TrimSpace(policy[i+2:])
+		}
+		switch name {
+		case "retentionMinutes", "retentionSizeMB":
+			min, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || (min < 1 && min != -1) {
+				return nil, fmt.Errorf("invalid topic policy [%s]: expected %s>=N with N > 0, or -1 for infinite", policy, name)
+			}
+			if name == "retentionMinutes" {
+				policies.RetentionMinutes = min
+			} else {
+				policies.RetentionSizeMB = min
+			}
+		case "deduplication":
+			policies.Deduplication = true
+		case "schemaEnforced":
+			policies.SchemaEnforced = true
+		default:
+			return nil, fmt.Errorf("unknown topic policy [%s]", policy)
+		}
+	}
+	if empty {
+		return nil, nil
+	}
+	return policies, nil
+}
+
+// AssertTopicPolicies checks the policies applied to the topic, including those inherited from its namespace and
+// the brokers, with the admin API. All violations are returned in one error.
+func (p *PulsarConnManager) AssertTopicPolicies(topic string, policies *TopicPolicies) error {
+	if policies == nil {
+		return nil
+	}
+	admin, err := p.AdminClient()
+	if err != nil {
+		return err
+	}
+	qualified, err := NormalizeTopic(topic, true)
+	if err != nil {
+		return err
+	}
+	domain, rest := splitTopicDomain(qualified)
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("topic policies of legacy topic names are not supported: [%s]", topic)
+	}
+	topicPath := "/admin/v2/" + domain + "/" + rest
+	var violations []string
+	if policies.RetentionMinutes != 0 || policies.RetentionSizeMB != 0 {
+		retention := struct {
+			RetentionTimeInMinutes int64 `json:"retentionTimeInMinutes"`
+			RetentionSizeInMB      int64 `json:"retentionSizeInMB"`
+		}{}
+		if err = admin.Get(topicPath+"/retention?applied=true", &retention); err != nil {
+			return fmt.Errorf("unable to get the retention of topic [%s]: %v", topic, err)
+		}
+		if !satisfiesMinimum(retention.RetentionTimeInMinutes, policies.RetentionMinutes) {
+			violations = append(violations, fmt.Sprintf("retention time is %d minutes, at least %d required", retention.RetentionTimeInMinutes, policies.RetentionMinutes))
+		}
+		if !satisfiesMinimum(retention.RetentionSizeInMB, policies.RetentionSizeMB) {
+			violations = append(violations, fmt.Sprintf("retention size is %d MB, at least %d required", retention.RetentionSizeInMB, policies.RetentionSizeMB))
+		}
+	}
+	if policies.Deduplication {
+		var enabled *bool
+		if err = admin.Get(topicPath+"/deduplicationEnabled?applied=true", &enabled); err != nil {
+			return fmt.Errorf("unable to get the deduplication status of topic [%s]: %v", topic, err)
+		}
+		if enabled == nil || !*enabled {
+			violations = append(violations, "deduplication is not enabled")
+		}
+	}
+	if policies.SchemaEnforced {
+		var enforced bool
+		if err = admin.Get("/admin/v2/namespaces/"+parts[0]+"/"+parts[1]+"/schemaValidationEnforced", &enforced); err != nil {
+			return fmt.Errorf("unable to get the schema validation of namespace [%s/%s]: %v", parts[0], parts[1], err)
+		}
+		if !enforced {
+			violations = append(violations, "schema validation is not enforced")
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("topic [%s] violates the required policies: %s", topic, strings.Join(violations, ", "))
+	}
+	return nil
+}
+
+// CheckTopicPolicies asserts the policies of the policy spec at startup, violations fail the startup or are
+// logged as a warning depending on onViolation
+func (p *PulsarConnManager) CheckTopicPolicies(topic, spec, onViolation string) error {
+	policies, err := ParseTopicPolicies(spec)
+	if err != nil || policies == nil {
+		return err
+	}
+	err = p.AssertTopicPolicies(topic, policies)
+	if err != nil && onViolation == PolicyViolationWarn {
+		logger.Warnf("%v", err)
+		return nil
+	}
+	return err
+}
+
+func splitTopicDomain(qualified string) (string, string) {
+	i := strings.Index(qualified, "://")
+	return qualified[:i], qualified[i+3:]
+}
+
+// satisfiesMinimum compares retention values, where -1 is infinite
+func satisfiesMinimum(actual, min int64) bool {
+	switch {
+	case min == 0 || actual == -1:
+		return true
+	case min == -1:
+		return false
+	default:
+		return actual >= min
+	}
+}
@@ -0,0 +1,111 @@
+package connection
+
+import (
+	"context"
+	"strings"
+
+	"github.com/project-flogo/core/data"
+	"github.com/project-flogo/core/data/coerce"
+)
+
+type propagationKey struct{}
+
+// ContextPropagation carries allowlisted context values, e.g. the tenant and user id, as message properties,
+// so that they survive asynchronous hops between apps
+type ContextPropagation struct {
+	keys []string
+}
+
+// NewContextPropagation creates the propagation for comma separated property names, it returns nil if there are none
+func NewContextPropagation(allowlist string) *ContextPropagation {
+	var keys []string
+	for _, k := range strings.Split(allowlist, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return &ContextPropagation{keys: keys}
+}
+
+// Extract returns the allowlisted values found in the properties of a consumed message
+func (c *ContextPropagation) Extract(properties map[string]string) map[string]string {
+	if c == nil {
+		return nil
+	}
+	values := make(map[string]string)
+	for _, k := range c.keys {
+		if v, ok := properties[k]; ok {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// Inject adds the allowlisted values to the properties of a message to publish, properties set explicitly are kept
+func (c *ContextPropagation) Inject(values map[string]string, properties map[string]string) {
+	if c == nil {
+		return
+	}
+	for _, k := range c.keys {
+		if _, ok := properties[k]; ok {
+			continue
+		}
+		if v, ok := values[k]; ok && v != "" {
+			properties[k] = v
+		}
+	}
+}
+
+// Collect gathers the values to propagate when publishing from an activity. Values given explicitly take
+// precedence over the ones of the consumed message found in the Go context, if any, which flow activities do not
+// get. Otherwise they are looked up in the flow scope: in the "context" attribute, which the context output of the trigger is
+// usually mapped to, or in attributes named like the allowlisted properties.
+func (c *ContextPropagation) Collect(ctx context.Context, scope data.Scope, explicit map[string]string) map[string]string {
+	if c == nil {
+		return nil
+	}
+	values := make(map[string]string)
+	if scope != nil {
+		if v, ok := scope.GetValue("context"); ok {
+			if m, err := coerce.ToParams(v); err == nil {
+				for k, s := range m {
+					values[k] = s
+				}
+			}
+		}
+		for _, k := range c.keys {
+			if v, ok := scope.GetValue(k); ok {
+				if s, ok := v.(string); ok {
+					values[k] = s
+				}
+			}
+		}
+	}
+	for k, v := range ValuesFromContext(ctx) {
+		values[k] = v
+	}
+	for k, v := range explicit {
+		values[k] = v
+	}
+	return values
+}
+
+// NewContextWithValues returns a context carrying the propagated values of a consumed message
+func NewContextWithValues(ctx context.Context, values map[string]string) context.Context {
+	if len(values) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, propagationKey{}, values)
+}
+
+// ValuesFromContext returns the propagated values stored with NewContextWithValues, or nil
+func ValuesFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	values, _ := ctx.Value(propagationKey{}).(map[string]string)
+	return values
+}
@@ -0,0 +1,140 @@
+package connection
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/project-flogo/core/data/coerce"
+)
+
+const (
+	// PropertyEncodingFlat sends string values as is, other values are coerced to strings
+	PropertyEncodingFlat = "Flat"
+	// PropertyEncodingJSON sends non string values as JSON in the property value
+	PropertyEncodingJSON = "JSON"
+	// PropertyEncodingPrefixed flattens nested objects into properties with dotted names, e.g. "order.id"
+	PropertyEncodingPrefixed = "Prefixed"
+
+	propertySeparator = "."
+)
+
+// PropertyCodec encodes structured values into message properties on publish and decodes them on consume,
+// since Pulsar only carries flat string properties
+type PropertyCodec struct {
+	encoding string
+}
+
+// NewPropertyCodec creates a codec for the encoding, Flat if empty
+func NewPropertyCodec(encoding string) *PropertyCodec {
+	if encoding == "" {
+		encoding = PropertyEncodingFlat
+	}
+	return &PropertyCodec{encoding: encoding}
+}
+
+// Encoding returns the name of the encoding
+func (c *PropertyCodec) Encoding() string {
+	if c == nil {
+		return PropertyEncodingFlat
+	}
+	return c.encoding
+}
+
+// Encode adds the values to the properties
+func (c *PropertyCodec) Encode(values map[string]interface{}, properties map[string]string) error {
+	for name, value := range values {
+		switch c.Encoding() {
+		case PropertyEncodingJSON:
+			if s, ok := value.(string); ok {
+				properties[name] = s
+				continue
+			}
+			b, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			properties[name] = string(b)
+		case PropertyEncodingPrefixed:
+			if err := flattenProperty(name, value, properties); err != nil {
+				return err
+			}
+		default:
+			s, err := coerce.ToString(value)
+			if err != nil {
+				return err
+			}
+			properties[name] = s
+		}
+	}
+	return nil
+}
+
+// Decode returns the structured values of the properties of a consumed message, nil for the Flat encoding
+func (c *PropertyCodec) Decode(properties map[string]string) map[string]interface{} {
+	switch c.Encoding() {
+	case PropertyEncodingJSON:
+		values := make(map[string]interface{}, len(properties))
+		for name, s := range properties {
+			values[name] = s
+			if t := strings.TrimSpace(s); strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[") {
+				var v interface{}
+				if json.Unmarshal([]byte(t), &v) == nil {
+					values[name] = v
+				}
+			}
+		}
+		return values
+	case PropertyEncodingPrefixed:
+		values := make(map[string]interface{}, len(properties))
+		for name, s := range properties {
+			unflattenProperty(values, strings.Split(name, propertySeparator), name, s)
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func flattenProperty(name string, value interface{}, properties map[string]string) error {
+	if m, ok := value.(map[string]interface{}); ok {
+		for k, v := range m {
+			if err := flattenProperty(name+propertySeparator+k, v, properties); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, err := coerce.ToString(value)
+	if err != nil {
+		return err
+	}
+	properties[name] = s
+	return nil
+}
+
+// unflattenProperty stores the value at the path, a property whose name clashes with a nested object is kept
+// under its full name
+func unflattenProperty(values map[string]interface{}, path []string, name, value string) {
+	current := values
+	for _, segment := range path[:len(path)-1] {
+		next, ok := current[segment]
+		if !ok {
+			m := make(map[string]interface{})
+			current[segment] = m
+			current = m
+			continue
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			values[name] = value
+			return
+		}
+		current = m
+	}
+	last := path[len(path)-1]
+	if _, ok := current[last].(map[string]interface{}); ok {
+		values[name] = value
+		return
+	}
+	current[last] = value
+}
@@ -0,0 +1,316 @@
+package connection
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var defaultServicePorts = map[string]string{
+	"pulsar":     "6650",
+	"pulsar+ssl": "6651",
+	"http":       "8080",
+	"https":      "8443",
+}
+
+// proxyDialer opens a tunnel to the target address through a SOCKS5 or HTTP proxy
+type proxyDialer struct {
+	scheme   string
+	address  string
+	user     string
+	password string
+	timeout  time.Duration
+}
+
+func newProxyDialer(proxyURL, user, password string, timeout time.Duration) (*proxyDialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxyUrl [%s]: %v", proxyURL, err)
+	}
+	d := &proxyDialer{scheme: strings.ToLower(u.Scheme), address: u.Host, user: user, password: password, timeout: timeout}
+	switch d.scheme {
+	case "socks5", "socks5h":
+		if u.Port() == "" {
+			d.address = net.JoinHostPort(u.Hostname(), "1080")
+		}
+	case "http":
+		if u.Port() == "" {
+			d.address = net.JoinHostPort(u.Hostname(), "80")
+		}
+	default:
+		return nil, fmt.Errorf("invalid proxyUrl [%s]: the scheme must be socks5 or http", proxyURL)
+	}
+	if d.user == "" && u.User != nil {
+		d.user = u.User.Username()
+		d.password, _ = u.User.Password()
+	}
+	return d, nil
+}
+
+func (d *proxyDialer) dial(target string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", d.address, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(d.timeout))
+	tunnel := conn
+	if d.scheme == "http" {
+		tunnel, err = d.connectHTTP(conn, target)
+	} else {
+		err = d.connectSOCKS5(conn, target)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy [%s] failed to connect to [%s]: %v", d.address, target, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// connectSOCKS5 performs the SOCKS5 handshake (RFC 1928) with username/password authentication (RFC 1929)
+func (d *proxyDialer) connectSOCKS5(conn net.Conn, target string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	methods := []byte{0x00}
+	if d.user != "" {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err = conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if len(d.user) > 255 || len(d.password) > 255 {
+			return fmt.Errorf("proxy user or password too long")
+		}
+		auth := []byte{0x01, byte(len(d.user))}
+		auth = append(auth, d.user...)
+		auth = append(auth, byte(len(d.password)))
+		auth = append(auth, d.password...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("proxy authentication failed")
+		}
+	default:
+		return fmt.Errorf("no acceptable authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 0x01), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 0x04), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long")
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("SOCKS5 error %d", header[1])
+	}
+	var addrLen int
+	switch header[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		addrLen = int(length[0])
+	default:
+		return fmt.Errorf("unexpected SOCKS5 address type %d", header[3])
+	}
+	// the bound address and port are not needed
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// connectHTTP opens a tunnel with the HTTP CONNECT method
+func (d *proxyDialer) connectHTTP(conn net.Conn, target string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if d.user != "" {
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(d.user+":"+d.password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP CONNECT returned %s", resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns the bytes read ahead from the proxy before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// proxyForwarder accepts the connections of the pulsar client on a loopback port and tunnels them to a service
+// URL through the proxy, since the pulsar client has no dialer hook
+type proxyForwarder struct {
+	listener net.Listener
+	target   string
+	dialer   *proxyDialer
+	lock     sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+// startProxyForwarders starts a forwarder per service URL, it returns the service URLs rewritten to the
+// loopback ports of the forwarders
+func startProxyForwarders(serviceURLs []string, dialer *proxyDialer) ([]string, []*proxyForwarder, error) {
+	var urls []string
+	var forwarders []*proxyForwarder
+	for _, serviceURL := range serviceURLs {
+		u, err := url.Parse(serviceURL)
+		if err != nil {
+			closeProxyForwarders(forwarders)
+			return nil, nil, fmt.Errorf("invalid service URL [%s]: %v", serviceURL, err)
+		}
+		target := u.Host
+		if u.Port() == "" {
+			target = net.JoinHostPort(u.Hostname(), defaultServicePorts[u.Scheme])
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			closeProxyForwarders(forwarders)
+			return nil, nil, err
+		}
+		f := &proxyForwarder{listener: listener, target: target, dialer: dialer, conns: make(map[net.Conn]struct{})}
+		go f.run()
+		forwarders = append(forwarders, f)
+		u.Host = listener.Addr().String()
+		urls = append(urls, u.String())
+		logger.Infof("Connecting to [%s] through proxy [%s]", target, dialer.address)
+	}
+	return urls, forwarders, nil
+}
+
+func closeProxyForwarders(forwarders []*proxyForwarder) {
+	for _, f := range forwarders {
+		f.close()
+	}
+}
+
+func (f *proxyForwarder) run() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			// closed
+			return
+		}
+		go f.forward(conn)
+	}
+}
+
+func (f *proxyForwarder) forward(conn net.Conn) {
+	upstream, err := f.dialer.dial(f.target)
+	if err != nil {
+		logger.Warnf("%v", err)
+		conn.Close()
+		return
+	}
+	if !f.track(conn, upstream) {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	defer f.untrack(conn, upstream)
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pipe(upstream, conn)
+	go pipe(conn, upstream)
+	// either side closed the connection
+	<-done
+	conn.Close()
+	upstream.Close()
+	<-done
+}
+
+func (f *proxyForwarder) track(conns ...net.Conn) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.conns == nil {
+		return false
+	}
+	for _, c := range conns {
+		f.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (f *proxyForwarder) untrack(conns ...net.Conn) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, c := range conns {
+		delete(f.conns, c)
+	}
+}
+
+// close stops accepting connections and closes the tunnels
+func (f *proxyForwarder) close() {
+	f.listener.Close()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
@@ -0,0 +1,464 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const (
+	defaultReconnectBackoff    = 1000
+	defaultReconnectMaxBackoff = 60000
+	defaultReconnectJitter     = 20
+	clientCreationTimeout      = 30 * time.Second
+)
+
+// ErrReconnectGaveUp is returned once reconnecting was given up after reconnectMaxAttempts
+var ErrReconnectGaveUp = errors.New("giving up connecting to pulsar")
+
+// backoff computes exponentially growing delays with random jitter
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	jitter  float64
+}
+
+func newBackoff(initialMs, maxMs, jitterPercent int) backoff {
+	if initialMs <= 0 {
+		initialMs = defaultReconnectBackoff
+	}
+	if maxMs <= 0 {
+		maxMs = defaultReconnectMaxBackoff
+	}
+	if jitterPercent < 0 || jitterPercent > 100 {
+		jitterPercent = defaultReconnectJitter
+	}
+	return backoff{
+		initial: time.Duration(initialMs) * time.Millisecond,
+		max:     time.Duration(maxMs) * time.Millisecond,
+		jitter:  float64(jitterPercent) / 100,
+	}
+}
+
+// delay returns the delay before the given attempt, starting at 0
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.max
+	if attempt < 32 {
+		if exp := b.initial << uint(attempt); exp > 0 && exp < b.max {
+			d = exp
+		}
+	}
+	if b.jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * b.jitter * float64(d))
+	}
+	return d
+}
+
+// reconnector owns the pulsar client of a connection. When the client cannot be created, or triggers and
+// activities report that producer/consumer creation failed, it reconnects in the background with exponential
+// backoff, failing over to the next service URL, and notifies everyone waiting for the connection.
+type reconnector struct {
+	opts        pulsar.ClientOptions
+	failover    *urlFailover
+	backoff     backoff
+	maxAttempts int
+
+	lock      sync.Mutex
+	client    pulsar.Client
+	err       error
+	failures  int
+	connected chan struct{}
+	running   bool
+	stop      chan struct{}
+	// producers and consumers per client, a client is closed along with its last one once the
+	// connection was released or the client was retired by a refresh
+	refs      map[pulsar.Client]int
+	owners    map[interface{}]pulsar.Client
+	released  func()
+	retired   map[pulsar.Client]func()
+	refreshed chan struct{}
+}
+
+func newReconnector(opts pulsar.ClientOptions, failover *urlFailover, b backoff, maxAttempts int) *reconnector {
+	return &reconnector{
+		opts:        opts,
+		failover:    failover,
+		backoff:     b,
+		maxAttempts: maxAttempts,
+		connected:   make(chan struct{}),
+		stop:        make(chan struct{}),
+		refs:        make(map[pulsar.Client]int),
+		owners:      make(map[interface{}]pulsar.Client),
+		retired:     make(map[pulsar.Client]func()),
+		refreshed:   make(chan struct{}),
+	}
+}
+
+// open allows reconnecting again after close, e.g. when the engine is restarted
+func (r *reconnector) open() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	select {
+	case <-r.stop:
+		r.stop = make(chan struct{})
+		r.err = nil
+		r.failures = 0
+	default:
+	}
+}
+
+// connect creates the client synchronously, used when the connection is started
+func (r *reconnector) connect() (pulsar.Client, error) {
+	url := r.failover.current()
+	client, err := r.newClient(r.options(), url)
+	if err != nil {
+		return nil, err
+	}
+	r.connectedTo(client, url)
+	return client, nil
+}
+
+func (r *reconnector) options() pulsar.ClientOptions {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.opts
+}
+
+// Client returns the current client, nil if not connected
+func (r *reconnector) Client() (pulsar.Client, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.client, r.err
+}
+
+// Connected returns a channel which is closed once the client is connected, or reconnecting was given up.
+// It starts reconnecting in the background if needed.
+func (r *reconnector) Connected() <-chan struct{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.client == nil && r.err == nil {
+		r.startLocked()
+	}
+	return r.connected
+}
+
+// Failed reports that the client could not be used. Waiters are notified again once the background
+// reconnect, delayed by the backoff, succeeded.
+func (r *reconnector) Failed(client pulsar.Client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if client != nil && client != r.client {
+		// already replaced
+		return
+	}
+	r.failures++
+	if r.client != nil && r.failover.size() > 1 {
+		// fail over to the next service URL with a new client, the current one is retired and closed along
+		// with the last of its producers and consumers, once they were re-created with the new client
+		r.failover.next(r.failover.current())
+		r.retireLocked()
+	}
+	select {
+	case <-r.connected:
+		r.connected = make(chan struct{})
+	default:
+	}
+	r.startLocked()
+}
+
+// Succeeded resets the backoff once the client was used successfully
+func (r *reconnector) Succeeded() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.failures = 0
+}
+
+func (r *reconnector) startLocked() {
+	if r.running || r.err != nil {
+		return
+	}
+	r.running = true
+	go r.run()
+}
+
+func (r *reconnector) run() {
+	for {
+		r.lock.Lock()
+		attempt, client, stop := r.failures, r.client, r.stop
+		r.lock.Unlock()
+		if r.maxAttempts > 0 && attempt > r.maxAttempts {
+			r.giveUp(fmt.Errorf("%w after %d attempts", ErrReconnectGaveUp, r.maxAttempts))
+			return
+		}
+		if attempt > 0 {
+			reconnectAttempts.Inc()
+			delay := r.backoff.delay(attempt - 1)
+			logger.Infof("Reconnecting to pulsar in %v (attempt %d)", delay, attempt)
+			select {
+			case <-time.After(delay):
+			case <-stop:
+				r.lock.Lock()
+				r.running = false
+				r.lock.Unlock()
+				return
+			}
+		}
+
+		url := r.failover.current()
+		if client == nil {
+			var err error
+			if client, err = r.newClient(r.options(), url); err != nil {
+				logger.Warnf("Connection to [%s] failed: %v", url, err)
+				r.failover.next(url)
+				r.lock.Lock()
+				r.failures++
+				r.lock.Unlock()
+				continue
+			}
+		}
+		r.connectedTo(client, url)
+		return
+	}
+}
+
+func (r *reconnector) connectedTo(client pulsar.Client, url string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.client != nil && client != r.client {
+		// connected meanwhile by a refresh
+		client.Close()
+		r.running = false
+		return
+	}
+	if client != r.client {
+		clientsCreated.WithLabelValues(url).Inc()
+		logger.Infof("Connected to [%s]", url)
+	}
+	r.setClientLocked(client)
+	r.running = false
+	select {
+	case <-r.connected:
+	default:
+		close(r.connected)
+	}
+}
+
+func (r *reconnector) giveUp(err error) {
+	logger.Error(err.Error())
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.err = err
+	r.running = false
+	select {
+	case <-r.connected:
+	default:
+		close(r.connected)
+	}
+}
+
+// newClient creates a client for the service URL, timing out after 30 seconds
+func (r *reconnector) newClient(opts pulsar.ClientOptions, url string) (pulsar.Client, error) {
+	opts.URL = url
+	logger.Infof("attempting to create client for [%s]", url)
+	type ClientInfo struct {
+		client pulsar.Client
+		err    error
+	}
+	infoChan := make(chan ClientInfo, 1)
+	go func() {
+		client, err := pulsar.NewClient(opts)
+		infoChan <- ClientInfo{client: client, err: err}
+	}()
+	select {
+	case data := <-infoChan:
+		if data.err == nil {
+			go probeTLS(r, opts, url)
+		}
+		return data.client, data.err
+	case <-time.After(clientCreationTimeout):
+		return nil, fmt.Errorf("client creation has %w after 30 seconds", errTimedOut)
+	}
+}
+
+// swap replaces the client with one created from the given options, e.g. with rotated credentials. The previous
+// client is retired, it is closed along with its last producer or consumer and the callback is invoked then.
+func (r *reconnector) swap(opts pulsar.ClientOptions, retired func()) error {
+	url := r.failover.current()
+	client, err := r.newClient(opts, url)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	previous := r.client
+	r.opts = opts
+	r.setClientLocked(client)
+	r.err = nil
+	r.failures = 0
+	clientsCreated.WithLabelValues(url).Inc()
+	logger.Infof("Connected to [%s] with refreshed credentials", url)
+	if previous == nil {
+		if retired != nil {
+			retired()
+		}
+	} else if r.refs[previous] == 0 {
+		closeRetired(previous, retired)
+	} else {
+		r.retired[previous] = retired
+		logger.Infof("Previous pulsar client is closed once its %d producer(s) and consumer(s) are closed", r.refs[previous])
+	}
+	select {
+	case <-r.connected:
+	default:
+		close(r.connected)
+	}
+	close(r.refreshed)
+	r.refreshed = make(chan struct{})
+	return nil
+}
+
+// Refreshed returns a channel which is closed when the client is swapped by the next refresh
+func (r *reconnector) Refreshed() <-chan struct{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.refreshed
+}
+
+// isRetired returns true if the producer or consumer was created with a client replaced by a refresh, a failover
+// or a restart
+func (r *reconnector) isRetired(handle interface{}) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	client, ok := r.owners[handle]
+	if !ok {
+		return false
+	}
+	_, retired := r.retired[client]
+	return retired
+}
+
+// isCurrent returns true if the client was not replaced meanwhile
+func (r *reconnector) isCurrent(client pulsar.Client) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return client == r.client
+}
+
+func closeRetired(client pulsar.Client, callback func()) {
+	client.Close()
+	logger.Info("Previous pulsar client closed")
+	if callback != nil {
+		callback()
+	}
+}
+
+// retireLocked closes the current client right away if no producer or consumer uses it, otherwise along with the
+// last one, and forgets it
+func (r *reconnector) retireLocked() {
+	if r.client == nil {
+		return
+	}
+	if r.refs[r.client] == 0 {
+		closeRetired(r.client, nil)
+	} else if _, ok := r.retired[r.client]; !ok {
+		r.retired[r.client] = nil
+	}
+	r.setClientLocked(nil)
+}
+
+// setClientLocked replaces the current client, keeping track of the connected clients
+func (r *reconnector) setClientLocked(client pulsar.Client) {
+	if r.client == nil && client != nil {
+		connectedClients.Inc()
+	} else if r.client != nil && client == nil {
+		connectedClients.Dec()
+	}
+	r.client = client
+}
+
+// acquire counts a producer or consumer created with the client
+func (r *reconnector) acquire(handle interface{}, client pulsar.Client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.owners[handle] = client
+	r.refs[client]++
+	openHandles.WithLabelValues(handleKind(handle)).Inc()
+}
+
+// release is called when a producer or consumer was closed
+func (r *reconnector) release(handle interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	client, ok := r.owners[handle]
+	if !ok {
+		return
+	}
+	delete(r.owners, handle)
+	openHandles.WithLabelValues(handleKind(handle)).Dec()
+	if r.refs[client]--; r.refs[client] > 0 {
+		return
+	}
+	delete(r.refs, client)
+	if callback, ok := r.retired[client]; ok {
+		delete(r.retired, client)
+		closeRetired(client, callback)
+	} else if client == r.client && r.released != nil {
+		r.closeClientLocked()
+	}
+}
+
+// closeWhenReleased closes the client right away if no producer or consumer uses it, otherwise along with
+// the last one. The callback is invoked once the client is closed.
+func (r *reconnector) closeWhenReleased(callback func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.released = callback
+	if r.refs[r.client] == 0 {
+		r.closeClientLocked()
+	} else {
+		logger.Infof("Pulsar client is closed once its %d producer(s) and consumer(s) are closed", r.refs[r.client])
+	}
+}
+
+func (r *reconnector) closeClientLocked() {
+	if r.client != nil {
+		r.client.Close()
+		r.setClientLocked(nil)
+		logger.Info("Pulsar client closed")
+	}
+	select {
+	case <-r.connected:
+		r.connected = make(chan struct{})
+	default:
+	}
+	if r.released != nil {
+		r.released()
+		r.released = nil
+	}
+}
+
+// close stops reconnecting and retires the current client, which is closed along with its last producer or
+// consumer, a new client is created when the connection is started again
+func (r *reconnector) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	if r.released == nil {
+		r.retireLocked()
+	}
+	certificates.remove(r)
+	select {
+	case <-r.connected:
+		r.connected = make(chan struct{})
+	default:
+	}
+}
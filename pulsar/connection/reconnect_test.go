@@ -0,0 +1,231 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// reachableURL is accepted by the client, which only connects to the brokers on the first lookup
+const reachableURL = "pulsar://127.0.0.1:1"
+
+func TestBackoffDelay(t *testing.T) {
+	b := newBackoff(100, 1000, 0)
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{31, time.Second},
+		{64, time.Second},
+	}
+	for _, tt := range tests {
+		if got := b.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := newBackoff(1000, 1000, 20)
+	for i := 0; i < 100; i++ {
+		if d := b.delay(0); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("delay(0) = %v, want within 20%% of 1s", d)
+		}
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	tests := []struct {
+		name                 string
+		initial, max, jitter int
+		wantInitial, wantMax time.Duration
+		wantJitter           float64
+	}{
+		{"defaults", 0, 0, -1, time.Second, time.Minute, 0.2},
+		{"explicit", 50, 500, 0, 50 * time.Millisecond, 500 * time.Millisecond, 0},
+		{"jitter out of range", 50, 500, 150, 50 * time.Millisecond, 500 * time.Millisecond, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(tt.initial, tt.max, tt.jitter)
+			if b.initial != tt.wantInitial || b.max != tt.wantMax || b.jitter != tt.wantJitter {
+				t.Errorf("newBackoff(%d, %d, %d) = %+v", tt.initial, tt.max, tt.jitter, b)
+			}
+		})
+	}
+}
+
+func TestURLFailover(t *testing.T) {
+	tests := []struct {
+		name   string
+		urls   string
+		failed []string
+		want   []string
+	}{
+		{"single URL stays", "pulsar://a:6650", []string{"pulsar://a:6650"}, []string{"pulsar://a:6650"}},
+		{"rotates", "pulsar://a:6650,pulsar://b:6650", []string{"pulsar://a:6650", "pulsar://b:6650"}, []string{"pulsar://b:6650", "pulsar://a:6650"}},
+		{"inherits the scheme", "pulsar+ssl://a:6651, b:6651", []string{"pulsar+ssl://a:6651"}, []string{"pulsar+ssl://b:6651"}},
+		{"stale failure rotates once", "pulsar://a:6650,pulsar://b:6650,pulsar://c:6650", []string{"pulsar://a:6650", "pulsar://a:6650"}, []string{"pulsar://b:6650", "pulsar://b:6650"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newURLFailover(tt.urls)
+			for i, failed := range tt.failed {
+				if got := f.next(failed); got != tt.want[i] {
+					t.Errorf("next(%s) = %s, want %s", failed, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReconnectorConnect(t *testing.T) {
+	tests := []struct {
+		name        string
+		urls        string
+		maxAttempts int
+		wantURL     string
+		wantGaveUp  bool
+	}{
+		{"connects", reachableURL, 0, reachableURL, false},
+		{"fails over to the next URL", "bad://a," + reachableURL, 0, reachableURL, false},
+		{"gives up after maxAttempts", "bad://a,bad://b", 3, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReconnector(pulsar.ClientOptions{}, newURLFailover(tt.urls), newBackoff(1, 1, 0), tt.maxAttempts)
+			defer r.close()
+			select {
+			case <-r.Connected():
+			case <-time.After(5 * time.Second):
+				t.Fatal("not connected nor given up in time")
+			}
+			client, err := r.Client()
+			if tt.wantGaveUp {
+				if !errors.Is(err, ErrReconnectGaveUp) || client != nil {
+					t.Fatalf("Client() = %v, %v, want ErrReconnectGaveUp", client, err)
+				}
+				return
+			}
+			if err != nil || client == nil {
+				t.Fatalf("Client() = %v, %v, want a client", client, err)
+			}
+			if got := r.failover.current(); got != tt.wantURL {
+				t.Errorf("connected to %s, want %s", got, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestReconnectorFailed(t *testing.T) {
+	tests := []struct {
+		name        string
+		urls        string
+		stale       bool
+		wantURL     string
+		wantReplace bool
+	}{
+		{"single URL keeps the client", reachableURL, false, reachableURL, false},
+		{"fails over and replaces the client", reachableURL + ",pulsar://127.0.0.2:1", false, "pulsar://127.0.0.2:1", true},
+		{"ignores a replaced client", reachableURL + ",pulsar://127.0.0.2:1", true, reachableURL, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReconnector(pulsar.ClientOptions{}, newURLFailover(tt.urls), newBackoff(1, 1, 0), 0)
+			defer r.close()
+			client, err := r.connect()
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed := client
+			if tt.stale {
+				failed = &stubClient{}
+			}
+			r.Failed(failed)
+			select {
+			case <-r.Connected():
+			case <-time.After(5 * time.Second):
+				t.Fatal("not reconnected in time")
+			}
+			current, err := r.Client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced := current != client; replaced != tt.wantReplace {
+				t.Errorf("client replaced = %v, want %v", replaced, tt.wantReplace)
+			}
+			if got := r.failover.current(); got != tt.wantURL {
+				t.Errorf("connected to %s, want %s", got, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestReconnectorRetiresReferencedClient(t *testing.T) {
+	r := newReconnector(pulsar.ClientOptions{}, newURLFailover(reachableURL+",pulsar://127.0.0.2:1"), newBackoff(1, 1, 0), 0)
+	defer r.close()
+	stub := &stubClient{}
+	r.lock.Lock()
+	r.setClientLocked(stub)
+	r.lock.Unlock()
+	handle := new(int)
+	r.acquire(handle, stub)
+
+	r.Failed(stub)
+	if stub.closed {
+		t.Fatal("client closed while a producer or consumer still uses it")
+	}
+	if !r.isRetired(handle) {
+		t.Fatal("producer or consumer of the replaced client not reported as retired")
+	}
+	r.release(handle)
+	if !stub.closed {
+		t.Fatal("replaced client not closed along with its last producer or consumer")
+	}
+}
+
+func TestIsConnectivityError(t *testing.T) {
+	_, invalid := pulsar.NewClient(pulsar.ClientOptions{URL: "bad://a"})
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"creation timeout", fmt.Errorf("producer creation has %w after 30 seconds", errTimedOut), true},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("refused")}, true},
+		{"lost connection", errors.New("connection closed"), true},
+		{"request timeout", errors.New("request timed out"), true},
+		{"invalid configuration", invalid, false},
+		{"consumer busy", errors.New("server error: ConsumerBusy: Exclusive consumer is already connected"), false},
+		{"not authorized", errors.New("server error: AuthorizationError: Don't have permission"), false},
+		{"incompatible schema", errors.New("server error: IncompatibleSchema: Trying to subscribe with incompatible schema"), false},
+		{"topic not found", errors.New("server error: TopicNotFound: Topic does not exist"), false},
+		{"gave up", fmt.Errorf("%w after 3 attempts", ErrReconnectGaveUp), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConnectivityError(tt.err); got != tt.want {
+				t.Errorf("IsConnectivityError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// stubClient is a pulsar client which is never connected
+type stubClient struct {
+	pulsar.Client
+	closed bool
+}
+
+func (c *stubClient) Close() {
+	c.closed = true
+}
@@ -0,0 +1,122 @@
+package connection
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 500
+	defaultMaxBackoff     = 10000
+)
+
+// permanentResults are the errors of the client which retrying does not resolve
+var permanentResults = map[pulsar.Result]bool{
+	pulsar.InvalidConfiguration:    true,
+	pulsar.AuthenticationError:     true,
+	pulsar.AuthorizationError:      true,
+	pulsar.InvalidTopicName:        true,
+	pulsar.InvalidURL:              true,
+	pulsar.TopicTerminated:         true,
+	pulsar.OperationNotSupported:   true,
+	pulsar.UnsupportedVersionError: true,
+	pulsar.CryptoError:             true,
+}
+
+// permanentServerErrors are reported by the brokers as text, e.g. "server error: AuthorizationError: ..."
+var permanentServerErrors = []string{"AuthenticationError", "AuthorizationError", "TopicTerminatedError", "IncompatibleSchema", "InvalidTopicName", "NotAllowedError"}
+
+// connectivityResults are the errors of the client caused by brokers which are unreachable or too slow to answer
+var connectivityResults = map[pulsar.Result]bool{
+	pulsar.TimeoutError:      true,
+	pulsar.ConnectError:      true,
+	pulsar.ReadError:         true,
+	pulsar.NotConnectedError: true,
+}
+
+// connectivityErrors are reported by the client as text, e.g. when the connection to the broker owning the topic
+// could not be established or was lost during the lookup
+var connectivityErrors = []string{"connection error", "connection closed", "request timed out", "connection refused", "no such host", "i/o timeout", "ServiceNotReady"}
+
+// errTimedOut is returned when the client did not answer a producer, consumer or client creation in time
+var errTimedOut = errors.New("timed out")
+
+// operationRetry governs how GetProducer and GetSubscriber retry transient lookup and creation failures
+type operationRetry struct {
+	maxRetries int
+	backoff    backoff
+}
+
+func newOperationRetry(maxRetries, initialBackoffMs, maxBackoffMs int) operationRetry {
+	if maxRetries < 0 {
+		maxRetries = defaultMaxRetries
+	}
+	if initialBackoffMs <= 0 {
+		initialBackoffMs = defaultInitialBackoff
+	}
+	if maxBackoffMs <= 0 {
+		maxBackoffMs = defaultMaxBackoff
+	}
+	return operationRetry{maxRetries: maxRetries, backoff: newBackoff(initialBackoffMs, maxBackoffMs, defaultReconnectJitter)}
+}
+
+// do runs the operation until it succeeds, fails permanently or the retries are exhausted
+func (r operationRetry) do(operation string, fn func() error) error {
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil || retry >= r.maxRetries || !isTransient(err) {
+			return err
+		}
+		delay := r.backoff.delay(retry)
+		logger.Warnf("%s failed, retrying in %v (retry %d of %d): %v", operation, delay, retry+1, r.maxRetries, err)
+		time.Sleep(delay)
+	}
+}
+
+// isTransient returns false for failures which retrying does not resolve, e.g. missing permissions
+func isTransient(err error) bool {
+	if errors.Is(err, ErrReconnectGaveUp) {
+		return false
+	}
+	var pulsarErr *pulsar.Error
+	if errors.As(err, &pulsarErr) && permanentResults[pulsarErr.Result()] {
+		return false
+	}
+	for _, e := range permanentServerErrors {
+		if strings.Contains(err.Error(), e) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsConnectivityError returns true for failures caused by the brokers of the current service URL being unreachable,
+// which reconnecting or failing over may resolve. Errors such as missing permissions, an incompatible schema or a
+// busy Exclusive subscription concern a single producer or consumer and leave the client as is.
+func IsConnectivityError(err error) bool {
+	if err == nil || errors.Is(err, ErrReconnectGaveUp) {
+		return false
+	}
+	if errors.Is(err, errTimedOut) {
+		return true
+	}
+	var pulsarErr *pulsar.Error
+	if errors.As(err, &pulsarErr) {
+		return connectivityResults[pulsarErr.Result()]
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	for _, e := range connectivityErrors {
+		if strings.Contains(err.Error(), e) {
+			return true
+		}
+	}
+	return false
+}
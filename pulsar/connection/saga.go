@@ -0,0 +1,106 @@
+package connection
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/project-flogo/core/data/coerce"
+)
+
+// Properties correlating the messages of a saga
+const (
+	PropertySagaID                = "SAGA_ID"
+	PropertySagaStep              = "SAGA_STEP"
+	PropertySagaCompensationTopic = "SAGA_COMPENSATION_TOPIC"
+	PropertySagaCompensating      = "SAGA_COMPENSATING"
+)
+
+// Saga is the position of a message in a saga: the id correlating the steps, the number of the step, the topic the
+// compensating flows consume from and whether the message compensates a failed step
+type Saga struct {
+	ID                string
+	Step              int
+	CompensationTopic string
+	Compensating      bool
+}
+
+// SagaFromProperties returns the saga of a message, nil if the message is not part of one
+func SagaFromProperties(props map[string]string) *Saga {
+	id := props[PropertySagaID]
+	if id == "" {
+		return nil
+	}
+	step, _ := strconv.Atoi(props[PropertySagaStep])
+	compensating, _ := strconv.ParseBool(props[PropertySagaCompensating])
+	return &Saga{ID: id, Step: step, CompensationTopic: props[PropertySagaCompensationTopic], Compensating: compensating}
+}
+
+// SagaFromMap returns the saga of a mapped object, e.g. the saga output of the trigger, nil if it has no id
+func SagaFromMap(values map[string]interface{}) (*Saga, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	s := &Saga{}
+	var err error
+	if s.ID, err = coerce.ToString(values["id"]); err != nil {
+		return nil, fmt.Errorf("invalid saga id: %v", err)
+	}
+	if s.Step, err = coerce.ToInt(values["step"]); err != nil {
+		return nil, fmt.Errorf("invalid saga step: %v", err)
+	}
+	if s.CompensationTopic, err = coerce.ToString(values["compensationTopic"]); err != nil {
+		return nil, fmt.Errorf("invalid saga compensation topic: %v", err)
+	}
+	if s.Compensating, err = coerce.ToBool(values["compensating"]); err != nil {
+		return nil, fmt.Errorf("invalid saga compensating flag: %v", err)
+	}
+	if s.ID == "" {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// NewSaga starts a saga with a random id at step 1
+func NewSaga(compensationTopic string) *Saga {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return &Saga{ID: hex.EncodeToString(b), Step: 1, CompensationTopic: compensationTopic}
+}
+
+// Next returns the following step of the saga, compensating messages keep their step so that the compensating
+// flows know which step failed
+func (s *Saga) Next() *Saga {
+	next := *s
+	if !s.Compensating {
+		next.Step++
+	}
+	return &next
+}
+
+// Inject stamps the saga on the message properties
+func (s *Saga) Inject(props map[string]string) {
+	props[PropertySagaID] = s.ID
+	props[PropertySagaStep] = strconv.Itoa(s.Step)
+	if s.CompensationTopic != "" {
+		props[PropertySagaCompensationTopic] = s.CompensationTopic
+	} else {
+		delete(props, PropertySagaCompensationTopic)
+	}
+	if s.Compensating {
+		props[PropertySagaCompensating] = "true"
+	} else {
+		delete(props, PropertySagaCompensating)
+	}
+}
+
+// ToMap returns the saga as mapped in flows
+func (s *Saga) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":                s.ID,
+		"step":              s.Step,
+		"compensationTopic": s.CompensationTopic,
+		"compensating":      s.Compensating,
+	}
+}
@@ -0,0 +1,213 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretResolver resolves a secret reference like "vault://secret/data/pulsar#jwt" to the secret value.
+// The reference passed to Resolve is the part after "scheme://".
+type SecretResolver interface {
+	Resolve(reference string) ([]byte, error)
+}
+
+// SecretResolverFunc adapts a function to a SecretResolver
+type SecretResolverFunc func(reference string) ([]byte, error)
+
+// Resolve implements SecretResolver.Resolve
+func (f SecretResolverFunc) Resolve(reference string) ([]byte, error) {
+	return f(reference)
+}
+
+var (
+	secretResolversLock sync.RWMutex
+	secretResolvers     = map[string]SecretResolver{
+		"vault": SecretResolverFunc(resolveVaultSecret),
+		"k8s":   SecretResolverFunc(resolveKubernetesSecret),
+	}
+)
+
+// RegisterSecretResolver registers the resolver for secret references with the given URI scheme, e.g. "awssm".
+// Registering a resolver for an existing scheme replaces it.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) error {
+	if scheme == "" || resolver == nil {
+		return fmt.Errorf("scheme and resolver are required")
+	}
+	secretResolversLock.Lock()
+	defer secretResolversLock.Unlock()
+	secretResolvers[scheme] = resolver
+	return nil
+}
+
+func getSecretResolver(scheme string) SecretResolver {
+	secretResolversLock.RLock()
+	defer secretResolversLock.RUnlock()
+	return secretResolvers[scheme]
+}
+
+// resolveSecret returns the secret for a reference with a registered scheme. Other values are returned as is.
+func resolveSecret(value string) ([]byte, bool, error) {
+	i := strings.Index(value, "://")
+	if i <= 0 {
+		return nil, false, nil
+	}
+	resolver := getSecretResolver(value[:i])
+	if resolver == nil {
+		return nil, false, nil
+	}
+	secret, err := resolver.Resolve(value[i+3:])
+	if err != nil {
+		return nil, true, fmt.Errorf("unable to resolve secret [%s]: %v", value, err)
+	}
+	return secret, true, nil
+}
+
+// resolveSecrets replaces secret references in the certificate, key and token settings by the secrets. Certificates
+// and keys are provided like file settings of the Flogo UI, so that they are written to the temporary keystore.
+func resolveSecrets(s *Settings) error {
+	for _, setting := range []*string{&s.CaCert, &s.CertFile, &s.KeyFile, &s.PrivateKey} {
+		secret, ok, err := resolveSecret(*setting)
+		if err != nil {
+			return err
+		}
+		if ok {
+			content, _ := json.Marshal(map[string]string{"content": "data:;base64," + base64.StdEncoding.EncodeToString(secret)})
+			*setting = string(content)
+		}
+	}
+	for _, setting := range []*string{&s.JWT, &s.ProxyPassword} {
+		secret, ok, err := resolveSecret(*setting)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*setting = strings.TrimSpace(string(secret))
+		}
+	}
+	return nil
+}
+
+// splitSecretReference splits "path#key" into the path and the key
+func splitSecretReference(reference string) (string, string, error) {
+	i := strings.LastIndex(reference, "#")
+	if i <= 0 || i == len(reference)-1 {
+		return "", "", fmt.Errorf("expected path#key")
+	}
+	return reference[:i], reference[i+1:], nil
+}
+
+var secretHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// resolveVaultSecret reads a key of a HashiCorp Vault secret, e.g. "secret/data/pulsar#jwt", from the server at
+// VAULT_ADDR with the token in VAULT_TOKEN. Both KV version 1 and 2 secret engines are supported.
+func resolveVaultSecret(reference string) ([]byte, error) {
+	path, key, err := splitSecretReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequest(http.MethodGet, addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = getJSON(secretHTTPClient, req, &body); err != nil {
+		return nil, err
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		// KV version 2
+		data = nested
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return nil, fmt.Errorf("key [%s] not found", key)
+	}
+	return []byte(value), nil
+}
+
+const kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// resolveKubernetesSecret reads a key of a Kubernetes secret, e.g. "namespace/name#tls.crt", from the API server
+// with the service account of the pod. The namespace of the pod is used for references like "name#key".
+func resolveKubernetesSecret(reference string) ([]byte, error) {
+	path, key, err := splitSecretReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	namespace, name := "", path
+	if i := strings.Index(path, "/"); i >= 0 {
+		namespace, name = path[:i], path[i+1:]
+	} else {
+		ns, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("namespace not given and not running in a pod: %v", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	token, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+	client := &http.Client{
+		Timeout:   secretHTTPClient.Timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" {
+		host, port = "kubernetes.default.svc", "443"
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s:%s/api/v1/namespaces/%s/secrets/%s", host, port, namespace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err = getJSON(client, req, &secret); err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("key [%s] not found", key)
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,156 @@
+package connection
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// EnvShutdownTimeout overrides the global deadline (in seconds) for draining in-flight work on engine stop
+const EnvShutdownTimeout = "FLOGO_PULSAR_SHUTDOWN_TIMEOUT"
+
+const defaultShutdownTimeout = 30
+
+// Drainer is implemented by consumers of pulsar messages (e.g. trigger handlers) which
+// take part in the coordinated shutdown
+type Drainer interface {
+	// StopIntake stops receiving new messages
+	StopIntake()
+	// AwaitInFlight waits until all in-flight messages are processed or the deadline expires.
+	// It returns false when the deadline expired before all messages were processed.
+	AwaitInFlight(deadline time.Time) bool
+	// Close releases the consumer
+	Close()
+}
+
+type shutdownCoordinator struct {
+	lock      sync.Mutex
+	drainers  map[Drainer]struct{}
+	producers map[pulsar.Producer]*reconnector
+	started   int
+}
+
+var coordinator = &shutdownCoordinator{
+	drainers:  make(map[Drainer]struct{}),
+	producers: make(map[pulsar.Producer]*reconnector),
+}
+
+// RegisterDrainer adds a consumer to the set of consumers stopped on engine shutdown
+func RegisterDrainer(d Drainer) {
+	coordinator.lock.Lock()
+	defer coordinator.lock.Unlock()
+	coordinator.drainers[d] = struct{}{}
+}
+
+// UnregisterDrainer removes a consumer from the shutdown coordination
+func UnregisterDrainer(d Drainer) {
+	coordinator.lock.Lock()
+	defer coordinator.lock.Unlock()
+	delete(coordinator.drainers, d)
+}
+
+func registerProducer(p pulsar.Producer, owner *reconnector) {
+	coordinator.lock.Lock()
+	defer coordinator.lock.Unlock()
+	coordinator.producers[p] = owner
+}
+
+func unregisterProducer(p pulsar.Producer) {
+	coordinator.lock.Lock()
+	defer coordinator.lock.Unlock()
+	delete(coordinator.producers, p)
+}
+
+// ShutdownDeadline returns the deadline for draining in-flight work when stopping now
+func ShutdownDeadline() time.Time {
+	return time.Now().Add(getShutdownTimeout())
+}
+
+// Drain stops the intake of the consumers, waits for their in-flight messages until the deadline and closes them
+func Drain(deadline time.Time, drainers ...Drainer) {
+	for _, d := range drainers {
+		d.StopIntake()
+	}
+	for _, d := range drainers {
+		if !d.AwaitInFlight(deadline) {
+			logger.Warnf("Deadline expired before all in-flight messages were processed")
+		}
+	}
+	for _, d := range drainers {
+		d.Close()
+	}
+}
+
+// connectionStarted counts the connections started by the engine
+func connectionStarted() {
+	coordinator.lock.Lock()
+	defer coordinator.lock.Unlock()
+	coordinator.started++
+}
+
+// connectionStopped runs the shutdown when the last connection is stopped, which the engine does once all
+// triggers and actions are stopped
+func connectionStopped() {
+	coordinator.lock.Lock()
+	coordinator.started--
+	last := coordinator.started == 0
+	coordinator.lock.Unlock()
+	if last {
+		coordinator.shutdown()
+	}
+}
+
+// shutdown drains the consumers which were not stopped by their trigger, flushes all producers and closes them.
+// The clients are closed by their connections once their producers and consumers are closed.
+func (c *shutdownCoordinator) shutdown() {
+	c.lock.Lock()
+	drainers := make([]Drainer, 0, len(c.drainers))
+	for d := range c.drainers {
+		drainers = append(drainers, d)
+	}
+	c.lock.Unlock()
+
+	logger.Infof("Shutting down pulsar consumers and producers")
+	c.flushProducers()
+	Drain(ShutdownDeadline(), drainers...)
+	// flows which were in-flight may have published more messages
+	c.flushProducers()
+
+	c.lock.Lock()
+	producers := c.producers
+	c.drainers = make(map[Drainer]struct{})
+	c.producers = make(map[pulsar.Producer]*reconnector)
+	c.lock.Unlock()
+	for p, owner := range producers {
+		p.Close()
+		owner.release(p)
+	}
+	logger.Info("Pulsar shutdown completed")
+}
+
+func (c *shutdownCoordinator) flushProducers() {
+	c.lock.Lock()
+	producers := make([]pulsar.Producer, 0, len(c.producers))
+	for p := range c.producers {
+		producers = append(producers, p)
+	}
+	c.lock.Unlock()
+	for _, p := range producers {
+		if err := p.Flush(); err != nil {
+			logger.Warnf("Failed to flush producer [%s]: %v", p.Name(), err)
+		}
+	}
+}
+
+func getShutdownTimeout() time.Duration {
+	if v := os.Getenv(EnvShutdownTimeout); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+		logger.Warnf("Invalid value [%s] for %s, using default", v, EnvShutdownTimeout)
+	}
+	return defaultShutdownTimeout * time.Second
+}
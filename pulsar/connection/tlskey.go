@@ -0,0 +1,73 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// getEncryptedKeyTLSAuthentication supplies the client certificate with the private key decrypted in memory, so
+// that the decrypted key is never written to disk. The files are read again whenever the client connects.
+func getEncryptedKeyTLSAuthentication(certFile, keyFile, password string) (pulsar.Authentication, error) {
+	supplier := func() (*tls.Certificate, error) {
+		return loadTLSCertificate(certFile, keyFile, password)
+	}
+	// report a wrong password or an unsupported key when the connection is created
+	if _, err := supplier(); err != nil {
+		return nil, err
+	}
+	return pulsar.NewAuthenticationFromTLSCertSupplier(supplier), nil
+}
+
+func loadTLSCertificate(certFile, keyFile, password string) (*tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseTLSCertificate(certPEM, keyPEM, password)
+}
+
+func parseTLSCertificate(certPEM, keyPEM []byte, password string) (*tls.Certificate, error) {
+	keyPEM, err := decryptPEMKey(keyPEM, password)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// decryptPEMKey decrypts a private key encrypted with a passphrase, an encrypted PKCS#8 key ("ENCRYPTED PRIVATE KEY")
+// or a key in the legacy PEM format ("Proc-Type: 4,ENCRYPTED"). Keys which are not encrypted are returned as is.
+func decryptPEMKey(keyPEM []byte, password string) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("key file does not contain a PEM encoded key")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		der, err := decryptPKCS8(block.Bytes, password)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	// the legacy format is only decrypted for existing keys, x509.DecryptPEMBlock is deprecated as it is not authenticated
+	if !x509.IsEncryptedPEMBlock(block) {
+		return keyPEM, nil
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
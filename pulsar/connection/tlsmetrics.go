@@ -0,0 +1,160 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// certificateExpiryWarning is how long before expiry a certificate is warned about when the client is created
+const certificateExpiryWarning = 30 * 24 * time.Hour
+
+var tlsHandshakes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "pulsar_connection_tls_handshakes_total",
+	Help: "TLS handshakes with the brokers checked when a client is created, by service URL and outcome",
+}, []string{"url", "outcome"})
+
+var certificateExpiryDesc = prometheus.NewDesc("pulsar_connection_certificate_expiry_days",
+	"Days until the certificate expires, by certificate (client, ca, broker) and subject", []string{"certificate", "subject"}, nil)
+
+type trackedCertificate struct {
+	kind     string
+	subject  string
+	notAfter time.Time
+}
+
+// certificateCollector computes the days remaining of the certificates in use when scraped, the certificates of a
+// reconnector are replaced with every client it creates
+type certificateCollector struct {
+	lock   sync.Mutex
+	owners map[interface{}][]trackedCertificate
+}
+
+var certificates = &certificateCollector{owners: make(map[interface{}][]trackedCertificate)}
+
+func (c *certificateCollector) set(owner interface{}, certs []trackedCertificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.owners[owner] = certs
+}
+
+func (c *certificateCollector) remove(owner interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.owners, owner)
+}
+
+// Describe implements prometheus.Collector.Describe
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certificateExpiryDesc
+}
+
+// Collect implements prometheus.Collector.Collect
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	seen := make(map[string]bool)
+	for _, certs := range c.owners {
+		for _, cert := range certs {
+			// connections sharing a certificate report it once
+			if seen[cert.kind+"\x00"+cert.subject] {
+				continue
+			}
+			seen[cert.kind+"\x00"+cert.subject] = true
+			days := time.Until(cert.notAfter).Hours() / 24
+			ch <- prometheus.MustNewConstMetric(certificateExpiryDesc, prometheus.GaugeValue, days, cert.kind, cert.subject)
+		}
+	}
+}
+
+// probeTLS performs a TLS handshake with the broker of a pulsar+ssl service URL with the TLS options of the client,
+// as the client creates its connections lazily, and tracks the expiry of the client, CA and broker certificates
+func probeTLS(owner interface{}, opts pulsar.ClientOptions, serviceURL string) {
+	u, err := url.Parse(serviceURL)
+	if err != nil || u.Scheme != "pulsar+ssl" {
+		return
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "6651")
+	}
+
+	var certs []trackedCertificate
+	config := &tls.Config{InsecureSkipVerify: opts.TLSAllowInsecureConnection}
+	if opts.TLSTrustCertsFilePath != "" {
+		if data, err := ioutil.ReadFile(opts.TLSTrustCertsFilePath); err == nil {
+			config.RootCAs = x509.NewCertPool()
+			config.RootCAs.AppendCertsFromPEM(data)
+			for _, cert := range parsePEMCertificates(data) {
+				certs = append(certs, newTrackedCertificate("ca", cert))
+			}
+		}
+	}
+	if opts.TLSValidateHostname {
+		config.ServerName = u.Hostname()
+	}
+	// pulsar.Authentication is opaque, the TLS providers expose their certificate
+	if provider, ok := opts.Authentication.(interface {
+		GetTLSCertificate() (*tls.Certificate, error)
+	}); ok {
+		if cert, err := provider.GetTLSCertificate(); err == nil && cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+			if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+				certs = append(certs, newTrackedCertificate("client", leaf))
+			}
+		}
+	}
+
+	timeout := opts.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = clientCreationTimeout
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", host, config)
+	if err != nil {
+		tlsHandshakes.WithLabelValues(serviceURL, "failure").Inc()
+		logger.Warnf("TLS handshake with [%s] failed: %v", serviceURL, err)
+	} else {
+		tlsHandshakes.WithLabelValues(serviceURL, "success").Inc()
+		if peers := conn.ConnectionState().PeerCertificates; len(peers) > 0 {
+			certs = append(certs, newTrackedCertificate("broker", peers[0]))
+		}
+		conn.Close()
+	}
+
+	for _, cert := range certs {
+		if remaining := time.Until(cert.notAfter); remaining < certificateExpiryWarning {
+			logger.Warnf("The %s certificate [%s] expires in %d days, on %s", cert.kind, cert.subject, int(remaining.Hours()/24), cert.notAfter.Format(time.RFC3339))
+		}
+	}
+	certificates.set(owner, certs)
+}
+
+func newTrackedCertificate(kind string, cert *x509.Certificate) trackedCertificate {
+	return trackedCertificate{kind: kind, subject: cert.Subject.String(), notAfter: cert.NotAfter}
+}
+
+func parsePEMCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if !strings.HasSuffix(block.Type, "CERTIFICATE") {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
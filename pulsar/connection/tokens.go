@@ -0,0 +1,202 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenRefreshMargin = 60
+	tokenRetryInterval        = 5 * time.Second
+)
+
+// tokenManager fetches OAuth2 access tokens with the client_credentials flow, caches them and refreshes
+// them ahead of expiry in the background. It backs a token supplier, so brokers challenging the client
+// for fresh credentials always get a valid token and producers/consumers are re-authenticated transparently.
+type tokenManager struct {
+	clientID     string
+	clientSecret string
+	issuerURL    string
+	audience     string
+	scope        string
+	margin       time.Duration
+	httpClient   *http.Client
+
+	lock          sync.RWMutex
+	token         string
+	expiry        time.Time
+	tokenEndpoint string
+	startOnce     sync.Once
+	stop          chan struct{}
+}
+
+type clientCredentials struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	IssuerURL    string `json:"issuer_url"`
+}
+
+// newTokenManager creates a token manager from the clientId/clientSecret settings or, when they are not
+// set, from the client credentials key
+func newTokenManager(s *Settings, key []byte) (*tokenManager, error) {
+	creds := clientCredentials{ClientID: s.ClientId, ClientSecret: s.ClientSecret, IssuerURL: s.IssuerUrl}
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		if err := json.Unmarshal(key, &creds); err != nil {
+			return nil, fmt.Errorf("invalid OAuth2 key file: %v", err)
+		}
+		if s.IssuerUrl != "" {
+			creds.IssuerURL = s.IssuerUrl
+		}
+	}
+	if creds.IssuerURL == "" {
+		return nil, fmt.Errorf("OAuth2 issuer url is required")
+	}
+	margin := s.TokenRefreshMargin
+	if margin <= 0 {
+		margin = defaultTokenRefreshMargin
+	}
+	return &tokenManager{
+		clientID:     creds.ClientID,
+		clientSecret: creds.ClientSecret,
+		issuerURL:    strings.TrimSuffix(creds.IssuerURL, "/"),
+		audience:     s.Audience,
+		scope:        s.Scope,
+		margin:       time.Duration(margin) * time.Second,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		stop:         make(chan struct{}),
+	}, nil
+}
+
+// Token returns the cached access token, fetching a new one if there is none or it expired
+func (m *tokenManager) Token() (string, error) {
+	m.startOnce.Do(func() { go m.refreshLoop() })
+	m.lock.RLock()
+	token, expiry := m.token, m.expiry
+	m.lock.RUnlock()
+	if token != "" && time.Now().Before(expiry) {
+		return token, nil
+	}
+	return m.refresh()
+}
+
+func (m *tokenManager) refreshLoop() {
+	for {
+		m.lock.RLock()
+		wait := time.Until(m.expiry.Add(-m.margin))
+		m.lock.RUnlock()
+		if wait < tokenRetryInterval {
+			wait = tokenRetryInterval
+		}
+		select {
+		case <-time.After(wait):
+			m.lock.RLock()
+			due := time.Now().After(m.expiry.Add(-m.margin))
+			m.lock.RUnlock()
+			if due {
+				if _, err := m.refresh(); err != nil {
+					logger.Warnf("Proactive OAuth2 token refresh failed, retrying in %v: %v", tokenRetryInterval, err)
+				}
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *tokenManager) refresh() (string, error) {
+	token, err := m.fetch()
+	recordAuthRefresh("oauth2", err)
+	return token, err
+}
+
+// fetch requests a new access token from the token endpoint
+func (m *tokenManager) fetch() (string, error) {
+	endpoint, err := m.getTokenEndpoint()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", m.clientID)
+	form.Set("client_secret", m.clientSecret)
+	if m.audience != "" {
+		form.Set("audience", m.audience)
+	}
+	if m.scope != "" {
+		form.Set("scope", m.scope)
+	}
+	resp, err := m.httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return "", fmt.Errorf("OAuth2 token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("OAuth2 token request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("invalid OAuth2 token response: %v", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("OAuth2 token response has no access token")
+	}
+	expiry := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	if tokenResp.ExpiresIn <= 0 {
+		// no expiry advertised, refresh once per hour
+		expiry = time.Now().Add(time.Hour)
+	}
+
+	m.lock.Lock()
+	m.token = tokenResp.AccessToken
+	m.expiry = expiry
+	m.lock.Unlock()
+	logger.Debugf("OAuth2 token refreshed, expires at %v", expiry)
+	return tokenResp.AccessToken, nil
+}
+
+// getTokenEndpoint discovers the token endpoint of the issuer
+func (m *tokenManager) getTokenEndpoint() (string, error) {
+	m.lock.RLock()
+	endpoint := m.tokenEndpoint
+	m.lock.RUnlock()
+	if endpoint != "" {
+		return endpoint, nil
+	}
+	resp, err := m.httpClient.Get(m.issuerURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("OAuth2 issuer discovery failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OAuth2 issuer discovery failed with status %d", resp.StatusCode)
+	}
+	var metadata struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&metadata); err != nil || metadata.TokenEndpoint == "" {
+		return "", fmt.Errorf("OAuth2 issuer [%s] does not advertise a token endpoint", m.issuerURL)
+	}
+	m.lock.Lock()
+	m.tokenEndpoint = metadata.TokenEndpoint
+	m.lock.Unlock()
+	return metadata.TokenEndpoint, nil
+}
+
+// Close stops the background refresh
+func (m *tokenManager) Close() {
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+}
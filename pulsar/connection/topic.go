@@ -0,0 +1,93 @@
+package connection
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultTopicDomain = "persistent"
+	defaultTenant      = "public"
+	defaultNamespace   = "default"
+)
+
+var topicNamePart = regexp.MustCompile(`^[-=:.\w]+$`)
+
+// NormalizeTopic validates a topic name so that misconfigurations are reported at startup instead of with
+// broker errors. When qualify is true the fully qualified name "persistent://tenant/namespace/topic" is
+// returned, short names like "orders" are expanded to the public/default namespace as the broker does.
+func NormalizeTopic(topic string, qualify bool) (string, error) {
+	name := strings.TrimSpace(topic)
+	if name == "" {
+		return "", fmt.Errorf("topic name is empty")
+	}
+	domain, rest := defaultTopicDomain, name
+	qualified := false
+	if i := strings.Index(name, "://"); i >= 0 {
+		domain, rest, qualified = name[:i], name[i+3:], true
+		if domain != "persistent" && domain != "non-persistent" {
+			return "", fmt.Errorf("invalid topic [%s]: domain must be persistent or non-persistent", topic)
+		}
+	}
+	parts := strings.Split(rest, "/")
+	switch {
+	case len(parts) == 1 && !qualified:
+		parts = []string{defaultTenant, defaultNamespace, parts[0]}
+	case len(parts) == 3, len(parts) == 4:
+		// tenant/namespace/topic or the legacy tenant/cluster/namespace/topic
+	default:
+		return "", fmt.Errorf("invalid topic [%s]: expected [persistent://]tenant/namespace/topic or a short topic name", topic)
+	}
+	for _, part := range parts[:len(parts)-1] {
+		if !topicNamePart.MatchString(part) {
+			return "", fmt.Errorf("invalid topic [%s]: illegal tenant or namespace name [%s]", topic, part)
+		}
+	}
+	if local := parts[len(parts)-1]; local == "" || strings.ContainsAny(local, " \t\r\n") {
+		return "", fmt.Errorf("invalid topic [%s]: illegal topic name [%s]", topic, local)
+	}
+	if !qualify {
+		return name, nil
+	}
+	return domain + "://" + strings.Join(parts, "/"), nil
+}
+
+// NormalizeTopicsPattern validates a topics pattern like "persistent://tenant/namespace/orders-.*", whose topic name
+// is a regular expression, and returns it fully qualified as the client discovers the matching topics of the
+// namespace. A short pattern like "orders-.*" matches the topics of the public/default namespace.
+func NormalizeTopicsPattern(pattern string) (string, error) {
+	name := strings.TrimSpace(pattern)
+	if name == "" {
+		return "", fmt.Errorf("topics pattern is empty")
+	}
+	domain, rest := defaultTopicDomain, name
+	if i := strings.Index(name, "://"); i >= 0 {
+		domain, rest = name[:i], name[i+3:]
+		if domain != "persistent" && domain != "non-persistent" {
+			return "", fmt.Errorf("invalid topics pattern [%s]: domain must be persistent or non-persistent", pattern)
+		}
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	switch len(parts) {
+	case 1:
+		parts = []string{defaultTenant, defaultNamespace, parts[0]}
+	case 3:
+	default:
+		return "", fmt.Errorf("invalid topics pattern [%s]: expected [persistent://]tenant/namespace/pattern", pattern)
+	}
+	for _, part := range parts[:2] {
+		if !topicNamePart.MatchString(part) {
+			return "", fmt.Errorf("invalid topics pattern [%s]: illegal tenant or namespace name [%s]", pattern, part)
+		}
+	}
+	if _, err := regexp.Compile(parts[2]); err != nil {
+		return "", fmt.Errorf("invalid topics pattern [%s]: %v", pattern, err)
+	}
+	return domain + "://" + strings.Join(parts, "/"), nil
+}
+
+// NormalizeTopic validates the topic name, qualifying it if the connection is configured to
+func (p *PulsarConnManager) NormalizeTopic(topic string) (string, error) {
+	return NormalizeTopic(topic, p.QualifyTopics)
+}
@@ -0,0 +1,48 @@
+package connection
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTopic(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		qualify bool
+		want    string
+		wantErr string
+	}{
+		{"short name kept", "orders", false, "orders", ""},
+		{"short name qualified", "orders", true, "persistent://public/default/orders", ""},
+		{"namespaced name qualified", "acme/sales/orders", true, "persistent://acme/sales/orders", ""},
+		{"qualified name kept", "non-persistent://acme/sales/orders", true, "non-persistent://acme/sales/orders", ""},
+		{"legacy cluster name", "persistent://acme/us-west/sales/orders", true, "persistent://acme/us-west/sales/orders", ""},
+		{"partition name", "persistent://acme/sales/orders-partition-0", true, "persistent://acme/sales/orders-partition-0", ""},
+		{"surrounding spaces trimmed", "  orders ", true, "persistent://public/default/orders", ""},
+		{"empty", " ", false, "", "topic name is empty"},
+		{"unknown domain", "kafka://acme/sales/orders", false, "", "domain must be persistent or non-persistent"},
+		{"qualified short name", "persistent://orders", false, "", "expected [persistent://]tenant/namespace/topic"},
+		{"missing namespace", "acme/orders", false, "", "expected [persistent://]tenant/namespace/topic"},
+		{"illegal tenant", "ac me/sales/orders", false, "", "illegal tenant or namespace name [ac me]"},
+		{"empty topic", "acme/sales/", false, "", "illegal topic name []"},
+		{"space in topic", "acme/sales/new orders", false, "", "illegal topic name [new orders]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTopic(tt.topic, tt.qualify)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NormalizeTopic(%q) error = %v, want %s", tt.topic, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeTopic(%q, %v) = %s, want %s", tt.topic, tt.qualify, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package subscriber
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+// decoder decodes a payload into its structured form
+type decoder func(payload []byte) (interface{}, error)
+
+// autoSchema decodes each message with the schema version of the topic it was published with, fetched with the
+// admin API when first seen, so that producers can evolve the schema of the topic independently of the app
+type autoSchema struct {
+	connMgr  connection.PulsarConnManager
+	lock     sync.Mutex
+	decoders map[string]decoder
+}
+
+func newAutoSchema(connMgr connection.PulsarConnManager) *autoSchema {
+	return &autoSchema{connMgr: connMgr, decoders: make(map[string]decoder)}
+}
+
+// schemaVersion returns the version of the schema the message was published with, -1 if it has none
+func schemaVersion(msg pulsar.Message) int64 {
+	version := msg.SchemaVersion()
+	if len(version) != 8 {
+		return -1
+	}
+	return int64(binary.BigEndian.Uint64(version))
+}
+
+// decode decodes the payload of a message of the topic published with the schema version
+func (a *autoSchema) decode(topic string, version int64, payload []byte) (interface{}, error) {
+	if i := strings.LastIndex(topic, "-partition-"); i > 0 {
+		topic = topic[:i]
+	}
+	key := topic + "@" + strconv.FormatInt(version, 10)
+	a.lock.Lock()
+	d, ok := a.decoders[key]
+	a.lock.Unlock()
+	if !ok {
+		var err error
+		// failures are not cached, the schema is fetched again for the next message
+		if d, err = a.newDecoder(topic, version); err != nil {
+			return nil, err
+		}
+		a.lock.Lock()
+		a.decoders[key] = d
+		a.lock.Unlock()
+	}
+	return d(payload)
+}
+
+func (a *autoSchema) newDecoder(topic string, version int64) (decoder, error) {
+	admin, err := a.connMgr.AdminClient()
+	if err != nil {
+		return nil, err
+	}
+	schema := &topicSchema{}
+	if err = admin.Get(topicSchemaPath(topic)+"/"+strconv.FormatInt(version, 10), schema); err != nil {
+		return nil, fmt.Errorf("unable to fetch version %d of the schema of topic [%s]: %v", version, topic, err)
+	}
+	switch schema.Type {
+	case "AVRO":
+		avro, err := pulsar.NewAvroSchemaWithValidation(schema.Data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Avro schema version %d of topic [%s]: %v", version, topic, err)
+		}
+		return func(payload []byte) (interface{}, error) {
+			return decodeAvro(avro, payload)
+		}, nil
+	case "JSON":
+		return func(payload []byte) (interface{}, error) {
+			var record interface{}
+			if err := json.Unmarshal(payload, &record); err != nil {
+				return nil, fmt.Errorf("invalid JSON payload: %v", err)
+			}
+			return record, nil
+		}, nil
+	case "PROTOBUF_NATIVE":
+		native := &nativeSchema{}
+		if err = json.Unmarshal([]byte(schema.Data), native); err != nil {
+			return nil, fmt.Errorf("invalid ProtobufNative schema version %d of topic [%s]: %v", version, topic, err)
+		}
+		proto, err := newProtoDecoder(native.FileDescriptorSet, native.RootMessageTypeName, schema.Type)
+		if err != nil {
+			return nil, err
+		}
+		return proto.decode, nil
+	case "STRING":
+		return func(payload []byte) (interface{}, error) {
+			return string(payload), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("schema version %d of topic [%s] is %s, which cannot be consumed generically", version, topic, schema.Type)
+}
@@ -0,0 +1,73 @@
+package subscriber
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+const (
+	SchemaTypeBytes = "Bytes"
+	SchemaTypeAvro  = "Avro"
+	SchemaTypeJSON  = "JSON"
+
+	SchemaTypeProtobuf       = "Protobuf"
+	SchemaTypeProtobufNative = "ProtobufNative"
+	SchemaTypeAutoConsume    = "AutoConsume"
+)
+
+// newAvroSchema creates the Avro schema the consumer is created with, from the inline definition or, when there is
+// none, from the schema of the topic registered with the broker
+func newAvroSchema(connMgr connection.PulsarConnManager, topic, definition string) (*pulsar.AvroSchema, error) {
+	if definition == "" {
+		var err error
+		if definition, err = fetchTopicSchema(connMgr, topic, "AVRO"); err != nil {
+			return nil, err
+		}
+	}
+	schema, err := pulsar.NewAvroSchemaWithValidation(definition, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %v", err)
+	}
+	return schema, nil
+}
+
+// fetchTopicSchema returns the definition of the schema of the topic, which must be of the given type
+func fetchTopicSchema(connMgr connection.PulsarConnManager, topic, schemaType string) (string, error) {
+	if topic == "" {
+		return "", fmt.Errorf("fetching the schema from the broker requires a single topic")
+	}
+	admin, err := connMgr.AdminClient()
+	if err != nil {
+		return "", fmt.Errorf("fetching the schema from the broker requires the admin API: %v", err)
+	}
+	qualified, err := connection.NormalizeTopic(topic, true)
+	if err != nil {
+		return "", err
+	}
+	schema := &topicSchema{}
+	if err = admin.Get(topicSchemaPath(qualified), schema); err != nil {
+		return "", fmt.Errorf("unable to fetch the schema of topic [%s]: %v", topic, err)
+	}
+	if schema.Type != schemaType {
+		return "", fmt.Errorf("the schema of topic [%s] is %s, not %s", topic, schema.Type, schemaType)
+	}
+	return schema.Data, nil
+}
+
+// topicSchemaPath returns the admin API path of the schema of a fully qualified topic
+func topicSchemaPath(topic string) string {
+	return "/admin/v2/schemas/" + topic[strings.Index(topic, "://")+3:] + "/schema"
+}
+
+// decodeAvro decodes the Avro record of the payload into its structured form, with unions as in the JSON encoding
+// of Avro, e.g. {"string": "value"}
+func decodeAvro(schema *pulsar.AvroSchema, payload []byte) (interface{}, error) {
+	var record interface{}
+	if err := schema.Decode(payload, &record); err != nil {
+		return nil, fmt.Errorf("invalid Avro payload: %v", err)
+	}
+	return record, nil
+}
@@ -0,0 +1,36 @@
+package subscriber
+
+import (
+	"time"
+
+	"github.com/project-flogo/core/data/coerce"
+)
+
+// defaultBatchTimeout is the batch receive timeout of the Pulsar clients
+const defaultBatchTimeout = 100 * time.Millisecond
+
+// newBatchWindow accumulates consumed messages and invokes the flow once with up to the maximum number of messages,
+// or with the messages received within the timeout of the first one, for flows doing bulk operations. The client
+// has no batch receive, the batches are assembled by the handler in a single window.
+func newBatchWindow(handler *Handler, maxMessages int, timeoutMs int) *windows {
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultBatchTimeout
+	}
+	return newWindows(handler, "Batch", timeout, false, maxMessages)
+}
+
+// nackedMessages returns the msgids of the nackMessages reply, the messages of a batch the flow failed to process
+func nackedMessages(attrs map[string]interface{}) map[string]bool {
+	ids, err := coerce.ToArray(attrs["nackMessages"])
+	if err != nil || len(ids) == 0 {
+		return nil
+	}
+	nacked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if s, err := coerce.ToString(id); err == nil {
+			nacked[s] = true
+		}
+	}
+	return nacked
+}
@@ -0,0 +1,30 @@
+package subscriber
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// setBatchMetadata sets the position of the message in the batch it was published in. The client decompresses and
+// splits the entries before delivering their messages and only reports the number of messages in the entry and its
+// compression for messages delivered encrypted, the others have the batch index only.
+func setBatchMetadata(out *Output, msg pulsar.Message) {
+	out.BatchIndex = int(msg.ID().BatchIdx())
+	if encryption := msg.GetEncryptionContext(); encryption != nil {
+		out.BatchSize = encryption.BatchSize
+		out.CompressionType = compressionName(encryption.CompressionType)
+	}
+}
+
+// compressionName returns the name of the compression type as in the compressionType setting of the publish activity
+func compressionName(compression pulsar.CompressionType) string {
+	switch compression {
+	case pulsar.LZ4:
+		return "LZ4"
+	case pulsar.ZLib:
+		return "ZLIB"
+	case pulsar.ZSTD:
+		return "ZSTD"
+	default:
+		return "NONE"
+	}
+}
@@ -0,0 +1,47 @@
+package subscriber
+
+import (
+	"fmt"
+)
+
+const defaultCanaryPercent = 5
+
+// canaryRoute sends a share of a primary handler's messages to the flow of a canary handler. The canary
+// handler shares the primary's consumer and has its own metrics, so a new flow version can be compared
+// against live traffic.
+type canaryRoute struct {
+	target  *Handler
+	sampler *sampler
+}
+
+// canaryTarget holds the canary settings of a handler until all handlers are known
+type canaryTarget struct {
+	handler *Handler
+	primary string
+	percent float64
+	byKey   bool
+}
+
+// resolveCanaries attaches canary handlers to the primary handlers they split traffic with
+func (t *Trigger) resolveCanaries(canaries []canaryTarget) error {
+	byName := make(map[string]*Handler, len(t.handlers))
+	for _, h := range t.handlers {
+		byName[h.handler.Name()] = h
+	}
+	for _, c := range canaries {
+		primary, ok := byName[c.primary]
+		if !ok || primary == c.handler {
+			return fmt.Errorf("canary handler [%s] references unknown primary handler [%s]", c.handler.handler.Name(), c.primary)
+		}
+		if primary.isCanary || primary.canary != nil {
+			return fmt.Errorf("handler [%s] cannot be both primary and canary, or have more than one canary", c.primary)
+		}
+		if c.percent <= 0 {
+			c.percent = defaultCanaryPercent
+		}
+		c.handler.isCanary = true
+		primary.canary = &canaryRoute{target: c.handler, sampler: &sampler{rate: c.percent, byKey: c.byKey}}
+		t.logger.Infof("Routing %.1f%% of the messages of handler [%s] to canary handler [%s]", c.percent, c.primary, c.handler.handler.Name())
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package subscriber
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	CharsetUTF8    = "UTF-8"
+	CharsetAuto    = "Auto"
+	CharsetUTF16   = "UTF-16"
+	CharsetUTF16LE = "UTF-16LE"
+	CharsetUTF16BE = "UTF-16BE"
+	CharsetLatin1  = "ISO-8859-1"
+
+	// propertyContentType is checked for the charset of the payload, e.g. "text/plain; charset=UTF-16LE"
+	propertyContentType = "content-type"
+)
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// CharsetDecoder converts a payload in a charset to UTF-8
+type CharsetDecoder interface {
+	Decode(payload []byte) ([]byte, error)
+}
+
+// CharsetDecoderFunc adapts a function to a CharsetDecoder
+type CharsetDecoderFunc func(payload []byte) ([]byte, error)
+
+// Decode implements CharsetDecoder.Decode
+func (f CharsetDecoderFunc) Decode(payload []byte) ([]byte, error) {
+	return f(payload)
+}
+
+var charsets = struct {
+	lock     sync.RWMutex
+	decoders map[string]CharsetDecoder
+}{decoders: map[string]CharsetDecoder{
+	CharsetUTF8:    CharsetDecoderFunc(decodeUTF8),
+	CharsetUTF16:   CharsetDecoderFunc(decodeUTF16),
+	CharsetUTF16LE: CharsetDecoderFunc(func(payload []byte) ([]byte, error) { return decodeUTF16Endian(payload, false) }),
+	CharsetUTF16BE: CharsetDecoderFunc(func(payload []byte) ([]byte, error) { return decodeUTF16Endian(payload, true) }),
+	CharsetLatin1:  CharsetDecoderFunc(decodeLatin1),
+}}
+
+// RegisterCharset makes a charset available to the charset handler setting and to Auto detection from the
+// content-type property, e.g. an EBCDIC code page such as IBM037 for messages produced by mainframes. Names are
+// case insensitive.
+func RegisterCharset(name string, decoder CharsetDecoder) {
+	charsets.lock.Lock()
+	defer charsets.lock.Unlock()
+	charsets.decoders[strings.ToUpper(name)] = decoder
+}
+
+func getCharsetDecoder(name string) (CharsetDecoder, bool) {
+	charsets.lock.RLock()
+	defer charsets.lock.RUnlock()
+	decoder, ok := charsets.decoders[strings.ToUpper(name)]
+	return decoder, ok
+}
+
+// validateCharset checks the charset handler setting, empty defaults to UTF-8
+func validateCharset(name string) (string, error) {
+	if name == "" {
+		return CharsetUTF8, nil
+	}
+	if strings.EqualFold(name, CharsetAuto) {
+		return CharsetAuto, nil
+	}
+	if _, ok := getCharsetDecoder(name); !ok {
+		return "", fmt.Errorf("unknown charset [%s], register it with subscriber.RegisterCharset", name)
+	}
+	return strings.ToUpper(name), nil
+}
+
+// decodePayload converts the payload to UTF-8 before format parsing. With Auto the charset is taken from the
+// content-type property, then detected from a byte order mark or from the zero bytes of UTF-16, then payloads
+// which are not valid UTF-8 are decoded as ISO-8859-1.
+func decodePayload(msg *Message, charset string) error {
+	if charset == CharsetAuto {
+		charset = detectCharset(msg)
+	}
+	if charset == CharsetUTF8 && !bytes.HasPrefix(msg.Payload, utf8BOM) {
+		return nil
+	}
+	decoder, ok := getCharsetDecoder(charset)
+	if !ok {
+		return fmt.Errorf("unknown charset [%s]", charset)
+	}
+	payload, err := decoder.Decode(msg.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid %s payload: %v", charset, err)
+	}
+	msg.Payload = payload
+	return nil
+}
+
+func detectCharset(msg *Message) string {
+	for k, v := range msg.Properties {
+		if !strings.EqualFold(k, propertyContentType) {
+			continue
+		}
+		if _, params, err := mime.ParseMediaType(v); err == nil && params["charset"] != "" {
+			if _, ok := getCharsetDecoder(params["charset"]); ok {
+				return params["charset"]
+			}
+		}
+		break
+	}
+	switch {
+	case bytes.HasPrefix(msg.Payload, utf8BOM):
+		return CharsetUTF8
+	case bytes.HasPrefix(msg.Payload, utf16LEBOM), bytes.HasPrefix(msg.Payload, utf16BEBOM):
+		return CharsetUTF16
+	}
+	// UTF-16 without byte order mark is valid UTF-8 when it is mostly ASCII
+	if le, be := zeroBytes(msg.Payload); len(msg.Payload)%2 == 0 && (le || be) {
+		if le {
+			return CharsetUTF16LE
+		}
+		return CharsetUTF16BE
+	}
+	if utf8.Valid(msg.Payload) {
+		return CharsetUTF8
+	}
+	return CharsetLatin1
+}
+
+// zeroBytes reports whether most of the odd (little endian) or even (big endian) bytes are zero, as in UTF-16
+// encoded text which is mostly ASCII
+func zeroBytes(payload []byte) (le, be bool) {
+	var even, odd int
+	for i, b := range payload {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	half := len(payload) / 4
+	return odd > half && even == 0, even > half && odd == 0
+}
+
+// decodeUTF8 strips the byte order mark, invalid sequences are passed on as they are
+func decodeUTF8(payload []byte) ([]byte, error) {
+	return bytes.TrimPrefix(payload, utf8BOM), nil
+}
+
+// decodeUTF16 uses the byte order mark, big endian if there is none
+func decodeUTF16(payload []byte) ([]byte, error) {
+	if bytes.HasPrefix(payload, utf16LEBOM) {
+		return decodeUTF16Endian(payload, false)
+	}
+	return decodeUTF16Endian(payload, true)
+}
+
+func decodeUTF16Endian(payload []byte, bigEndian bool) ([]byte, error) {
+	if len(payload)%2 != 0 {
+		return nil, fmt.Errorf("odd number of bytes")
+	}
+	units := make([]uint16, 0, len(payload)/2)
+	for i := 0; i < len(payload); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(payload[i])<<8|uint16(payload[i+1]))
+		} else {
+			units = append(units, uint16(payload[i+1])<<8|uint16(payload[i]))
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return []byte(string(utf16.Decode(units))), nil
+}
+
+func decodeLatin1(payload []byte) ([]byte, error) {
+	runes := make([]rune, len(payload))
+	for i, b := range payload {
+		runes[i] = rune(b)
+	}
+	return []byte(string(runes)), nil
+}
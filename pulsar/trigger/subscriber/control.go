@@ -0,0 +1,210 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/engine"
+)
+
+// Actions of control messages
+const (
+	ControlActionPause  = "pause"
+	ControlActionResume = "resume"
+	ControlActionDrain  = "drain"
+
+	controlDrainTimeout = 30 * time.Second
+	controlRetryDelay   = 5 * time.Second
+)
+
+// controlMessage is published as JSON on the controlTopic to change the state of handlers at runtime, e.g.
+// {"action":"pause","handler":"orders"}. An empty or "*" handler applies to all handlers of the trigger, an
+// empty app to all apps reading the control topic.
+type controlMessage struct {
+	Action  string `json:"action"`
+	Handler string `json:"handler"`
+	App     string `json:"app"`
+}
+
+// pauseGate holds off receiving messages while a handler is paused by a control message, the consumer stays
+// subscribed
+type pauseGate struct {
+	lock    sync.Mutex
+	paused  bool
+	resumed chan struct{}
+}
+
+func (g *pauseGate) pause() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.paused {
+		return false
+	}
+	g.paused = true
+	g.resumed = make(chan struct{})
+	return true
+}
+
+func (g *pauseGate) resume() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resumed)
+	return true
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.paused
+}
+
+// wait blocks while paused, it returns false if the handler was stopped meanwhile
+func (g *pauseGate) wait(done chan bool) bool {
+	g.lock.Lock()
+	if !g.paused {
+		g.lock.Unlock()
+		return true
+	}
+	resumed := g.resumed
+	g.lock.Unlock()
+	select {
+	case <-resumed:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// listenControl reads the control topic from the latest message, every app instance reads all control messages
+// published while it is running
+func (t *Trigger) listenControl(connMgr connection.PulsarConnManager, done chan bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+	for {
+		select {
+		case <-connMgr.NotifyConnected():
+		case <-done:
+			return
+		}
+		reader, err := t.createControlReader(&connMgr)
+		if err != nil {
+			t.logger.Errorf("Unable to read control topic [%s]: %v", t.controlTopic, err)
+			select {
+			case <-time.After(controlRetryDelay):
+				continue
+			case <-done:
+				return
+			}
+		}
+		t.logger.Infof("Listening for control messages on topic [%s]", t.controlTopic)
+		for {
+			msg, err := reader.Next(ctx)
+			if err != nil {
+				reader.Close()
+				if ctx.Err() != nil {
+					return
+				}
+				// e.g. the client was swapped by a credential refresh
+				t.logger.Warnf("Reading control topic [%s] failed, reading it again: %v", t.controlTopic, err)
+				connMgr = t.pulsarCnn.GetConnection().(connection.PulsarConnManager)
+				break
+			}
+			t.control(msg)
+		}
+	}
+}
+
+func (t *Trigger) createControlReader(connMgr *connection.PulsarConnManager) (pulsar.Reader, error) {
+	if err := connMgr.Connect(); err != nil {
+		return nil, err
+	}
+	return connMgr.Client.CreateReader(pulsar.ReaderOptions{
+		Topic:          t.controlTopic,
+		StartMessageID: pulsar.LatestMessageID(),
+	})
+}
+
+// control applies a control message to the handlers it targets
+func (t *Trigger) control(msg pulsar.Message) {
+	var m controlMessage
+	if err := json.Unmarshal(msg.Payload(), &m); err != nil {
+		t.logger.Warnf("Ignoring invalid control message [%s]: %v", msg.ID(), err)
+		return
+	}
+	if m.App != "" && m.App != engine.GetAppName() {
+		return
+	}
+	action := strings.ToLower(m.Action)
+	switch action {
+	case ControlActionPause, ControlActionResume, ControlActionDrain:
+	default:
+		t.logger.Warnf("Ignoring control message [%s] with unknown action [%s]", msg.ID(), m.Action)
+		return
+	}
+	for _, handler := range t.handlers {
+		if handler.isCanary || (m.Handler != "" && m.Handler != "*" && m.Handler != handler.handler.Name()) {
+			continue
+		}
+		t.applyControl(handler, action, "control message")
+	}
+}
+
+// applyControl changes the state of a handler, source names who asked for it in the logs
+func (t *Trigger) applyControl(handler *Handler, action, source string) {
+	switch action {
+	case ControlActionPause:
+		if handler.pause.pause() {
+			handler.logger.Infof("Handler [%s] paused by %s", handler.handler.Name(), source)
+		}
+	case ControlActionResume:
+		if handler.standingBy() {
+			// the replica leading the handler consumes
+			handler.pause.resume()
+			return
+		}
+		resumed := handler.pause.resume()
+		handler.stateLock.Lock()
+		drained := !handler.running
+		handler.stateLock.Unlock()
+		if drained {
+			handler.start(t.connMgr)
+			resumed = true
+		}
+		if resumed {
+			handler.logger.Infof("Handler [%s] resumed by %s", handler.handler.Name(), source)
+		}
+	case ControlActionDrain:
+		go handler.drain(source)
+	}
+}
+
+// drain stops receiving, waits for the in-flight messages and closes the consumer so that other consumers of
+// the subscription take over, until the handler is resumed
+func (handler *Handler) drain(source string) {
+	handler.stateLock.Lock()
+	running := handler.running
+	handler.stateLock.Unlock()
+	if !running {
+		return
+	}
+	handler.logger.Infof("Draining handler [%s] by %s", handler.handler.Name(), source)
+	handler.StopIntake()
+	if !handler.AwaitInFlight(time.Now().Add(controlDrainTimeout)) {
+		handler.logger.Warnf("Handler [%s] drained with in-flight messages, they are redelivered", handler.handler.Name())
+	}
+	handler.Close()
+	handler.logger.Infof("Handler [%s] drained", handler.handler.Name())
+}
@@ -0,0 +1,52 @@
+package subscriber
+
+import (
+	"fmt"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/apache/pulsar-client-go/pulsar/crypto"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+// Actions of consumerCryptoFailureAction
+const (
+	CryptoFailureFail    = "FAIL"
+	CryptoFailureDiscard = "DISCARD"
+	CryptoFailureConsume = "CONSUME"
+)
+
+// privateKeyReader supplies the private key of the handler for all the key names messages are encrypted with, the
+// client tries it for each of them until the data key decrypts
+type privateKeyReader struct {
+	key []byte
+}
+
+// PublicKey implements crypto.KeyReader.PublicKey, consumers do not encrypt
+func (r *privateKeyReader) PublicKey(keyName string, metadata map[string]string) (*crypto.EncryptionKeyInfo, error) {
+	return nil, fmt.Errorf("no public key available to the consumer")
+}
+
+// PrivateKey implements crypto.KeyReader.PrivateKey
+func (r *privateKeyReader) PrivateKey(keyName string, metadata map[string]string) (*crypto.EncryptionKeyInfo, error) {
+	return crypto.NewEncryptionKeyInfo(keyName, r.key, metadata), nil
+}
+
+// decryptionInfo returns the decryption of end-to-end encrypted messages with the private key of the setting
+func decryptionInfo(privateKey, failureAction string) (*pulsar.MessageDecryptionInfo, error) {
+	key, err := connection.ReadKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the decryption key: %v", err)
+	}
+	info := &pulsar.MessageDecryptionInfo{KeyReader: &privateKeyReader{key: key}}
+	switch failureAction {
+	case "", CryptoFailureFail:
+		info.ConsumerCryptoFailureAction = crypto.ConsumerCryptoFailureActionFail
+	case CryptoFailureDiscard:
+		info.ConsumerCryptoFailureAction = crypto.ConsumerCryptoFailureActionDiscard
+	case CryptoFailureConsume:
+		info.ConsumerCryptoFailureAction = crypto.ConsumerCryptoFailureActionConsume
+	default:
+		return nil, fmt.Errorf("invalid consumerCryptoFailureAction [%s], expected FAIL, DISCARD or CONSUME", failureAction)
+	}
+	return info, nil
+}
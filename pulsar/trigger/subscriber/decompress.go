@@ -0,0 +1,61 @@
+package subscriber
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	DecompressNone = "None"
+	DecompressAuto = "Auto"
+
+	// propertyContentEncoding is checked for the compression applied by the producer
+	propertyContentEncoding = "content-encoding"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressPayload detects payloads compressed at the application layer, from the content-encoding
+// property or from their magic bytes, and decompresses them
+func decompressPayload(msg *Message) error {
+	encoding := ""
+	for k, v := range msg.Properties {
+		if strings.EqualFold(k, propertyContentEncoding) {
+			encoding = strings.ToLower(v)
+			break
+		}
+	}
+	switch {
+	case encoding == "gzip" || (encoding == "" && bytes.HasPrefix(msg.Payload, gzipMagic)):
+		reader, err := gzip.NewReader(bytes.NewReader(msg.Payload))
+		if err != nil {
+			return fmt.Errorf("invalid gzip payload: %v", err)
+		}
+		defer reader.Close()
+		payload, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("invalid gzip payload: %v", err)
+		}
+		msg.Payload = payload
+	case encoding == "zstd" || (encoding == "" && bytes.HasPrefix(msg.Payload, zstdMagic)):
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		payload, err := decoder.DecodeAll(msg.Payload, nil)
+		if err != nil {
+			return fmt.Errorf("invalid zstd payload: %v", err)
+		}
+		msg.Payload = payload
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package subscriber
+
+import (
+	"sync/atomic"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+// handlerDiagnostics is the state of a handler reported in the diagnostics dump
+type handlerDiagnostics struct {
+	Topic             string                           `json:"topic"`
+	Topics            []string                         `json:"topics,omitempty"`
+	TopicsPattern     string                           `json:"topicsPattern,omitempty"`
+	Subscription      string                           `json:"subscription"`
+	SubscriptionType  string                           `json:"subscriptionType"`
+	ConsumerName      string                           `json:"consumerName"`
+	ReceiverQueueSize int                              `json:"receiverQueueSize"`
+	DLQTopic          string                           `json:"dlqTopic,omitempty"`
+	Running           bool                             `json:"running"`
+	Subscribed        bool                             `json:"subscribed"`
+	Paused            bool                             `json:"paused"`
+	StandingBy        bool                             `json:"standingBy,omitempty"`
+	QueuedMessages    int                              `json:"queuedMessages"`
+	InFlight          int64                            `json:"inFlight"`
+	LastMsgID         string                           `json:"lastMsgId,omitempty"`
+	Generation        int64                            `json:"generation,omitempty"`
+	Scaling           *scalingState                    `json:"scaling,omitempty"`
+	Connection        connection.ConnectionDiagnostics `json:"connection"`
+}
+
+var subscriptionTypes = map[pulsar.SubscriptionType]string{
+	pulsar.Exclusive: "Exclusive",
+	pulsar.Shared:    "Shared",
+	pulsar.Failover:  "Failover",
+	pulsar.KeyShared: "KeyShared",
+}
+
+// DiagnosticsName implements connection.Diagnosable.DiagnosticsName
+func (handler *Handler) DiagnosticsName() string {
+	return handler.handler.Name()
+}
+
+// Diagnostics implements connection.Diagnosable.Diagnostics
+func (handler *Handler) Diagnostics() interface{} {
+	handler.stateLock.Lock()
+	running := handler.running
+	handler.stateLock.Unlock()
+
+	opts := handler.consumerOpts
+	d := handlerDiagnostics{
+		Topic:             opts.Topic,
+		Topics:            opts.Topics,
+		TopicsPattern:     opts.TopicsPattern,
+		Subscription:      opts.SubscriptionName,
+		SubscriptionType:  subscriptionTypes[opts.Type],
+		ConsumerName:      opts.Name,
+		ReceiverQueueSize: opts.ReceiverQueueSize,
+		DLQTopic:          handler.dlqTopic,
+		Running:           running,
+		Subscribed:        handler.consumer != nil,
+		Paused:            (handler.connMgr.Backpressure != nil && handler.connMgr.Backpressure.Delay() > 0) || handler.pause.isPaused(),
+		StandingBy:        handler.standingBy(),
+		QueuedMessages:    len(opts.MessageChannel),
+		InFlight:          atomic.LoadInt64(&handler.processing),
+		Generation:        handler.generation.current(),
+		Connection:        handler.connMgr.Diagnostics(),
+	}
+	if handler.scaling != nil {
+		d.Scaling = handler.scaling.state()
+	}
+	if id, ok := handler.lastMsgID.Load().(string); ok {
+		d.LastMsgID = id
+	}
+	return d
+}
@@ -0,0 +1,28 @@
+package subscriber
+
+import (
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+const (
+	ExpiredActionSkip  = "Skip"
+	ExpiredActionRoute = "Route"
+	ExpiredActionFlag  = "Flag"
+)
+
+// messageExpiry enforces the maximum age of consumed messages, measured from their publish time
+type messageExpiry struct {
+	maxAge time.Duration
+	action string
+	topic  string
+}
+
+// expired returns the age of the message and whether it exceeds the maximum age. The publish time is set by the
+// broker, so the maximum age is extended by the clock skew tolerance.
+func (e *messageExpiry) expired(msg pulsar.ConsumerMessage) (time.Duration, bool) {
+	age := connection.Age(msg.PublishTime())
+	return age, age > e.maxAge+connection.ClockSkewTolerance()
+}
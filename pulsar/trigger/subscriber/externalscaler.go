@@ -0,0 +1,223 @@
+package subscriber
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// externalScalerPath prefixes the methods of the externalscaler.ExternalScaler gRPC service of KEDA
+const externalScalerPath = "/externalscaler.ExternalScaler/"
+
+// desiredReplicasMetric is scaled on with a target of one, so KEDA sets the replicas to the desired replicas
+const desiredReplicasMetric = "desiredReplicas"
+
+// maxScalerRequest bounds the gRPC requests of KEDA, which only carry the metadata of the scaled object
+const maxScalerRequest = 1 << 20
+
+// gRPC status codes of the external scaler
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcNotFound        = 5
+	grpcUnimplemented   = 12
+	grpcUnavailable     = 14
+	grpcUnauthenticated = 16
+)
+
+// scaledObjectRef is the ScaledObjectRef message of KEDA, the scalerMetadata holds the metadata of the trigger
+type scaledObjectRef struct {
+	name      string
+	namespace string
+	metadata  map[string]string
+}
+
+// serveExternalScaler implements the IsActive, GetMetricSpec and GetMetrics methods of the gRPC external scaler of
+// KEDA. The handler is named by the "handler" metadata of the trigger and the "scalerToken" metadata must match the
+// scalerToken. StreamIsActive, only used by the external-push scaler, is not implemented.
+func (t *Trigger) serveExternalScaler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC request expected", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	method := strings.TrimPrefix(r.URL.Path, externalScalerPath)
+	if method != "IsActive" && method != "GetMetricSpec" && method != "GetMetrics" {
+		writeGRPCStatus(w, grpcUnimplemented, "method "+method+" is not implemented")
+		return
+	}
+	body, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	var ref scaledObjectRef
+	metricName := desiredReplicasMetric
+	if method == "GetMetrics" {
+		ref, metricName, err = parseGetMetricsRequest(body)
+	} else {
+		ref, err = parseScaledObjectRef(body)
+	}
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	if !t.validScalerToken(ref.metadata["scalerToken"]) {
+		writeGRPCStatus(w, grpcUnauthenticated, "invalid scaler token")
+		return
+	}
+	name := ref.metadata["handler"]
+	state, ok := t.scalingStateOf(name)
+	if !ok {
+		writeGRPCStatus(w, grpcNotFound, "no handler with a scaling signal named ["+name+"]")
+		return
+	}
+	if state == nil {
+		writeGRPCStatus(w, grpcUnavailable, "scaling signal not computed yet")
+		return
+	}
+
+	var resp []byte
+	switch method {
+	case "IsActive":
+		// IsActiveResponse{result}
+		resp = protowire.AppendTag(resp, 1, protowire.VarintType)
+		resp = protowire.AppendVarint(resp, protowire.EncodeBool(state.Backlog > 0))
+	case "GetMetricSpec":
+		// GetMetricSpecResponse{metricSpecs: [MetricSpec{metricName, targetSize}]}
+		resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, appendMetric(nil, desiredReplicasMetric, 1))
+	case "GetMetrics":
+		// GetMetricsResponse{metricValues: [MetricValue{metricName, metricValue}]}
+		resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+		resp = protowire.AppendBytes(resp, appendMetric(nil, metricName, int64(state.DesiredReplicas)))
+	}
+	writeGRPCMessage(w, resp)
+}
+
+// appendMetric appends a MetricSpec or a MetricValue, which share the field numbers of the name and the value
+func appendMetric(b []byte, name string, value int64) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func parseScaledObjectRef(b []byte) (scaledObjectRef, error) {
+	ref := scaledObjectRef{metadata: make(map[string]string)}
+	err := parseFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			ref.name = string(value)
+		case 2:
+			ref.namespace = string(value)
+		case 3:
+			// map entries are messages of the key and the value
+			var key, val string
+			err := parseFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if typ == protowire.BytesType && num == 1 {
+					key = string(value)
+				} else if typ == protowire.BytesType && num == 2 {
+					val = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ref.metadata[key] = val
+		}
+		return nil
+	})
+	return ref, err
+}
+
+func parseGetMetricsRequest(b []byte) (ref scaledObjectRef, metricName string, err error) {
+	ref.metadata = make(map[string]string)
+	err = parseFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		var err error
+		switch num {
+		case 1:
+			ref, err = parseScaledObjectRef(value)
+		case 2:
+			metricName = string(value)
+		}
+		return err
+	})
+	return ref, metricName, err
+}
+
+// parseFields calls field with the fields of the message, the value is the content of length-delimited fields and
+// the encoded value of the others
+func parseFields(b []byte, field func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid message: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var value []byte
+		if typ == protowire.BytesType {
+			value, n = protowire.ConsumeBytes(b)
+		} else if n = protowire.ConsumeFieldValue(num, typ, b); n >= 0 {
+			value = b[:n]
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid message: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := field(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGRPCMessage reads the single length-prefixed message of a unary call
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("invalid gRPC message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxScalerRequest {
+		return nil, fmt.Errorf("gRPC message of %d bytes exceeds %d bytes", size, maxScalerRequest)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("invalid gRPC message: %v", err)
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(append(frame, msg...))
+	w.Header().Set("Grpc-Status", strconv.Itoa(grpcOK))
+}
+
+// writeGRPCStatus ends the call with the status in the trailers, gRPC errors are sent with HTTP status 200 and a
+// percent-encoded message
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(message))
+}
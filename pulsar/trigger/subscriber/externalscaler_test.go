@@ -0,0 +1,144 @@
+package subscriber
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestExternalScaler(t *testing.T) {
+	trg := &Trigger{scalerToken: "secret", handlers: []*Handler{
+		{handler: &stubFlow{}, scaling: &scalingSignal{desired: 3, replicas: 1, backlog: 1200}},
+	}}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(trg.serveExternalScaler))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	valid := map[string]string{"handler": "test", "scalerToken": "secret"}
+	tests := []struct {
+		name       string
+		method     string
+		request    []byte
+		wantStatus string
+		// the name and the varint value of the first field, or of the metric it holds
+		wantMetric string
+		wantValue  uint64
+	}{
+		{"is active", "IsActive", appendScaledObjectRef(nil, valid), "0", "", 1},
+		{"metric spec", "GetMetricSpec", appendScaledObjectRef(nil, valid), "0", desiredReplicasMetric, 1},
+		{"metrics", "GetMetrics", appendGetMetricsRequest(valid, "s0-desiredReplicas"), "0", "s0-desiredReplicas", 3},
+		{"invalid token", "IsActive", appendScaledObjectRef(nil, map[string]string{"handler": "test", "scalerToken": "wrong"}), "16", "", 0},
+		{"unknown handler", "GetMetrics", appendGetMetricsRequest(map[string]string{"handler": "other", "scalerToken": "secret"}, "m"), "5", "", 0},
+		{"stream is active", "StreamIsActive", appendScaledObjectRef(nil, valid), "12", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := make([]byte, 5)
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(tt.request)))
+			req, err := http.NewRequest(http.MethodPost, server.URL+externalScalerPath+tt.method, bytes.NewReader(append(frame, tt.request...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/grpc")
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.ProtoMajor != 2 {
+				t.Fatalf("served with %s, gRPC requires HTTP/2", resp.Proto)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Trailer.Get("Grpc-Status"); got != tt.wantStatus {
+				t.Fatalf("grpc-status = %s (%s), want %s", got, resp.Trailer.Get("Grpc-Message"), tt.wantStatus)
+			}
+			if tt.wantStatus != "0" {
+				return
+			}
+			msg, err := readGRPCMessage(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			metric, value := decodeScalerResponse(t, msg)
+			if metric != tt.wantMetric || value != tt.wantValue {
+				t.Errorf("response = %s %d, want %s %d", metric, value, tt.wantMetric, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestParseScaledObjectRef(t *testing.T) {
+	request := protowire.AppendTag(nil, 1, protowire.BytesType)
+	request = protowire.AppendString(request, "orders")
+	request = protowire.AppendTag(request, 2, protowire.BytesType)
+	request = protowire.AppendString(request, "shop")
+	// unknown fields are skipped
+	request = protowire.AppendTag(request, 9, protowire.VarintType)
+	request = protowire.AppendVarint(request, 7)
+	request = appendScaledObjectRef(request, map[string]string{"handler": "orders"})
+
+	ref, err := parseScaledObjectRef(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.name != "orders" || ref.namespace != "shop" || ref.metadata["handler"] != "orders" {
+		t.Errorf("parseScaledObjectRef() = %+v", ref)
+	}
+	if _, err = parseScaledObjectRef(request[:len(request)-1]); err == nil {
+		t.Error("parseScaledObjectRef() of a truncated message succeeded")
+	}
+}
+
+// appendScaledObjectRef appends the scalerMetadata field of a ScaledObjectRef
+func appendScaledObjectRef(b []byte, metadata map[string]string) []byte {
+	for key, value := range metadata {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, value)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+func appendGetMetricsRequest(metadata map[string]string, metricName string) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, appendScaledObjectRef(nil, metadata))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendString(b, metricName)
+}
+
+// decodeScalerResponse returns the value of IsActiveResponse, or the name and the value of the metric of a
+// GetMetricSpecResponse or a GetMetricsResponse
+func decodeScalerResponse(t *testing.T, msg []byte) (metric string, value uint64) {
+	t.Helper()
+	err := parseFields(msg, func(_ protowire.Number, typ protowire.Type, field []byte) error {
+		if typ != protowire.BytesType {
+			value, _ = protowire.ConsumeVarint(field)
+			return nil
+		}
+		return parseFields(field, func(num protowire.Number, _ protowire.Type, field []byte) error {
+			if num == 1 {
+				metric = string(field)
+			} else if num == 2 {
+				value, _ = protowire.ConsumeVarint(field)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metric, value
+}
@@ -0,0 +1,33 @@
+package subscriber
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// generation is the fencing token of a handler's consumer. It advances whenever the consumer subscribes again,
+// e.g. after a reconnect or a credential refresh, so that sinks can reject writes of flows still processing
+// messages received before. It is derived from the subscribe time, so it also grows across restarts and
+// between instances of the app taking over a subscription.
+type generation struct {
+	value int64
+}
+
+// advance starts a new generation and returns it
+func (g *generation) advance() int64 {
+	for {
+		current := atomic.LoadInt64(&g.value)
+		next := time.Now().UnixMilli()
+		if next <= current {
+			next = current + 1
+		}
+		if atomic.CompareAndSwapInt64(&g.value, current, next) {
+			return next
+		}
+	}
+}
+
+// current returns the generation of the current consumer, 0 before it subscribed
+func (g *generation) current() int64 {
+	return atomic.LoadInt64(&g.value)
+}
@@ -0,0 +1,84 @@
+package subscriber
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const propertyProcessingHistory = "PROCESSING_HISTORY"
+
+// maxProcessingHistory bounds the history of messages which keep failing
+const maxProcessingHistory = 20
+
+// processingAttempt is an entry of the processing history property
+type processingAttempt struct {
+	Attempt      int    `json:"attempt"`
+	Redeliveries uint32 `json:"redeliveries"`
+	Timestamp    string `json:"timestamp"`
+	Host         string `json:"host"`
+	Handler      string `json:"handler"`
+	Topic        string `json:"topic"`
+	Outcome      string `json:"outcome"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// processingHistory appends an attempt to the PROCESSING_HISTORY property whenever the trigger republishes a
+// message, i.e. to the retry topic, the DLQ or another topic, so that the journey of a message is traceable.
+// Redeliveries by the broker cannot change the properties, their number is recorded with the next attempt.
+type processingHistory struct {
+	host    string
+	handler string
+}
+
+// append adds the attempt of the message to the properties, which are the copy to publish
+func (h *processingHistory) append(props map[string]string, msg pulsar.ConsumerMessage, outcome, reason string) {
+	var attempts []processingAttempt
+	if value, ok := props[propertyProcessingHistory]; ok {
+		// a history which is not ours is started over
+		_ = json.Unmarshal([]byte(value), &attempts)
+	}
+	attempt := 1
+	if len(attempts) > 0 {
+		attempt = attempts[len(attempts)-1].Attempt + 1
+	}
+	attempts = append(attempts, processingAttempt{
+		Attempt:      attempt,
+		Redeliveries: msg.RedeliveryCount(),
+		Timestamp:    time.Now().UTC().Format(time.RFC3339Nano),
+		Host:         h.host,
+		Handler:      h.handler,
+		Topic:        msg.Topic(),
+		Outcome:      outcome,
+		Reason:       reason,
+	})
+	if len(attempts) > maxProcessingHistory {
+		attempts = attempts[len(attempts)-maxProcessingHistory:]
+	}
+	data, _ := json.Marshal(attempts)
+	props[propertyProcessingHistory] = string(data)
+}
+
+// historyMessage overrides the properties of a message reconsumed later, the client copies them to the message
+// published to the retry topic or the DLQ
+type historyMessage struct {
+	pulsar.Message
+	properties map[string]string
+}
+
+func (m historyMessage) Properties() map[string]string {
+	return m.properties
+}
+
+func (handler *Handler) withHistory(msg pulsar.ConsumerMessage, outcome string) pulsar.Message {
+	if handler.history == nil {
+		return msg.Message
+	}
+	props := make(map[string]string, len(msg.Properties())+1)
+	for k, v := range msg.Properties() {
+		props[k] = v
+	}
+	handler.history.append(props, msg, outcome, "")
+	return historyMessage{Message: msg.Message, properties: props}
+}
@@ -0,0 +1,162 @@
+package subscriber
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultInferenceSamples = 100
+	maxCapturedSamples      = 5
+)
+
+// schemaInference samples consumed payloads, infers a JSON schema covering all of them and writes it,
+// along with a few sample payloads, to a local file to help building the flow's output schema
+type schemaInference struct {
+	lock     sync.Mutex
+	file     string
+	limit    int
+	observed int
+	root     *schemaNode
+	samples  []interface{}
+	done     bool
+}
+
+type schemaNode struct {
+	types      map[string]bool
+	properties map[string]*schemaNode
+	seen       map[string]int
+	objects    int
+	items      *schemaNode
+}
+
+func newSchemaInference(file string, limit int) *schemaInference {
+	if limit <= 0 {
+		limit = defaultInferenceSamples
+	}
+	return &schemaInference{file: file, limit: limit, root: &schemaNode{}}
+}
+
+// observe adds a payload to the inferred schema, the schema is written once enough payloads were sampled
+func (si *schemaInference) observe(payload interface{}) error {
+	si.lock.Lock()
+	defer si.lock.Unlock()
+	if si.done {
+		return nil
+	}
+	if str, ok := payload.(string); ok {
+		var obj interface{}
+		if json.Unmarshal([]byte(str), &obj) == nil {
+			payload = obj
+		}
+	}
+	si.root.add(payload)
+	if len(si.samples) < maxCapturedSamples {
+		si.samples = append(si.samples, payload)
+	}
+	si.observed++
+	if si.observed < si.limit {
+		return nil
+	}
+	si.done = true
+	return si.write()
+}
+
+func (si *schemaInference) write() error {
+	schema := si.root.toSchema()
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(si.file, data, 0644); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(si.samples, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(si.file+".samples", data, 0644)
+}
+
+func (n *schemaNode) add(v interface{}) {
+	if n.types == nil {
+		n.types = make(map[string]bool)
+	}
+	switch value := v.(type) {
+	case nil:
+		n.types["null"] = true
+	case bool:
+		n.types["boolean"] = true
+	case float64:
+		if value == float64(int64(value)) {
+			n.types["integer"] = true
+		} else {
+			n.types["number"] = true
+		}
+	case string:
+		n.types["string"] = true
+	case []interface{}:
+		n.types["array"] = true
+		if n.items == nil {
+			n.items = &schemaNode{}
+		}
+		for _, item := range value {
+			n.items.add(item)
+		}
+	case map[string]interface{}:
+		n.types["object"] = true
+		if n.properties == nil {
+			n.properties = make(map[string]*schemaNode)
+			n.seen = make(map[string]int)
+		}
+		n.objects++
+		for k, item := range value {
+			child, ok := n.properties[k]
+			if !ok {
+				child = &schemaNode{}
+				n.properties[k] = child
+			}
+			child.add(item)
+			n.seen[k]++
+		}
+	}
+}
+
+func (n *schemaNode) toSchema() map[string]interface{} {
+	schema := make(map[string]interface{})
+	if n.types["integer"] && n.types["number"] {
+		delete(n.types, "integer")
+	}
+	var types []string
+	for t := range n.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	if len(types) == 1 {
+		schema["type"] = types[0]
+	} else if len(types) > 1 {
+		schema["type"] = types
+	}
+	if n.properties != nil {
+		properties := make(map[string]interface{}, len(n.properties))
+		var required []string
+		for k, child := range n.properties {
+			properties[k] = child.toSchema()
+			if n.seen[k] == n.objects {
+				required = append(required, k)
+			}
+		}
+		sort.Strings(required)
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	if n.items != nil {
+		schema["items"] = n.items.toSchema()
+	}
+	return schema
+}
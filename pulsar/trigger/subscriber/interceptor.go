@@ -0,0 +1,79 @@
+package subscriber
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/project-flogo/core/support/log"
+)
+
+// registeredInterceptor is a consumer interceptor registered by name
+type registeredInterceptor struct {
+	name        string
+	interceptor pulsar.ConsumerInterceptor
+}
+
+var (
+	interceptorsLock sync.RWMutex
+	interceptors     []registeredInterceptor
+)
+
+// RegisterConsumerInterceptor registers an interceptor, e.g. for audit logging or metrics, which the trigger
+// attaches to every consumer it creates, in the order of registration. It is typically called from the init
+// function of the registering package, consumers created before are not intercepted.
+func RegisterConsumerInterceptor(name string, interceptor pulsar.ConsumerInterceptor) error {
+	interceptorsLock.Lock()
+	defer interceptorsLock.Unlock()
+	for _, i := range interceptors {
+		if i.name == name {
+			return fmt.Errorf("consumer interceptor [%s] already registered", name)
+		}
+	}
+	interceptors = append(interceptors, registeredInterceptor{name: name, interceptor: interceptor})
+	return nil
+}
+
+// consumerInterceptors returns the registered interceptors, nil if there are none
+func consumerInterceptors(logger log.Logger) pulsar.ConsumerInterceptors {
+	interceptorsLock.RLock()
+	defer interceptorsLock.RUnlock()
+	if len(interceptors) == 0 {
+		return nil
+	}
+	result := make(pulsar.ConsumerInterceptors, 0, len(interceptors))
+	for _, i := range interceptors {
+		result = append(result, &guardedInterceptor{registeredInterceptor: i, logger: logger})
+	}
+	return result
+}
+
+// guardedInterceptor recovers the panics of an interceptor, which is called on the goroutines of the client
+type guardedInterceptor struct {
+	registeredInterceptor
+	logger log.Logger
+}
+
+func (g *guardedInterceptor) recoverPanic() {
+	if r := recover(); r != nil {
+		g.logger.Errorf("Consumer interceptor [%s] panicked: %v", g.name, r)
+	}
+}
+
+// BeforeConsume implements pulsar.ConsumerInterceptor.BeforeConsume
+func (g *guardedInterceptor) BeforeConsume(message pulsar.ConsumerMessage) {
+	defer g.recoverPanic()
+	g.interceptor.BeforeConsume(message)
+}
+
+// OnAcknowledge implements pulsar.ConsumerInterceptor.OnAcknowledge
+func (g *guardedInterceptor) OnAcknowledge(consumer pulsar.Consumer, msgID pulsar.MessageID) {
+	defer g.recoverPanic()
+	g.interceptor.OnAcknowledge(consumer, msgID)
+}
+
+// OnNegativeAcksSend implements pulsar.ConsumerInterceptor.OnNegativeAcksSend
+func (g *guardedInterceptor) OnNegativeAcksSend(consumer pulsar.Consumer, msgIDs []pulsar.MessageID) {
+	defer g.recoverPanic()
+	g.interceptor.OnNegativeAcksSend(consumer, msgIDs)
+}
@@ -0,0 +1,40 @@
+package subscriber
+
+import (
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/engine"
+)
+
+// startSingleton campaigns for the lead of a singleton handler, which only consumes on the replica leading it
+func (t *Trigger) startSingleton(handler *Handler) {
+	if handler.electionDone != nil {
+		return
+	}
+	name := engine.GetAppName() + "-" + handler.handler.Name() + "-leader"
+	handler.election = connection.NewLeaderElection(t.connMgr, t.leaderTopic, name, handler.consumerOpts.Name, t.leaderInterval)
+	handler.electionDone = make(chan bool)
+	leaders.WithLabelValues(handler.handler.Name()).Set(0)
+	handler.logger.Infof("Handler [%s] is a singleton, standing by until elected", handler.handler.Name())
+	go handler.election.Run(handler.electionDone, func() {
+		leaders.WithLabelValues(handler.handler.Name()).Set(1)
+		handler.start(t.connMgr)
+	}, func() {
+		leaders.WithLabelValues(handler.handler.Name()).Set(0)
+		handler.drain("leader election")
+	})
+}
+
+// stopSingleton releases the lead of a singleton handler when the trigger is stopped or paused, another replica
+// takes over
+func (handler *Handler) stopSingleton() {
+	if handler.electionDone != nil {
+		close(handler.electionDone)
+		handler.electionDone = nil
+		leaders.WithLabelValues(handler.handler.Name()).Set(0)
+	}
+}
+
+// standingBy returns true for a singleton handler led by another replica
+func (handler *Handler) standingBy() bool {
+	return handler.election != nil && !handler.election.IsLeader()
+}
@@ -0,0 +1,99 @@
+package subscriber
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handlerLoad holds the figures of a handler between two load reports
+type handlerLoad struct {
+	acked, nacked      int64
+	processed          int64
+	totalTime, maxTime time.Duration
+}
+
+// handlerStats accumulates per handler figures between two load reports
+type handlerStats struct {
+	lock sync.Mutex
+	handlerLoad
+}
+
+func (s *handlerStats) recordAck(acked bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if acked {
+		s.acked++
+	} else {
+		s.nacked++
+	}
+}
+
+func (s *handlerStats) recordProcessingTime(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.processed++
+	s.totalTime += d
+	if d > s.maxTime {
+		s.maxTime = d
+	}
+}
+
+// reset returns the figures since the last reset and starts over
+func (s *handlerStats) reset() handlerLoad {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snap := s.handlerLoad
+	s.handlerLoad = handlerLoad{}
+	return snap
+}
+
+// reportLoad periodically logs each handler's share of the messages, its nack rate and processing times,
+// helping to detect skew between handlers of Shared and KeyShared subscriptions
+func (t *Trigger) reportLoad(interval time.Duration, done chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.logLoadReport()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (t *Trigger) logLoadReport() {
+	snaps := make(map[string]handlerLoad, len(t.handlers))
+	names := make([]string, 0, len(t.handlers))
+	var total int64
+	for _, handler := range t.handlers {
+		snap := handler.stats.reset()
+		total += snap.acked + snap.nacked
+		snaps[handler.handler.Name()] = snap
+		names = append(names, handler.handler.Name())
+	}
+	sort.Strings(names)
+
+	var report strings.Builder
+	for _, name := range names {
+		snap := snaps[name]
+		count := snap.acked + snap.nacked
+		var share, nackRate float64
+		var avg time.Duration
+		if total > 0 {
+			share = float64(count) * 100 / float64(total)
+		}
+		if count > 0 {
+			nackRate = float64(snap.nacked) * 100 / float64(count)
+		}
+		if snap.processed > 0 {
+			avg = snap.totalTime / time.Duration(snap.processed)
+		}
+		report.WriteString(fmt.Sprintf("\n  handler [%s]: messages=%d share=%.1f%% nackRate=%.1f%% avgProcessingTime=%v maxProcessingTime=%v",
+			name, count, share, nackRate, avg, snap.maxTime))
+	}
+	t.logger.Infof("Pulsar handler load report, %d messages in total:%s", total, report.String())
+}
@@ -0,0 +1,190 @@
+package subscriber
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const managementPath = "/manage/handlers/"
+
+// redactedSettings are the handler settings which are masked by the management API, e.g. private keys
+var redactedSettings = map[string]bool{"decryptionKey": true}
+
+// handlerState is returned by the management endpoint for a handler
+type handlerState struct {
+	Handler  string                 `json:"handler"`
+	State    interface{}            `json:"state"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// handlerLag is the backlog of the subscription of a handler
+type handlerLag struct {
+	Handler    string  `json:"handler"`
+	Backlog    int64   `json:"backlog"`
+	MsgRateIn  float64 `json:"msgRateIn"`
+	MsgRateOut float64 `json:"msgRateOut"`
+}
+
+// startManagement serves the management API of the handlers on the managementAddress, so that consumers can be
+// paused, resumed and moved without redeploying the app
+func (t *Trigger) startManagement() error {
+	listener, err := net.Listen("tcp", t.managementAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(managementPath, t.serveManagement)
+	t.management = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			t.logger.Errorf("Management endpoint stopped: %v", err)
+		}
+	}(t.management)
+	t.logger.Infof("Serving the management API on [%s]", listener.Addr())
+	return nil
+}
+
+func (t *Trigger) stopManagement() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = t.management.Shutdown(ctx)
+	t.management = nil
+}
+
+// serveManagement serves /manage/handlers/<handler name>[/<operation>]:
+//
+//	GET  /manage/handlers/orders          state and settings of the handler
+//	GET  /manage/handlers/orders/lag      backlog of the subscription
+//	POST /manage/handlers/orders/pause    pause, resume or drain the handler
+//	POST /manage/handlers/orders/seek     move the subscription, ?time=<RFC3339 or -2h>, ?beforeLatest=<count> or ?messageId=<msgid>
+func (t *Trigger) serveManagement(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(t.managementToken)) != 1 {
+		http.Error(w, "invalid management token", http.StatusUnauthorized)
+		return
+	}
+	name, operation := strings.TrimPrefix(r.URL.Path, managementPath), ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, operation = name[:i], name[i+1:]
+	}
+	var handler *Handler
+	for _, h := range t.handlers {
+		if h.handler.Name() == name {
+			handler = h
+			break
+		}
+	}
+	if handler == nil {
+		http.Error(w, "no handler named ["+name+"]", http.StatusNotFound)
+		return
+	}
+
+	method := http.MethodPost
+	if operation == "" || operation == "lag" {
+		method = http.MethodGet
+	}
+	if r.Method != method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch operation {
+	case "":
+		writeJSON(w, handlerState{Handler: name, State: handler.Diagnostics(), Settings: publicSettings(handler.handler.Settings())})
+	case "lag":
+		stats, err := handler.subscriptionStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		sub := stats.Subscriptions[handler.consumerOpts.SubscriptionName]
+		writeJSON(w, handlerLag{Handler: name, Backlog: sub.MsgBacklog, MsgRateIn: stats.MsgRateIn, MsgRateOut: sub.MsgRateOut})
+	case ControlActionPause, ControlActionResume, ControlActionDrain:
+		if handler.isCanary {
+			http.Error(w, "canary handlers follow their primary handler", http.StatusConflict)
+			return
+		}
+		t.applyControl(handler, operation, "management API")
+		w.WriteHeader(http.StatusNoContent)
+	case "seek":
+		t.manageSeek(w, r, handler)
+	default:
+		http.Error(w, "unknown operation ["+operation+"]", http.StatusNotFound)
+	}
+}
+
+func (t *Trigger) manageSeek(w http.ResponseWriter, r *http.Request, handler *Handler) {
+	if handler.isCanary {
+		http.Error(w, "the handler is not subscribed", http.StatusConflict)
+		return
+	}
+	var err error
+	query := r.URL.Query()
+	switch {
+	case query.Get("time") != "":
+		var ts time.Time
+		if ts, err = startTime(query.Get("time")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = handler.withConsumer(func(consumer pulsar.Consumer) error { return consumer.SeekByTime(ts) })
+	case query.Get("beforeLatest") != "":
+		var count int
+		if count, err = strconv.Atoi(query.Get("beforeLatest")); err != nil || count <= 0 {
+			http.Error(w, "invalid beforeLatest count", http.StatusBadRequest)
+			return
+		}
+		err = handler.seekBeforeLatest(count)
+	case query.Get("messageId") != "":
+		id, perr := parseMsgID(query.Get("messageId"))
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		err = handler.withConsumer(func(consumer pulsar.Consumer) error { return consumer.Seek(id) })
+	default:
+		http.Error(w, "time, beforeLatest or messageId is required", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errNotSubscribed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	handler.logger.Infof("Subscription of handler [%s] moved by management API", handler.handler.Name())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// publicSettings returns the settings of the handler declared in HandlerSettings, with the secrets masked
+func publicSettings(settings map[string]interface{}) map[string]interface{} {
+	public := make(map[string]interface{})
+	fields := reflect.TypeOf(HandlerSettings{})
+	for i := 0; i < fields.NumField(); i++ {
+		name := strings.Split(fields.Field(i).Tag.Get("md"), ",")[0]
+		value, ok := settings[name]
+		if name == "" || !ok {
+			continue
+		}
+		if redactedSettings[name] && value != nil && value != "" {
+			value = "****"
+		}
+		public[name] = value
+	}
+	return public
+}
@@ -6,26 +6,125 @@ import (
 )
 
 type Settings struct {
-	Connection connection.Manager `md:"connection,required"`
+	Connection         connection.Manager `md:"connection,required"`
+	LoadReportInterval int                `md:"loadReportInterval"`
+	ControlTopic       string             `md:"controlTopic"`
+	ScalingInterval    int                `md:"scalingInterval"`
+	ScalerAddress      string             `md:"scalerAddress"`
+	ScalerToken        string             `md:"scalerToken"`
+	ScalerCertFile     string             `md:"scalerCertFile"`
+	ScalerKeyFile      string             `md:"scalerKeyFile"`
+	ManagementAddress  string             `md:"managementAddress"`
+	ManagementToken    string             `md:"managementToken"`
+	LeaderTopic        string             `md:"leaderTopic"`
+	LeaderInterval     int                `md:"leaderInterval"`
 }
 
 type HandlerSettings struct {
-	Topic               string `md:"topic,required"`
-	Subscription        string `md:"subscriptionName,required"`
-	SubscriptionType    string `md:"subscriptionType"`
-	ProcessingMode      string `md:"processingMode"`
-	InitialPosition     string `md:"initialPosition"`
-	DLQMaxDeliveries    int    `md:"dlqMaxDeliveries"`
-	DLQTopic            string `md:"dlqTopic"`
-	NackRedeliveryDelay int    `md:"nackRedeliveryDelay"`
+	Topic                  string  `md:"topic"`
+	Subscription           string  `md:"subscriptionName,required"`
+	SubscriptionType       string  `md:"subscriptionType"`
+	ProcessingMode         string  `md:"processingMode"`
+	InitialPosition        string  `md:"initialPosition"`
+	StartBeforeLatest      int     `md:"startBeforeLatest"`
+	StartFromMessageID     string  `md:"startFromMessageId"`
+	StartFromTime          string  `md:"startFromTime"`
+	DecryptionKey          string  `md:"decryptionKey"`
+	CryptoFailureAction    string  `md:"consumerCryptoFailureAction"`
+	Singleton              bool    `md:"singleton"`
+	SagaCompensate         bool    `md:"sagaCompensate"`
+	DLQMaxDeliveries       int     `md:"dlqMaxDeliveries"`
+	DLQTopic               string  `md:"dlqTopic"`
+	NackRedeliveryDelay    int     `md:"nackRedeliveryDelay"`
+	PriorityProperty       string  `md:"priorityProperty"`
+	PriorityValues         string  `md:"priorityValues"`
+	PriorityWorkers        int     `md:"priorityWorkers"`
+	MaxPayloadSize         int     `md:"maxPayloadSize"`
+	PreProcessors          string  `md:"preProcessors"`
+	WatermarkSource        string  `md:"watermarkSource"`
+	WatermarkField         string  `md:"watermarkField"`
+	WatermarkLateness      int     `md:"watermarkLateness"`
+	SessionGap             int     `md:"sessionGap"`
+	SessionMaxMessages     int     `md:"sessionMaxMessages"`
+	BatchMaxMessages       int     `md:"batchMaxMessages"`
+	BatchTimeout           int     `md:"batchTimeout"`
+	RetryCount             int     `md:"retryCount"`
+	RetryDelay             int     `md:"retryDelay"`
+	RetryOn                string  `md:"retryOn"`
+	CircuitBreaker         string  `md:"circuitBreaker"`
+	SampleRate             float64 `md:"sampleRate"`
+	SampleByKey            bool    `md:"sampleByKey"`
+	ShadowMode             bool    `md:"shadowMode"`
+	CanaryOf               string  `md:"canaryOf"`
+	CanaryPercent          float64 `md:"canaryPercent"`
+	CanaryByKey            bool    `md:"canaryByKey"`
+	StuckThreshold         int     `md:"stuckThreshold"`
+	NackStuck              bool    `md:"nackStuck"`
+	AckTimeout             int     `md:"ackTimeout"`
+	AckWithResponse        bool    `md:"ackWithResponse"`
+	SchemaInferenceFile    string  `md:"schemaInferenceFile"`
+	SchemaInferenceSamples int     `md:"schemaInferenceSamples"`
+	OutputSchema           string  `md:"outputSchema"`
+	SchemaMismatch         string  `md:"schemaMismatch"`
+	Decompress             string  `md:"decompress"`
+	MaxMessageAge          int     `md:"maxMessageAge"`
+	ExpiredAction          string  `md:"expiredAction"`
+	ExpiredTopic           string  `md:"expiredTopic"`
+	Pipeline               string  `md:"pipeline"`
+	NextTopic              string  `md:"nextTopic"`
+	GenerationFencing      bool    `md:"generationFencing"`
+	RetryEnable            bool    `md:"retryEnable"`
+	RetryLetterTopic       string  `md:"retryLetterTopic"`
+	MaxRedeliveries        int     `md:"maxRedeliveries"`
+	Charset                string  `md:"charset"`
+	AssertPolicies         string  `md:"assertPolicies"`
+	PolicyViolation        string  `md:"policyViolation"`
+	ScalingTargetLag       int     `md:"scalingTargetLag"`
+	ScalingMinReplicas     int     `md:"scalingMinReplicas"`
+	ScalingMaxReplicas     int     `md:"scalingMaxReplicas"`
+	TenantProperty         string  `md:"tenantProperty"`
+	TenantValue            string  `md:"tenantValue"`
+	ProcessingHistory      bool    `md:"processingHistory"`
+	TopicsPattern          string  `md:"topicsPattern"`
+	AutoDiscoveryPeriod    int     `md:"autoDiscoveryPeriod"`
+	ReceiverQueueSize      int     `md:"receiverQueueSize"`
+	ReadCompacted          bool    `md:"readCompacted"`
+	ReplicateSubscription  bool    `md:"replicateSubscriptionState"`
+	MaxInFlightBytes       int64   `md:"maxInFlightBytes"`
+	VerifyChecksum         bool    `md:"verifyChecksum"`
+	DedupWindow            int     `md:"dedupWindow"`
+	DedupHash              string  `md:"dedupHash"`
+	DedupProperties        string  `md:"dedupProperties"`
+	SchemaType             string  `md:"schemaType"`
+	SchemaDefinition       string  `md:"schemaDefinition"`
+	ProtoDescriptor        string  `md:"protoDescriptor"`
+	ProtoMessage           string  `md:"protoMessage"`
+	ProjectFields          string  `md:"projectFields"`
 }
 
 type Output struct {
-	Properties      map[string]string `md:"properties"`
-	Payload         interface{}       `md:"payload"`
-	Topic           string            `md:"topic"`
-	Msgid           string            `md:"msgid"`
-	RedeliveryCount int               `md:"redeliveryCount"`
+	Properties           map[string]string      `md:"properties"`
+	Payload              interface{}            `md:"payload"`
+	Topic                string                 `md:"topic"`
+	Msgid                string                 `md:"msgid"`
+	RedeliveryCount      int                    `md:"redeliveryCount"`
+	Watermark            int64                  `md:"watermark"`
+	Late                 bool                   `md:"late"`
+	Key                  string                 `md:"key"`
+	Messages             []interface{}          `md:"messages"`
+	Expired              bool                   `md:"expired"`
+	Context              map[string]string      `md:"context"`
+	Generation           int64                  `md:"generation"`
+	StructuredProperties map[string]interface{} `md:"structuredProperties"`
+	SchemaVersion        int64                  `md:"schemaVersion"`
+	OriginCluster        string                 `md:"originCluster"`
+	Partition            int                    `md:"partition"`
+	Identity             map[string]string      `md:"identity"`
+	Encrypted            bool                   `md:"encrypted"`
+	Saga                 map[string]interface{} `md:"saga"`
+	BatchIndex           int                    `md:"batchIndex"`
+	BatchSize            int                    `md:"batchSize"`
+	CompressionType      string                 `md:"compressionType"`
 }
 
 func (o *Output) FromMap(values map[string]interface{}) error {
@@ -51,15 +150,128 @@ func (o *Output) FromMap(values map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
+	o.Watermark, err = coerce.ToInt64(values["watermark"])
+	if err != nil {
+		return err
+	}
+	o.Late, err = coerce.ToBool(values["late"])
+	if err != nil {
+		return err
+	}
+	o.Key, err = coerce.ToString(values["key"])
+	if err != nil {
+		return err
+	}
+	o.Messages, err = coerce.ToArray(values["messages"])
+	if err != nil {
+		return err
+	}
+	o.Expired, err = coerce.ToBool(values["expired"])
+	if err != nil {
+		return err
+	}
+	o.Context, err = coerce.ToParams(values["context"])
+	if err != nil {
+		return err
+	}
+	o.Generation, err = coerce.ToInt64(values["generation"])
+	if err != nil {
+		return err
+	}
+	o.StructuredProperties, err = coerce.ToObject(values["structuredProperties"])
+	if err != nil {
+		return err
+	}
+	o.SchemaVersion, err = coerce.ToInt64(values["schemaVersion"])
+	if err != nil {
+		return err
+	}
+	o.OriginCluster, err = coerce.ToString(values["originCluster"])
+	if err != nil {
+		return err
+	}
+	o.Partition, err = coerce.ToInt(values["partition"])
+	if err != nil {
+		return err
+	}
+	o.Identity, err = coerce.ToParams(values["identity"])
+	if err != nil {
+		return err
+	}
+	o.Encrypted, err = coerce.ToBool(values["encrypted"])
+	if err != nil {
+		return err
+	}
+	o.Saga, err = coerce.ToObject(values["saga"])
+	if err != nil {
+		return err
+	}
+	o.BatchIndex, err = coerce.ToInt(values["batchIndex"])
+	if err != nil {
+		return err
+	}
+	o.BatchSize, err = coerce.ToInt(values["batchSize"])
+	if err != nil {
+		return err
+	}
+	o.CompressionType, err = coerce.ToString(values["compressionType"])
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 func (o *Output) ToMap() map[string]interface{} {
 	return map[string]interface{}{
-		"payload":         o.Payload,
-		"properties":      o.Properties,
-		"topic":           o.Topic,
-		"msgid":           o.Msgid,
-		"redeliveryCount": o.RedeliveryCount,
+		"payload":              o.Payload,
+		"properties":           o.Properties,
+		"topic":                o.Topic,
+		"msgid":                o.Msgid,
+		"redeliveryCount":      o.RedeliveryCount,
+		"watermark":            o.Watermark,
+		"late":                 o.Late,
+		"key":                  o.Key,
+		"messages":             o.Messages,
+		"expired":              o.Expired,
+		"context":              o.Context,
+		"generation":           o.Generation,
+		"structuredProperties": o.StructuredProperties,
+		"schemaVersion":        o.SchemaVersion,
+		"originCluster":        o.OriginCluster,
+		"partition":            o.Partition,
+		"identity":             o.Identity,
+		"encrypted":            o.Encrypted,
+		"saga":                 o.Saga,
+		"batchIndex":           o.BatchIndex,
+		"batchSize":            o.BatchSize,
+		"compressionType":      o.CompressionType,
+	}
+}
+
+type Reply struct {
+	Data           interface{}   `md:"data"`
+	ReconsumeLater int           `md:"reconsumeLater"`
+	NackMessages   []interface{} `md:"nackMessages"`
+}
+
+func (r *Reply) FromMap(values map[string]interface{}) error {
+	var err error
+	r.Data = values["data"]
+	r.ReconsumeLater, err = coerce.ToInt(values["reconsumeLater"])
+	if err != nil {
+		return err
+	}
+	r.NackMessages, err = coerce.ToArray(values["nackMessages"])
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Reply) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"data":           r.Data,
+		"reconsumeLater": r.ReconsumeLater,
+		"nackMessages":   r.NackMessages,
 	}
 }
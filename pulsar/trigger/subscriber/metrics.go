@@ -0,0 +1,74 @@
+package subscriber
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are registered with the default prometheus registry, which is also used by the pulsar client
+var (
+	oversizedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_oversized_messages_total",
+		Help: "Number of consumed messages rejected because their payload exceeded maxPayloadSize",
+	}, []string{"handler", "topic"})
+	handledMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_messages_total",
+		Help: "Number of consumed messages by outcome (ack, ack_failed, nack, reconsume)",
+	}, []string{"handler", "result"})
+	processingTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pulsar_trigger_processing_seconds",
+		Help:    "Time spent by the flow processing a consumed message",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"handler"})
+	watermarkGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pulsar_trigger_watermark_seconds",
+		Help: "Current event-time watermark of a handler, in seconds since the epoch",
+	}, []string{"handler"})
+	stuckMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_stuck_messages_total",
+		Help: "Number of messages whose flow ran longer than stuckThreshold",
+	}, []string{"handler"})
+	expiredMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_expired_messages_total",
+		Help: "Number of consumed messages older than maxMessageAge",
+	}, []string{"handler"})
+	desiredReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pulsar_trigger_desired_replicas",
+		Help: "Number of app replicas needed to drain the subscription backlog within scalingTargetLag",
+	}, []string{"handler"})
+	subscriptionBacklog = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pulsar_trigger_subscription_backlog",
+		Help: "Number of messages in the backlog of the subscription of a handler",
+	}, []string{"handler"})
+	filteredMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_filtered_messages_total",
+		Help: "Number of consumed messages of other tenants acknowledged without triggering the flow",
+	}, []string{"handler"})
+	checksumFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_checksum_failures_total",
+		Help: "Number of consumed messages rejected because their payload did not match its checksum",
+	}, []string{"handler"})
+	ackTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_ack_timeouts_total",
+		Help: "Number of messages negatively acknowledged because they were not acknowledged within the ack timeout",
+	}, []string{"handler"})
+	resubscribes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_resubscribes_total",
+		Help: "Number of consumers re-created because their message channel was closed",
+	}, []string{"handler"})
+	leaders = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pulsar_trigger_leader",
+		Help: "1 while the replica leads a singleton handler, 0 while it stands by",
+	}, []string{"handler"})
+	duplicateMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_duplicate_messages_total",
+		Help: "Number of messages acknowledged without triggering the flow because their content was received within the dedup window",
+	}, []string{"handler"})
+	compensations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pulsar_trigger_saga_compensations_total",
+		Help: "Number of saga messages routed to their compensation topic after the flow failed",
+	}, []string{"handler"})
+)
+
+func init() {
+	prometheus.MustRegister(oversizedMessages, handledMessages, processingTime, watermarkGauge, stuckMessages, expiredMessages, desiredReplicas, subscriptionBacklog, filteredMessages, checksumFailures, ackTimeouts, resubscribes, leaders, compensations, duplicateMessages)
+}
@@ -0,0 +1,75 @@
+package subscriber
+
+import (
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const partitionQueueSize = 100
+
+// partitionWorkers processes the messages of each partition of a topic on a worker of its own. Messages of a
+// partition are processed one after the other in the order they were received, while partitions are processed
+// in parallel. The consumer of a partitioned topic holds a consumer per partition, so the messages are
+// acknowledged on the partition they were received from.
+type partitionWorkers struct {
+	handler *Handler
+	lock    sync.Mutex
+	queues  map[string]chan pulsar.ConsumerMessage
+}
+
+func newPartitionWorkers(handler *Handler) *partitionWorkers {
+	return &partitionWorkers{handler: handler, queues: make(map[string]chan pulsar.ConsumerMessage)}
+}
+
+// dispatch hands the message to the worker of its partition, it returns false if the handler was stopped meanwhile
+func (w *partitionWorkers) dispatch(msg pulsar.ConsumerMessage, done chan bool) bool {
+	select {
+	case w.queue(msg.Topic(), done) <- msg:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// queue returns the queue of the partition, starting its worker on first use
+func (w *partitionWorkers) queue(partition string, done chan bool) chan pulsar.ConsumerMessage {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if queue, ok := w.queues[partition]; ok {
+		return queue
+	}
+	queue := make(chan pulsar.ConsumerMessage, partitionQueueSize)
+	w.queues[partition] = queue
+	go w.run(partition, queue, done)
+	w.handler.logger.Infof("Started worker for partition [%s]", partition)
+	return queue
+}
+
+func (w *partitionWorkers) run(partition string, queue chan pulsar.ConsumerMessage, done chan bool) {
+	defer func() {
+		w.lock.Lock()
+		if w.queues[partition] == queue {
+			delete(w.queues, partition)
+		}
+		w.lock.Unlock()
+	}()
+	for {
+		select {
+		case msg := <-queue:
+			w.handler.handleMessage(msg)
+		case <-done:
+			// the messages queued for the partition are redelivered
+			for {
+				select {
+				case msg := <-queue:
+					w.handler.nack(msg)
+					w.handler.inFlightBytes.Release(int64(len(msg.Payload())))
+					w.handler.inFlight.Done()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
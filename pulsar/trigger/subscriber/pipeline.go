@@ -0,0 +1,123 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/trigger"
+)
+
+const (
+	propertyPipeline      = "PIPELINE"
+	propertyPipelineStage = "PIPELINE_STAGE"
+	replyData             = "data"
+)
+
+// applyPipelines wires the stages of each pipeline, i.e. the handlers with the same pipeline setting. Each stage
+// consumes the topic the previous stage publishes to with nextTopic. Stages which do not configure a retry or
+// dead letter policy of their own inherit the one of the first stage, so failures are treated alike in all stages.
+func applyPipelines(handlers []trigger.Handler, settings []*HandlerSettings) error {
+	pipelines := make(map[string][]int)
+	var names []string
+	for i, s := range settings {
+		if s.Pipeline == "" {
+			continue
+		}
+		if _, ok := pipelines[s.Pipeline]; !ok {
+			names = append(names, s.Pipeline)
+		}
+		pipelines[s.Pipeline] = append(pipelines[s.Pipeline], i)
+	}
+
+	for _, name := range names {
+		stages := pipelines[name]
+		next := make(map[string]bool)
+		for _, i := range stages {
+			if settings[i].NextTopic != "" {
+				next[stageTopic(settings[i].NextTopic)] = true
+			}
+		}
+		first := -1
+		for _, i := range stages {
+			if next[stageTopic(settings[i].Topic)] {
+				continue
+			}
+			if first >= 0 {
+				return fmt.Errorf("pipeline [%s] has more than one first stage: handlers [%s] and [%s] consume topics no stage publishes to", name, handlers[first].Name(), handlers[i].Name())
+			}
+			first = i
+		}
+		if first < 0 {
+			return fmt.Errorf("pipeline [%s] has no first stage, the stages form a cycle", name)
+		}
+
+		policy := settings[first]
+		for _, i := range stages {
+			s := settings[i]
+			if s.RetryCount == 0 && s.RetryDelay == 0 && s.RetryOn == "" {
+				s.RetryCount, s.RetryDelay, s.RetryOn = policy.RetryCount, policy.RetryDelay, policy.RetryOn
+			}
+			if s.DLQTopic == "" {
+				s.DLQTopic, s.DLQMaxDeliveries = policy.DLQTopic, policy.DLQMaxDeliveries
+			}
+			if s.NackRedeliveryDelay == 0 {
+				s.NackRedeliveryDelay = policy.NackRedeliveryDelay
+			}
+			handlers[i].Logger().Infof("Handler is a stage of pipeline [%s], consuming [%s] and publishing to [%s]", name, s.Topic, s.NextTopic)
+		}
+	}
+	return nil
+}
+
+// stageTopic returns the fully qualified topic name, so that the short and the qualified name of a topic match
+func stageTopic(topic string) string {
+	if qualified, err := connection.NormalizeTopic(topic, true); err == nil {
+		return qualified
+	}
+	return topic
+}
+
+// forwardStage publishes the result of the flow to the next stage of the pipeline. The flow may return the
+// payload for the next stage as data, otherwise the consumed payloads are forwarded unchanged.
+func (handler *Handler) forwardStage(msgs []pulsar.ConsumerMessage, attrs map[string]interface{}) error {
+	producer, err := handler.getProducer(handler.nextTopic)
+	if err != nil {
+		return err
+	}
+	if result, ok := attrs[replyData]; ok && result != nil {
+		payload, err := coerce.ToBytes(result)
+		if err != nil {
+			return fmt.Errorf("unable to coerce the flow result to the payload for topic [%s]: %v", handler.nextTopic, err)
+		}
+		return handler.sendStage(producer, msgs[0], payload)
+	}
+	for _, msg := range msgs {
+		if err = handler.sendStage(producer, msg, msg.Payload()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (handler *Handler) sendStage(producer pulsar.Producer, msg pulsar.ConsumerMessage, payload []byte) error {
+	props := make(map[string]string, len(msg.Properties())+3)
+	for k, v := range msg.Properties() {
+		props[k] = v
+	}
+	props[propertyOriginMessageID] = formatMsgID(msg.ID())
+	props[propertyPipeline] = handler.pipeline
+	props[propertyPipelineStage] = handler.handler.Name()
+	if handler.history != nil {
+		handler.history.append(props, msg, "forwarded", "")
+	}
+	_, err := producer.Send(context.Background(), &pulsar.ProducerMessage{
+		Payload:    payload,
+		Key:        msg.Key(),
+		Properties: props,
+		EventTime:  msg.EventTime(),
+	})
+	return err
+}
@@ -0,0 +1,66 @@
+package subscriber
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Message is the consumed message as seen by pre-processors. Pre-processors may replace the payload
+// and modify the properties before the trigger output is constructed.
+type Message struct {
+	Topic      string
+	Key        string
+	Payload    []byte
+	Properties map[string]string
+}
+
+// PreProcessor transforms consumed messages (e.g. decrypt, decompress, enrich) before the flow is invoked
+type PreProcessor interface {
+	Process(ctx context.Context, msg *Message) error
+}
+
+// PreProcessorFunc adapts a function to a PreProcessor
+type PreProcessorFunc func(ctx context.Context, msg *Message) error
+
+// Process implements PreProcessor.Process
+func (f PreProcessorFunc) Process(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+var (
+	preProcessorsLock sync.RWMutex
+	preProcessors     = make(map[string]PreProcessor)
+)
+
+// RegisterPreProcessor registers a pre-processor which handlers can reference by name in their
+// preProcessors setting. It is typically called from the init function of the registering package.
+func RegisterPreProcessor(name string, p PreProcessor) error {
+	preProcessorsLock.Lock()
+	defer preProcessorsLock.Unlock()
+	if _, exists := preProcessors[name]; exists {
+		return fmt.Errorf("pre-processor [%s] already registered", name)
+	}
+	preProcessors[name] = p
+	return nil
+}
+
+// getPreProcessors resolves a comma separated list of pre-processor names
+func getPreProcessors(names string) ([]PreProcessor, error) {
+	preProcessorsLock.RLock()
+	defer preProcessorsLock.RUnlock()
+	var result []PreProcessor
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, ok := preProcessors[name]
+		if !ok {
+			return nil, fmt.Errorf("pre-processor [%s] is not registered", name)
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package subscriber
+
+import (
+	"strings"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const defaultPriorityWorkers = 2
+
+// priorityLane dispatches messages carrying the configured priority property to a dedicated
+// pool of workers, so they are not queued behind normal messages. The lane is fed by the receive
+// loop of the handler's consumer, it only reorders messages already received: the broker still
+// dispatches priority messages in order with the others, behind the backlog of the subscription.
+type priorityLane struct {
+	handler  *Handler
+	property string
+	values   map[string]bool
+	workers  int
+	queue    chan pulsar.ConsumerMessage
+}
+
+func newPriorityLane(handler *Handler, property, values string, workers int) *priorityLane {
+	if workers <= 0 {
+		workers = defaultPriorityWorkers
+	}
+	lane := &priorityLane{handler: handler, property: property, values: make(map[string]bool), workers: workers}
+	for _, v := range strings.Split(values, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			lane.values[v] = true
+		}
+	}
+	return lane
+}
+
+// matches returns true if the message carries the priority property with one of the configured
+// values, or with any value when no values are configured
+func (l *priorityLane) matches(msg pulsar.ConsumerMessage) bool {
+	v, ok := msg.Properties()[l.property]
+	if !ok {
+		return false
+	}
+	if len(l.values) == 0 {
+		return v != ""
+	}
+	return l.values[v]
+}
+
+func (l *priorityLane) start(done chan bool) {
+	l.queue = make(chan pulsar.ConsumerMessage)
+	for i := 0; i < l.workers; i++ {
+		go func(queue chan pulsar.ConsumerMessage) {
+			for {
+				select {
+				case msg := <-queue:
+					l.handler.handleMessage(msg)
+				case <-done:
+					return
+				}
+			}
+		}(l.queue)
+	}
+	l.handler.logger.Infof("Started %d priority workers for property [%s]", l.workers, l.property)
+}
+
+// dispatch hands the message to a priority worker, it returns false if the handler was stopped meanwhile
+func (l *priorityLane) dispatch(msg pulsar.ConsumerMessage, done chan bool) bool {
+	select {
+	case l.queue <- msg:
+		return true
+	case <-done:
+		return false
+	}
+}
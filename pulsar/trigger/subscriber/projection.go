@@ -0,0 +1,62 @@
+package subscriber
+
+import (
+	"fmt"
+	"strings"
+
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+// projectedField is a field of the projection, the value at the path is output under the name
+type projectedField struct {
+	name string
+	path string
+}
+
+// projection replaces a JSON payload with a flat object of the configured fields, so that flows needing a handful of
+// fields of large documents neither keep nor map the whole document
+type projection []projectedField
+
+// newProjection parses a comma separated list of JSON paths, each optionally named, e.g.
+// "$.order.id,customer=$.order.customer.name". Unnamed fields are named after their path, e.g. "order.id".
+func newProjection(spec string) (projection, error) {
+	var p projection
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		field := projectedField{path: entry}
+		if i := strings.Index(entry, "="); i >= 0 {
+			field.name, field.path = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		if !strings.HasPrefix(field.path, "$") {
+			return nil, fmt.Errorf("invalid projected field [%s]: the path must start with $", entry)
+		}
+		if field.name == "" {
+			field.name = strings.TrimPrefix(strings.TrimPrefix(field.path, "$"), ".")
+		}
+		if field.name == "" {
+			return nil, fmt.Errorf("invalid projected field [%s]: a name is required for the root", entry)
+		}
+		p = append(p, field)
+	}
+	return p, nil
+}
+
+// project returns the projected fields of the payload, fields whose path does not match are left out. Payloads
+// which are not JSON documents are returned as is.
+func (p projection) project(payload interface{}) interface{} {
+	switch payload.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return payload
+	}
+	projected := make(map[string]interface{}, len(p))
+	for _, field := range p {
+		if value, ok := connection.LookupPath(payload, field.path); ok {
+			projected[field.name] = value
+		}
+	}
+	return projected
+}
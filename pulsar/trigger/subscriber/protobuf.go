@@ -0,0 +1,87 @@
+package subscriber
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoDecoder decodes protobuf payloads with the descriptor of their message type, so that no generated code is
+// needed
+type protoDecoder struct {
+	message protoreflect.MessageDescriptor
+	// topicSchemaType is the type of the schema of the topics the handler consumes from
+	topicSchemaType string
+}
+
+// nativeSchema is the definition of a ProtobufNative schema registered with the broker
+type nativeSchema struct {
+	FileDescriptorSet   string `json:"fileDescriptorSet"`
+	RootMessageTypeName string `json:"rootMessageTypeName"`
+}
+
+// newProtoDecoder creates the decoder of the message type from a base64 encoded FileDescriptorSet, such as written
+// by protoc --include_imports --descriptor_set_out
+func newProtoDecoder(descriptorSet, messageName, topicSchemaType string) (*protoDecoder, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(descriptorSet))
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor: %v", err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor: %v", err)
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("message type [%s] not found in the protobuf descriptor", messageName)
+	}
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("[%s] is not a message type", messageName)
+	}
+	return &protoDecoder{message: message, topicSchemaType: topicSchemaType}, nil
+}
+
+// fetchNativeDescriptor returns the descriptor set and the root message type of the ProtobufNative schema of the topic
+func fetchNativeDescriptor(connMgr connection.PulsarConnManager, topic string) (string, string, error) {
+	definition, err := fetchTopicSchema(connMgr, topic, "PROTOBUF_NATIVE")
+	if err != nil {
+		return "", "", err
+	}
+	native := &nativeSchema{}
+	if err = json.Unmarshal([]byte(definition), native); err != nil {
+		return "", "", fmt.Errorf("invalid ProtobufNative schema of topic [%s]: %v", topic, err)
+	}
+	return native.FileDescriptorSet, native.RootMessageTypeName, nil
+}
+
+// decode decodes the payload into its structured form as in the JSON mapping of protobuf, with fields named as in
+// the .proto definition, e.g. 64 bit integers are strings and enums are named
+func (d *protoDecoder) decode(payload []byte) (interface{}, error) {
+	msg := dynamicpb.NewMessage(d.message)
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, fmt.Errorf("invalid protobuf payload: %v", err)
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf payload: %v", err)
+	}
+	var record interface{}
+	if err = json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
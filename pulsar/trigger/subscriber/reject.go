@@ -0,0 +1,91 @@
+package subscriber
+
+import (
+	"context"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+const (
+	propertyRealTopic       = "REAL_TOPIC"
+	propertyOriginMessageID = "ORIGIN_MESSAGE_ID"
+	propertyRejectReason    = "REJECT_REASON"
+)
+
+// reject handles a message which can never be processed successfully. The message is published
+// to the dead letter topic along with the reason, when one is configured, and acknowledged.
+func (handler *Handler) reject(msg pulsar.ConsumerMessage, reason string) {
+	handler.logger.Warnf("Rejecting message [%s]: %s", msg.ID(), reason)
+	handler.sendAndAck(handler.dlqTopic, msg, reason)
+}
+
+// sendAndAck publishes the message to the topic, if one is given, and acknowledges it
+func (handler *Handler) sendAndAck(topic string, msg pulsar.ConsumerMessage, reason string) {
+	if topic == "" {
+		handler.ack(msg)
+		return
+	}
+	err := handler.sendToTopic(topic, msg, reason, nil)
+	if err != nil {
+		// leave it to the broker to redeliver the message
+		handler.logger.Errorf("Failed to publish message [%s] to topic [%s]: %v", msg.ID(), topic, err)
+		handler.nack(msg)
+		return
+	}
+	handler.ack(msg)
+}
+
+// sendToTopic publishes a copy of the message to the topic, stamp adds properties to the copy if not nil
+func (handler *Handler) sendToTopic(topic string, msg pulsar.ConsumerMessage, reason string, stamp func(map[string]string)) error {
+	producer, err := handler.getProducer(topic)
+	if err != nil {
+		return err
+	}
+	props := make(map[string]string, len(msg.Properties())+3)
+	for k, v := range msg.Properties() {
+		props[k] = v
+	}
+	props[propertyRealTopic] = msg.Topic()
+	props[propertyOriginMessageID] = formatMsgID(msg.ID())
+	if reason != "" {
+		props[propertyRejectReason] = reason
+	}
+	if stamp != nil {
+		stamp(props)
+	}
+	if handler.history != nil {
+		outcome := "routed"
+		if topic == handler.dlqTopic {
+			outcome = "dlq"
+		} else if props[connection.PropertySagaCompensating] == "true" {
+			outcome = "compensate"
+		}
+		handler.history.append(props, msg, outcome, reason)
+	}
+	_, err = producer.Send(context.Background(), &pulsar.ProducerMessage{
+		Payload:    msg.Payload(),
+		Key:        msg.Key(),
+		Properties: props,
+		EventTime:  msg.EventTime(),
+	})
+	return err
+}
+
+// getProducer returns the producer for the given topic, creating it on first use
+func (handler *Handler) getProducer(topic string) (pulsar.Producer, error) {
+	handler.producersLock.Lock()
+	defer handler.producersLock.Unlock()
+	if producer, ok := handler.producers[topic]; ok {
+		return producer, nil
+	}
+	producer, err := handler.connMgr.GetProducer(pulsar.ProducerOptions{Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+	if handler.producers == nil {
+		handler.producers = make(map[string]pulsar.Producer)
+	}
+	handler.producers[topic] = producer
+	return producer, nil
+}
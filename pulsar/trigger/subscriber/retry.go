@@ -0,0 +1,41 @@
+package subscriber
+
+import (
+	"strings"
+	"time"
+)
+
+// retryPolicy governs in-process retries of a failed flow execution before the message is negatively acknowledged
+type retryPolicy struct {
+	count    int
+	delay    time.Duration
+	patterns []string
+}
+
+func newRetryPolicy(count, delayMs int, retryOn string) retryPolicy {
+	policy := retryPolicy{count: count, delay: time.Duration(delayMs) * time.Millisecond}
+	for _, p := range strings.Split(retryOn, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			policy.patterns = append(policy.patterns, strings.ToLower(p))
+		}
+	}
+	return policy
+}
+
+// shouldRetry returns true if the given failed attempt is to be retried. When error patterns are configured
+// only errors whose message contains one of them are retried.
+func (p retryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt > p.count {
+		return false
+	}
+	if len(p.patterns) == 0 {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range p.patterns {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package subscriber
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	p := newRetryPolicy(3, 250, " Timeout, ,connection REFUSED ")
+	if p.count != 3 || p.delay != 250*time.Millisecond {
+		t.Errorf("newRetryPolicy() = %d retries after %v, want 3 after 250ms", p.count, p.delay)
+	}
+	if len(p.patterns) != 2 || p.patterns[0] != "timeout" || p.patterns[1] != "connection refused" {
+		t.Errorf("patterns = %q, want the trimmed lower case patterns", p.patterns)
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	timeout := errors.New("HTTP call failed: Timeout awaiting headers")
+	invalid := errors.New("invalid order")
+	tests := []struct {
+		name    string
+		count   int
+		retryOn string
+		attempt int
+		err     error
+		want    bool
+	}{
+		{"disabled", 0, "", 1, timeout, false},
+		{"any error within the count", 2, "", 2, invalid, true},
+		{"count exhausted", 2, "", 3, timeout, false},
+		{"matching pattern", 2, "timeout", 1, timeout, true},
+		{"pattern is case insensitive", 2, "TIMEOUT", 1, timeout, true},
+		{"one of the patterns", 2, "refused,awaiting headers", 1, timeout, true},
+		{"no matching pattern", 2, "timeout", 1, invalid, false},
+		{"matching pattern with count exhausted", 2, "timeout", 3, timeout, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRetryPolicy(tt.count, 0, tt.retryOn)
+			if got := p.shouldRetry(tt.attempt, tt.err); got != tt.want {
+				t.Errorf("shouldRetry(%d, %v) = %v, want %v", tt.attempt, tt.err, got, tt.want)
+			}
+		})
+	}
+}
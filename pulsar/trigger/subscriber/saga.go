@@ -0,0 +1,25 @@
+package subscriber
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+// compensate routes a saga message whose flow failed to the compensation topic of the saga and acknowledges it,
+// so that the compensating flow undoes the previous steps. It returns false if the message is not routed, i.e. it
+// is not part of a saga, the saga has no compensation topic or the message already is a compensation.
+func (handler *Handler) compensate(msg pulsar.ConsumerMessage, cause error) bool {
+	saga := connection.SagaFromProperties(msg.Properties())
+	if saga == nil || saga.CompensationTopic == "" || saga.Compensating {
+		return false
+	}
+	saga.Compensating = true
+	if err := handler.sendToTopic(saga.CompensationTopic, msg, cause.Error(), saga.Inject); err != nil {
+		handler.logger.Errorf("Failed to route message [%s] of saga [%s] to compensation topic [%s]: %v", msg.ID(), saga.ID, saga.CompensationTopic, err)
+		return false
+	}
+	handler.logger.Warnf("Step %d of saga [%s] failed, message [%s] routed to compensation topic [%s]: %v", saga.Step, saga.ID, msg.ID(), saga.CompensationTopic, cause)
+	compensations.WithLabelValues(handler.handler.Name()).Inc()
+	handler.ack(msg)
+	return true
+}
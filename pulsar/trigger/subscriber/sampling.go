@@ -0,0 +1,25 @@
+package subscriber
+
+import (
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// sampler selects the percentage of messages which trigger the flow
+type sampler struct {
+	rate  float64
+	byKey bool
+}
+
+// sampled returns true if the message is to be processed. Keyed sampling consistently selects the same
+// keys, so all messages of a selected key are processed.
+func (s *sampler) sampled(msg pulsar.Message) bool {
+	if s.byKey {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(msg.Key()))
+		return float64(h.Sum32()%10000) < s.rate*100
+	}
+	return rand.Float64()*100 < s.rate
+}
@@ -0,0 +1,105 @@
+package subscriber
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const scalerPath = "/scaler/"
+
+// scalerMetrics is returned by the scaler endpoint for the metrics-api scaler of KEDA, e.g. with
+// valueLocation "desiredReplicas" or "backlog"
+type scalerMetrics struct {
+	Handler         string `json:"handler"`
+	Backlog         int64  `json:"backlog"`
+	DesiredReplicas int    `json:"desiredReplicas"`
+	Replicas        int    `json:"replicas"`
+}
+
+// startScaler serves the scaling signals of the handlers on the scalerAddress, so that KEDA scales the app without
+// admin credentials of the brokers
+func (t *Trigger) startScaler() error {
+	var tlsConfig *tls.Config
+	if t.scalerCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.scalerCertFile, t.scalerKeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	listener, err := net.Listen("tcp", t.scalerAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(scalerPath, t.serveScaler)
+	mux.HandleFunc(externalScalerPath, t.serveExternalScaler)
+	t.scaler = &http.Server{Handler: mux, TLSConfig: tlsConfig, ReadHeaderTimeout: 10 * time.Second}
+	go func(server *http.Server) {
+		var err error
+		if server.TLSConfig != nil {
+			// HTTP/2, required by the gRPC external scaler, is negotiated over TLS
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			t.logger.Errorf("Scaler endpoint stopped: %v", err)
+		}
+	}(t.scaler)
+	t.logger.Infof("Serving scaling signals on [%s]", listener.Addr())
+	return nil
+}
+
+func (t *Trigger) stopScaler() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = t.scaler.Shutdown(ctx)
+	t.scaler = nil
+}
+
+// serveScaler returns the scaling signal of the handler named in the path, e.g. /scaler/orders
+func (t *Trigger) serveScaler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !t.validScalerToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		http.Error(w, "invalid scaler token", http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, scalerPath)
+	state, ok := t.scalingStateOf(name)
+	if !ok {
+		http.Error(w, "no handler with a scaling signal named ["+name+"]", http.StatusNotFound)
+		return
+	}
+	if state == nil {
+		http.Error(w, "scaling signal not computed yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(scalerMetrics{Handler: name, Backlog: state.Backlog, DesiredReplicas: state.DesiredReplicas, Replicas: state.Replicas})
+}
+
+func (t *Trigger) validScalerToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(t.scalerToken)) == 1
+}
+
+// scalingStateOf returns the scaling signal of the handler, nil until it was computed once. It returns false if no
+// handler of that name has a scaling signal.
+func (t *Trigger) scalingStateOf(name string) (*scalingState, bool) {
+	for _, handler := range t.handlers {
+		if handler.scaling == nil || handler.isCanary || handler.handler.Name() != name {
+			continue
+		}
+		return handler.scaling.state(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,200 @@
+package subscriber
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+const defaultScalingInterval = 30 * time.Second
+
+// scalingSignal computes the number of replicas of the app needed to drain the backlog of the subscription within
+// targetLag while keeping up with the incoming rate, for autoscalers like KEDA or the HPA
+type scalingSignal struct {
+	targetLag   time.Duration
+	minReplicas int
+	maxReplicas int
+
+	lock     sync.Mutex
+	busy     time.Duration
+	handled  int64
+	desired  int
+	replicas int
+	backlog  int64
+}
+
+// scalingState is the last computed signal reported in the diagnostics dump
+type scalingState struct {
+	DesiredReplicas int   `json:"desiredReplicas"`
+	Replicas        int   `json:"replicas"`
+	Backlog         int64 `json:"backlog"`
+}
+
+// topicStats holds the figures of the topic stats of the admin API the signal is computed from
+type topicStats struct {
+	MsgRateIn     float64 `json:"msgRateIn"`
+	Subscriptions map[string]struct {
+		MsgBacklog int64   `json:"msgBacklog"`
+		MsgRateOut float64 `json:"msgRateOut"`
+		Consumers  []struct {
+			ConsumerName string `json:"consumerName"`
+		} `json:"consumers"`
+	} `json:"subscriptions"`
+}
+
+func newScalingSignal(targetLag, minReplicas, maxReplicas int) *scalingSignal {
+	if targetLag <= 0 {
+		return nil
+	}
+	if minReplicas <= 0 {
+		minReplicas = 1
+	}
+	return &scalingSignal{targetLag: time.Duration(targetLag) * time.Second, minReplicas: minReplicas, maxReplicas: maxReplicas}
+}
+
+func (s *scalingSignal) record(elapsed time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.busy += elapsed
+	s.handled++
+}
+
+func (s *scalingSignal) state() *scalingState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.desired == 0 {
+		// not computed yet
+		return nil
+	}
+	return &scalingState{DesiredReplicas: s.desired, Replicas: s.replicas, Backlog: s.backlog}
+}
+
+func (t *Trigger) hasScalingSignal() bool {
+	for _, handler := range t.handlers {
+		if handler.scaling != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// reportScaling periodically computes the scaling signal of the handlers which have a scalingTargetLag
+func (t *Trigger) reportScaling(interval time.Duration, done chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, handler := range t.handlers {
+				if handler.scaling == nil || handler.isCanary {
+					continue
+				}
+				if err := handler.updateScaling(); err != nil {
+					handler.logger.Warnf("Unable to compute the scaling signal of handler [%s]: %v", handler.handler.Name(), err)
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (handler *Handler) updateScaling() error {
+	stats, err := handler.subscriptionStats()
+	if err != nil {
+		return err
+	}
+	sub, ok := stats.Subscriptions[handler.consumerOpts.SubscriptionName]
+	if !ok {
+		return fmt.Errorf("subscription [%s] not found", handler.consumerOpts.SubscriptionName)
+	}
+	consumers := make(map[string]bool)
+	for _, c := range sub.Consumers {
+		consumers[c.ConsumerName] = true
+	}
+	replicas := len(consumers)
+
+	s := handler.scaling
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// the rate one replica handles when busy: the concurrency of the handler over the average processing time,
+	// or the rate the subscription was drained at per replica until this one handled messages
+	var capacity float64
+	if s.handled > 0 && s.busy > 0 {
+		capacity = float64(handler.concurrency()) / (s.busy.Seconds() / float64(s.handled))
+	} else if replicas > 0 {
+		capacity = sub.MsgRateOut / float64(replicas)
+	}
+	s.busy, s.handled = 0, 0
+
+	required := stats.MsgRateIn + float64(sub.MsgBacklog)/s.targetLag.Seconds()
+	desired := replicas
+	if capacity > 0 {
+		desired = int(math.Ceil(required / capacity))
+	}
+	if desired < s.minReplicas {
+		desired = s.minReplicas
+	}
+	if s.maxReplicas > 0 && desired > s.maxReplicas {
+		desired = s.maxReplicas
+	}
+	s.desired, s.replicas, s.backlog = desired, replicas, sub.MsgBacklog
+	name := handler.handler.Name()
+	desiredReplicas.WithLabelValues(name).Set(float64(desired))
+	subscriptionBacklog.WithLabelValues(name).Set(float64(sub.MsgBacklog))
+	return nil
+}
+
+// subscriptionStats returns the stats of the topic of the handler, aggregated over its partitions
+func (handler *Handler) subscriptionStats() (*topicStats, error) {
+	admin, err := handler.connMgr.AdminClient()
+	if err != nil {
+		return nil, err
+	}
+	if handler.consumerOpts.Topic == "" {
+		return nil, fmt.Errorf("scaling signals require a single topic")
+	}
+	topic, err := connection.NormalizeTopic(handler.consumerOpts.Topic, true)
+	if err != nil {
+		return nil, err
+	}
+	topicPath := "/admin/v2/" + strings.Replace(topic, "://", "/", 1)
+	var metadata struct {
+		Partitions int `json:"partitions"`
+	}
+	if err = admin.Get(topicPath+"/partitions", &metadata); err != nil {
+		return nil, err
+	}
+	stats := &topicStats{}
+	if metadata.Partitions > 0 {
+		err = admin.Get(topicPath+"/partitioned-stats", stats)
+	} else {
+		err = admin.Get(topicPath+"/stats", stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// concurrency is the number of messages the handler processes at once
+func (handler *Handler) concurrency() int {
+	switch {
+	case handler.asyncMode:
+		return handler.maxMsgCount
+	case handler.partitions != nil:
+		// a worker per partition
+		handler.partitions.lock.Lock()
+		defer handler.partitions.lock.Unlock()
+		if n := len(handler.partitions.queues); n > 0 {
+			return n
+		}
+		return 1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,290 @@
+package subscriber
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/project-flogo/core/data/coerce"
+)
+
+const (
+	SchemaMismatchNack        = "Nack"
+	SchemaMismatchDLQ         = "DLQ"
+	SchemaMismatchPassThrough = "PassThrough"
+)
+
+// jsonSchema is the subset of JSON Schema used to validate and coerce payloads: type, properties,
+// required, items and enum
+type jsonSchema struct {
+	Type       interface{}            `json:"type"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Required   []string               `json:"required"`
+	Items      *jsonSchema            `json:"items"`
+	Enum       []interface{}          `json:"enum"`
+}
+
+func parseJSONSchema(schema string) (*jsonSchema, error) {
+	s := &jsonSchema{}
+	if err := json.Unmarshal([]byte(schema), s); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %v", err)
+	}
+	return s, nil
+}
+
+func (s *jsonSchema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, v := range t {
+			if str, ok := v.(string); ok {
+				types = append(types, str)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// coerce validates the value against the schema, converting values to the declared types where possible
+// (e.g. "42" to 42 for an integer). The path identifies the value in error messages.
+func (s *jsonSchema) coerce(value interface{}, path string) (interface{}, error) {
+	types := s.types()
+	if len(types) == 0 {
+		return value, s.checkEnum(value, path)
+	}
+	var lastErr error
+	for _, t := range types {
+		coerced, err := s.coerceTo(t, value, path)
+		if err == nil {
+			return coerced, s.checkEnum(coerced, path)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (s *jsonSchema) coerceTo(t string, value interface{}, path string) (interface{}, error) {
+	var coerced interface{}
+	var err error
+	switch t {
+	case "null":
+		if value != nil {
+			err = fmt.Errorf("%s: expected null", path)
+		}
+	case "string":
+		if value == nil {
+			return nil, fmt.Errorf("%s: expected string", path)
+		}
+		coerced, err = coerce.ToString(value)
+	case "number":
+		coerced, err = coerce.ToFloat64(value)
+	case "integer":
+		var f float64
+		if f, err = coerce.ToFloat64(value); err == nil {
+			if f != float64(int64(f)) {
+				err = fmt.Errorf("%s: expected integer, got %v", path, value)
+			}
+			coerced = int64(f)
+		}
+	case "boolean":
+		coerced, err = coerce.ToBool(value)
+	case "object":
+		var obj map[string]interface{}
+		if obj, err = coerce.ToObject(value); err == nil {
+			if obj == nil {
+				return nil, fmt.Errorf("%s: expected object", path)
+			}
+			coerced, err = s.coerceObject(obj, path)
+		}
+	case "array":
+		var arr []interface{}
+		if arr, err = coerce.ToArray(value); err == nil {
+			if arr == nil {
+				return nil, fmt.Errorf("%s: expected array", path)
+			}
+			coerced, err = s.coerceArray(arr, path)
+		}
+	default:
+		err = fmt.Errorf("%s: unsupported schema type [%s]", path, t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: expected %s: %v", path, t, err)
+	}
+	return coerced, nil
+}
+
+func (s *jsonSchema) coerceObject(obj map[string]interface{}, path string) (map[string]interface{}, error) {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return nil, fmt.Errorf("%s.%s: required property missing", path, name)
+		}
+	}
+	result := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if prop, ok := s.Properties[k]; ok {
+			coerced, err := prop.coerce(v, path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = coerced
+		} else {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+func (s *jsonSchema) coerceArray(arr []interface{}, path string) ([]interface{}, error) {
+	if s.Items == nil {
+		return arr, nil
+	}
+	result := make([]interface{}, len(arr))
+	for i, v := range arr {
+		coerced, err := s.Items.coerce(v, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = coerced
+	}
+	return result, nil
+}
+
+func (s *jsonSchema) checkEnum(value interface{}, path string) error {
+	if len(s.Enum) == 0 {
+		return nil
+	}
+	for _, e := range s.Enum {
+		if reflect.DeepEqual(e, value) || fmt.Sprint(e) == fmt.Sprint(value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: value %v is not one of %v", path, value, s.Enum)
+}
+
+// schemaMismatched handles a payload which does not match the named schema as configured by schemaMismatch. It
+// returns true if the message is processed nonetheless.
+func (handler *Handler) schemaMismatched(msg pulsar.ConsumerMessage, schema string, err error) bool {
+	switch handler.schemaMismatch {
+	case SchemaMismatchPassThrough:
+		handler.logger.Warnf("Payload of message [%s] does not match the %s: %v", msg.ID(), schema, err)
+		return true
+	case SchemaMismatchDLQ:
+		handler.reject(msg, fmt.Sprintf("payload does not match the %s: %v", schema, err))
+	default:
+		handler.logger.Errorf("Payload of message [%s] does not match the %s: %v", msg.ID(), schema, err)
+		handler.nack(msg)
+	}
+	return false
+}
+
+// recordSchema converts the Avro record definition of a Pulsar JSON schema to the JSON schema payloads are
+// validated with. Fields are required unless they are nullable or have a default.
+func recordSchema(definition string) (*jsonSchema, error) {
+	var def interface{}
+	if err := json.Unmarshal([]byte(definition), &def); err != nil {
+		return nil, fmt.Errorf("invalid schema definition: %v", err)
+	}
+	s, err := fromAvroType(def)
+	if err != nil {
+		return nil, err
+	}
+	if s.Type != "object" {
+		return nil, fmt.Errorf("invalid schema definition: expected a record")
+	}
+	return s, nil
+}
+
+func fromAvroType(t interface{}) (*jsonSchema, error) {
+	switch v := t.(type) {
+	case string:
+		switch v {
+		case "null", "boolean", "string":
+			return &jsonSchema{Type: v}, nil
+		case "int", "long":
+			return &jsonSchema{Type: "integer"}, nil
+		case "float", "double":
+			return &jsonSchema{Type: "number"}, nil
+		case "bytes":
+			return &jsonSchema{Type: "string"}, nil
+		}
+		// a reference to a named type, which is not validated
+		return &jsonSchema{}, nil
+	case []interface{}:
+		// a union, e.g. ["null", "string"]
+		s := &jsonSchema{}
+		var types []interface{}
+		for _, member := range v {
+			m, err := fromAvroType(member)
+			if err != nil {
+				return nil, err
+			}
+			memberTypes := m.types()
+			if len(memberTypes) == 0 {
+				return &jsonSchema{}, nil
+			}
+			for _, mt := range memberTypes {
+				types = append(types, mt)
+			}
+			if m.Properties != nil {
+				s.Properties, s.Required = m.Properties, m.Required
+			}
+			if m.Items != nil {
+				s.Items = m.Items
+			}
+		}
+		s.Type = types
+		return s, nil
+	case map[string]interface{}:
+		switch v["type"] {
+		case "record":
+			s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+			fields, _ := v["fields"].([]interface{})
+			for _, f := range fields {
+				field, _ := f.(map[string]interface{})
+				name, _ := field["name"].(string)
+				if name == "" {
+					return nil, fmt.Errorf("invalid schema definition: field without name")
+				}
+				fs, err := fromAvroType(field["type"])
+				if err != nil {
+					return nil, err
+				}
+				s.Properties[name] = fs
+				if _, hasDefault := field["default"]; !hasDefault && !fs.nullable() {
+					s.Required = append(s.Required, name)
+				}
+			}
+			return s, nil
+		case "array":
+			items, err := fromAvroType(v["items"])
+			if err != nil {
+				return nil, err
+			}
+			return &jsonSchema{Type: "array", Items: items}, nil
+		case "map":
+			return &jsonSchema{Type: "object"}, nil
+		case "enum":
+			symbols, _ := v["symbols"].([]interface{})
+			return &jsonSchema{Type: "string", Enum: symbols}, nil
+		case "fixed":
+			return &jsonSchema{Type: "string"}, nil
+		}
+		// a primitive with attributes, e.g. {"type": "long", "logicalType": "timestamp-millis"}
+		return fromAvroType(v["type"])
+	}
+	return nil, fmt.Errorf("invalid schema definition: unexpected type %v", t)
+}
+
+func (s *jsonSchema) nullable() bool {
+	types := s.types()
+	for _, t := range types {
+		if t == "null" {
+			return true
+		}
+	}
+	return len(types) == 0
+}
@@ -0,0 +1,85 @@
+package subscriber
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// lastMessageID is the position of the last message of a topic as returned by the admin API
+type lastMessageID struct {
+	LedgerID int64 `json:"ledgerId"`
+	EntryID  int64 `json:"entryId"`
+}
+
+// seekBeforeLatest moves the subscription to the last entries of the topic, so that a flow can be debugged against
+// the last few messages without consuming the backlog. The client neither exposes the last message id nor creates
+// message ids, the position is fetched with the admin API and the id is serialized as the brokers do.
+func (handler *Handler) seekBeforeLatest(count int) error {
+	admin, err := handler.connMgr.AdminClient()
+	if err != nil {
+		return err
+	}
+	topic, err := connection.NormalizeTopic(handler.consumerOpts.Topic, true)
+	if err != nil {
+		return err
+	}
+	last := &lastMessageID{}
+	if err = admin.Get("/admin/v2/"+strings.Replace(topic, "://", "/", 1)+"/lastMessageId", last); err != nil {
+		return fmt.Errorf("unable to get the last message id of topic [%s]: %v", topic, err)
+	}
+	if last.EntryID < 0 {
+		handler.logger.Infof("Topic [%s] has no messages, consuming from the latest position", topic)
+		return nil
+	}
+	entry := last.EntryID - int64(count) + 1
+	if entry < 0 {
+		// the entries of previous ledgers cannot be counted without reading them
+		handler.logger.Warnf("The current ledger of topic [%s] has %d entries only, consuming from its first entry", topic, last.EntryID+1)
+		entry = 0
+	}
+	id, err := pulsar.DeserializeMessageID(serializeMessageID(last.LedgerID, entry))
+	if err != nil {
+		return err
+	}
+	if err = handler.withConsumer(func(consumer pulsar.Consumer) error { return consumer.Seek(id) }); err != nil {
+		return fmt.Errorf("unable to seek topic [%s] to %v: %w", topic, id, err)
+	}
+	handler.logger.Infof("Subscription [%s] moved %d entries before the latest position of topic [%s]", handler.consumerOpts.SubscriptionName, last.EntryID-entry+1, topic)
+	return nil
+}
+
+// serializeMessageID encodes the MessageIdData of an entry of a non-partitioned topic
+func serializeMessageID(ledgerID, entryID int64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(ledgerID))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(entryID))
+	// the consumer of a non-partitioned topic expects partition 0
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, 0)
+	return b
+}
+
+// startTime returns the publish time to start consuming from, an RFC3339 time or a duration relative to now, e.g.
+// "-2h" to replay the last 2 hours
+func startTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "-") {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time [%s]: %v", s, err)
+		}
+		return connection.Now().Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time [%s], expected RFC3339 or a negative duration like -2h", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,36 @@
+package subscriber
+
+import (
+	"testing"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+func TestSerializeMessageID(t *testing.T) {
+	tests := []struct {
+		name              string
+		ledgerID, entryID int64
+	}{
+		{"first entry", 0, 0},
+		{"small ids", 12, 34},
+		{"multi-byte varints", 1 << 40, 300},
+		{"max ids", 1<<63 - 1, 1<<63 - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := pulsar.DeserializeMessageID(serializeMessageID(tt.ledgerID, tt.entryID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.LedgerID() != tt.ledgerID || id.EntryID() != tt.entryID {
+				t.Errorf("deserialized %d:%d, want %d:%d", id.LedgerID(), id.EntryID(), tt.ledgerID, tt.entryID)
+			}
+			if id.PartitionIdx() != 0 {
+				t.Errorf("partition = %d, want 0 as expected by the consumer of a non-partitioned topic", id.PartitionIdx())
+			}
+			if id.BatchIdx() != -1 {
+				t.Errorf("batch index = %d, want -1 for a whole entry", id.BatchIdx())
+			}
+		})
+	}
+}
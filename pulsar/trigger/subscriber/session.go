@@ -0,0 +1,12 @@
+package subscriber
+
+import (
+	"time"
+)
+
+// newSessionWindows groups messages sharing a key into session windows. A window closes once no message
+// for its key arrived within the gap, or when it holds the maximum number of messages. The flow is then
+// invoked once with all messages of the window, which are acknowledged together.
+func newSessionWindows(handler *Handler, gap time.Duration, maxMessages int) *windows {
+	return newWindows(handler, "Session window", gap, true, maxMessages)
+}
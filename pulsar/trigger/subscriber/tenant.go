@@ -0,0 +1,19 @@
+package subscriber
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// tenantFilter restricts a handler consuming a topic shared by several tenants to the messages of its tenant,
+// the messages of other tenants are acknowledged without triggering the flow
+type tenantFilter struct {
+	property string
+	value    string
+}
+
+// matches returns true if the tenant property of the message has the expected value, messages without the
+// property belong to no tenant
+func (f *tenantFilter) matches(msg pulsar.Message) bool {
+	value, ok := msg.Properties()[f.property]
+	return ok && value == f.value
+}
@@ -2,10 +2,16 @@ package subscriber
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apache/pulsar-client-go/pulsar"
@@ -20,29 +26,108 @@ import (
 )
 
 const (
-	ProcessingModeAsync = "Async"
+	ProcessingModeAsync       = "Async"
+	ProcessingModePartitioned = "Partitioned"
 )
 
-var triggerMd = trigger.NewMetadata(&Settings{}, &HandlerSettings{}, &Output{})
+const shadowSubscriptionSuffix = "-shadow"
+
+var triggerMd = trigger.NewMetadata(&Settings{}, &HandlerSettings{}, &Output{}, &Reply{})
+
+// errNotSubscribed is returned by operations on the consumer while the handler has none
+var errNotSubscribed = errors.New("the handler is not subscribed")
 
 func init() {
 	_ = trigger.Register(&Trigger{}, &Factory{})
 }
 
 type Trigger struct {
-	connMgr   connection.PulsarConnManager
-	pulsarCnn cnn.Manager
-	handlers  []*Handler
-	logger    log.Logger
+	connMgr            connection.PulsarConnManager
+	pulsarCnn          cnn.Manager
+	handlers           []*Handler
+	logger             log.Logger
+	loadReportInterval time.Duration
+	loadReportDone     chan bool
+	scalingInterval    time.Duration
+	scalingDone        chan bool
+	scalerAddress      string
+	scalerToken        string
+	scalerCertFile     string
+	scalerKeyFile      string
+	scaler             *http.Server
+	controlTopic       string
+	controlDone        chan bool
+	managementAddress  string
+	managementToken    string
+	management         *http.Server
+	leaderTopic        string
+	leaderInterval     time.Duration
 }
 type Handler struct {
 	handler                      trigger.Handler
+	logger                       log.Logger
 	consumer                     pulsar.Consumer
 	done                         chan bool
 	asyncMode                    bool
 	maxMsgCount, currentMsgCount int
 	wg                           sync.WaitGroup
 	consumerOpts                 pulsar.ConsumerOptions
+	inFlight                     sync.WaitGroup
+	stateLock                    sync.Mutex
+	running                      bool
+	priority                     *priorityLane
+	partitions                   *partitionWorkers
+	connMgr                      connection.PulsarConnManager
+	dlqTopic                     string
+	maxPayloadSize               int
+	producers                    map[string]pulsar.Producer
+	producersLock                sync.Mutex
+	preProcessors                []PreProcessor
+	stats                        handlerStats
+	watermark                    *watermark
+	sessions                     *windows
+	batches                      *windows
+	retry                        retryPolicy
+	circuitBreaker               string
+	sampler                      *sampler
+	shadowMode                   bool
+	canary                       *canaryRoute
+	isCanary                     bool
+	singleton                    bool
+	sagaCompensate               bool
+	election                     *connection.LeaderElection
+	electionDone                 chan bool
+	watchdog                     *watchdog
+	inference                    *schemaInference
+	outputSchema                 *jsonSchema
+	schemaMismatch               string
+	decompress                   bool
+	charset                      string
+	expiry                       *messageExpiry
+	pipeline                     string
+	nextTopic                    string
+	retryEnable                  bool
+	fencing                      bool
+	generation                   generation
+	processing                   int64
+	lastMsgID                    atomic.Value
+	pause                        pauseGate
+	scaling                      *scalingSignal
+	startBeforeLatest            int
+	startFrom                    pulsar.MessageID
+	startFromTime                string
+	startSeek                    sync.Once
+	consumerLock                 sync.Mutex // guards consumer against Close while the management API moves the subscription
+	tenant                       *tenantFilter
+	history                      *processingHistory
+	verifyChecksum               bool
+	dedup                        *connection.Deduplicator
+	avro                         *pulsar.AvroSchema
+	recordSchema                 *jsonSchema
+	protobuf                     *protoDecoder
+	autoSchema                   *autoSchema
+	inFlightBytes                *connection.MemoryLimiter
+	projection                   projection
 }
 
 type Factory struct {
@@ -59,7 +144,31 @@ func (*Factory) New(config *trigger.Config) (trigger.Trigger, error) {
 		return nil, err
 	}
 	connMgr := pulsarConn.GetConnection().(connection.PulsarConnManager)
-	return &Trigger{connMgr: connMgr, pulsarCnn: pulsarConn}, nil
+	if s.ControlTopic != "" {
+		if s.ControlTopic, err = connMgr.NormalizeTopic(s.ControlTopic); err != nil {
+			return nil, fmt.Errorf("controlTopic: %v", err)
+		}
+	}
+	if s.LeaderTopic != "" {
+		if s.LeaderTopic, err = connMgr.NormalizeTopic(s.LeaderTopic); err != nil {
+			return nil, fmt.Errorf("leaderTopic: %v", err)
+		}
+	}
+	if s.ScalerAddress != "" && s.ScalerToken == "" {
+		return nil, fmt.Errorf("scalerAddress requires a scalerToken")
+	}
+	if (s.ScalerCertFile == "") != (s.ScalerKeyFile == "") {
+		return nil, fmt.Errorf("scalerCertFile and scalerKeyFile must be set together")
+	}
+	if s.ManagementAddress != "" && s.ManagementToken == "" {
+		return nil, fmt.Errorf("managementAddress requires a managementToken")
+	}
+	if validateMode() {
+		validation.lock.Lock()
+		validation.triggers++
+		validation.lock.Unlock()
+	}
+	return &Trigger{connMgr: connMgr, pulsarCnn: pulsarConn, loadReportInterval: time.Duration(s.LoadReportInterval) * time.Second, scalingInterval: time.Duration(s.ScalingInterval) * time.Second, scalerAddress: s.ScalerAddress, scalerToken: s.ScalerToken, scalerCertFile: s.ScalerCertFile, scalerKeyFile: s.ScalerKeyFile, controlTopic: s.ControlTopic, managementAddress: s.ManagementAddress, managementToken: s.ManagementToken, leaderTopic: s.LeaderTopic, leaderInterval: time.Duration(s.LeaderInterval) * time.Second}, nil
 }
 
 func (f *Factory) Metadata() *trigger.Metadata {
@@ -72,15 +181,69 @@ func (t *Trigger) Metadata() *trigger.Metadata {
 }
 
 func (t *Trigger) Initialize(ctx trigger.InitContext) error {
-	t.logger = ctx.Logger()
-	// Init handlers
-	for _, handler := range ctx.GetHandlers() {
-
-		s := &HandlerSettings{}
-		err := metadata.MapToStruct(handler.Settings(), s, true)
+	t.logger = t.connMgr.Labels.Logger(ctx.Logger())
+	var canaries []canaryTarget
+	handlers := ctx.GetHandlers()
+	settings := make([]*HandlerSettings, len(handlers))
+	for i, handler := range handlers {
+		settings[i] = &HandlerSettings{}
+		err := metadata.MapToStruct(handler.Settings(), settings[i], true)
 		if err != nil {
 			return err
 		}
+	}
+	if err := applyPipelines(handlers, settings); err != nil {
+		return err
+	}
+	// Init handlers
+	for i, handler := range handlers {
+
+		s := settings[i]
+		var err error
+		if s.Topic == "" && s.TopicsPattern == "" {
+			return fmt.Errorf("handler [%s]: topic or topicsPattern is required", handler.Name())
+		}
+		if s.TopicsPattern != "" {
+			if s.Topic != "" {
+				return fmt.Errorf("handler [%s]: topic and topicsPattern are mutually exclusive", handler.Name())
+			}
+			if s.ScalingTargetLag > 0 || s.AssertPolicies != "" || s.StartBeforeLatest > 0 || s.StartFromMessageID != "" {
+				return fmt.Errorf("handler [%s]: scalingTargetLag, assertPolicies, startBeforeLatest and startFromMessageId require a single topic", handler.Name())
+			}
+			if s.TopicsPattern, err = connection.NormalizeTopicsPattern(s.TopicsPattern); err != nil {
+				return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+			}
+		} else if s.Topic, err = t.connMgr.NormalizeTopic(s.Topic); err != nil {
+			return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+		}
+		if s.DLQTopic != "" {
+			if s.DLQTopic, err = t.connMgr.NormalizeTopic(s.DLQTopic); err != nil {
+				return fmt.Errorf("handler [%s]: dlqTopic: %v", handler.Name(), err)
+			}
+		} else if s.MaxPayloadSize > 0 {
+			// oversized messages would be acknowledged and lost otherwise
+			return fmt.Errorf("handler [%s]: maxPayloadSize requires a dlqTopic", handler.Name())
+		}
+		if s.NextTopic != "" {
+			if s.NextTopic, err = t.connMgr.NormalizeTopic(s.NextTopic); err != nil {
+				return fmt.Errorf("handler [%s]: nextTopic: %v", handler.Name(), err)
+			}
+		}
+		if s.Topic != "" {
+			if err = t.connMgr.CheckTopicPolicies(s.Topic, s.AssertPolicies, s.PolicyViolation); err != nil {
+				return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+			}
+		}
+		if s.ScalingTargetLag > 0 {
+			if _, err = t.connMgr.AdminClient(); err != nil {
+				return fmt.Errorf("handler [%s]: scalingTargetLag requires the admin API: %v", handler.Name(), err)
+			}
+		}
+		if s.StartBeforeLatest > 0 {
+			if _, err = t.connMgr.AdminClient(); err != nil {
+				return fmt.Errorf("handler [%s]: startBeforeLatest requires the admin API: %v", handler.Name(), err)
+			}
+		}
 		var hostName string
 		hostName, err = os.Hostname()
 		if err != nil {
@@ -88,14 +251,37 @@ func (t *Trigger) Initialize(ctx trigger.InitContext) error {
 		}
 		consumeroptions := pulsar.ConsumerOptions{
 			Topic:            s.Topic,
+			TopicsPattern:    s.TopicsPattern,
 			SubscriptionName: s.Subscription,
 			Name:             fmt.Sprintf("%s-%s-%s-%s", engine.GetAppName(), engine.GetAppVersion(), handler.Name(), hostName),
 		}
 
+		if v, ok := handler.Settings()["receiverQueueSize"]; ok && v != nil {
+			// unset keeps the default of the client, 1000 messages
+			consumeroptions.ReceiverQueueSize = s.ReceiverQueueSize
+			if s.ReceiverQueueSize <= 0 {
+				// the client has no zero queue consumer, with a queue of 1 a single message is fetched ahead
+				consumeroptions.ReceiverQueueSize = 1
+			}
+		}
+
+		if s.AutoDiscoveryPeriod > 0 {
+			// the client polls for new topics matching the pattern every minute by default
+			consumeroptions.AutoDiscoveryPeriod = time.Duration(s.AutoDiscoveryPeriod) * time.Second
+		}
+
 		if s.NackRedeliveryDelay != 0 {
 			consumeroptions.NackRedeliveryDelay = time.Duration(s.NackRedeliveryDelay) * time.Second
 		}
 
+		if s.DecryptionKey != "" {
+			if consumeroptions.Decryption, err = decryptionInfo(s.DecryptionKey, s.CryptoFailureAction); err != nil {
+				return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+			}
+		} else if s.CryptoFailureAction != "" && s.CryptoFailureAction != CryptoFailureFail {
+			return fmt.Errorf("handler [%s]: consumerCryptoFailureAction requires a decryptionKey", handler.Name())
+		}
+
 		switch s.SubscriptionType {
 		case "Exclusive":
 			consumeroptions.Type = pulsar.Exclusive
@@ -108,6 +294,18 @@ func (t *Trigger) Initialize(ctx trigger.InitContext) error {
 		default:
 			consumeroptions.Type = pulsar.Exclusive
 		}
+		if s.ReadCompacted {
+			// the brokers only serve the compacted view to the single active consumer of a persistent topic
+			if consumeroptions.Type == pulsar.Shared || consumeroptions.Type == pulsar.KeyShared {
+				return fmt.Errorf("handler [%s]: readCompacted requires an Exclusive or Failover subscription", handler.Name())
+			}
+			if strings.HasPrefix(s.Topic, "non-persistent://") {
+				return fmt.Errorf("handler [%s]: readCompacted requires a persistent topic", handler.Name())
+			}
+			consumeroptions.ReadCompacted = true
+		}
+		// the brokers replicate the cursor only if replicated subscriptions are enabled for the geo-replicated topic
+		consumeroptions.ReplicateSubscriptionState = s.ReplicateSubscription
 		if s.DLQTopic != "" {
 			policy := pulsar.DLQPolicy{
 				MaxDeliveries:   uint32(s.DLQMaxDeliveries),
@@ -115,23 +313,231 @@ func (t *Trigger) Initialize(ctx trigger.InitContext) error {
 			}
 			consumeroptions.DLQ = &policy
 		}
+		// the client subscribes to the retry topic, "<subscriptionName>-RETRY" of the namespace if not set, as well,
+		// messages reconsumed maxRedeliveries times go to the DLQ topic, "<subscriptionName>-DLQ" if not set
+		consumeroptions.RetryEnable = s.RetryEnable
+		consumeroptions.AckWithResponse = s.AckWithResponse
+		if s.RetryEnable && (s.RetryLetterTopic != "" || s.MaxRedeliveries > 0) {
+			if consumeroptions.DLQ == nil {
+				// the client defaults the DLQ topic
+				consumeroptions.DLQ = &pulsar.DLQPolicy{MaxDeliveries: pulsar.MaxReconsumeTimes}
+			}
+			consumeroptions.DLQ.RetryLetterTopic = s.RetryLetterTopic
+			if s.MaxRedeliveries > 0 {
+				consumeroptions.DLQ.MaxDeliveries = uint32(s.MaxRedeliveries)
+			}
+		}
 		if s.InitialPosition == "Latest" {
 			consumeroptions.SubscriptionInitialPosition = pulsar.SubscriptionPositionLatest
 		} else {
 			consumeroptions.SubscriptionInitialPosition = pulsar.SubscriptionPositionEarliest
 		}
 
+		if s.ShadowMode {
+			// never touch the real subscription, consume new messages on a separate one
+			consumeroptions.SubscriptionName = s.Subscription + shadowSubscriptionSuffix
+			consumeroptions.SubscriptionInitialPosition = pulsar.SubscriptionPositionLatest
+			consumeroptions.DLQ = nil
+			consumeroptions.RetryEnable = false
+			s.DLQTopic = ""
+			handler.Logger().Infof("Shadow mode enabled, consuming on subscription [%s]", consumeroptions.SubscriptionName)
+		}
+
 		consumeroptions.MessageChannel = make(chan pulsar.ConsumerMessage)
 		var consumer pulsar.Consumer
 
-		tHandler := &Handler{handler: handler, consumer: consumer, done: make(chan bool), consumerOpts: consumeroptions}
+		tHandler := &Handler{handler: handler, consumer: consumer, consumerOpts: consumeroptions}
+		tHandler.logger = t.connMgr.Labels.Logger(handler.Logger())
+		tHandler.consumerOpts.Interceptors = consumerInterceptors(tHandler.logger)
+		t.connMgr.Labels.RegisterMetric("pulsar_trigger_labels", "handler", handler.Name())
 		tHandler.asyncMode = s.ProcessingMode == ProcessingModeAsync
+		tHandler.inFlightBytes = connection.NewMemoryLimiter(s.MaxInFlightBytes)
+		if s.ProcessingMode == ProcessingModePartitioned {
+			if consumeroptions.Type != pulsar.Exclusive && consumeroptions.Type != pulsar.Failover {
+				return fmt.Errorf("handler [%s]: processingMode %s requires an Exclusive or Failover subscription", handler.Name(), ProcessingModePartitioned)
+			}
+			tHandler.partitions = newPartitionWorkers(tHandler)
+		}
 		tHandler.maxMsgCount = getMaxMessageCount()
 		tHandler.wg = sync.WaitGroup{}
+		tHandler.dlqTopic = s.DLQTopic
+		tHandler.maxPayloadSize = s.MaxPayloadSize
+		tHandler.preProcessors, err = getPreProcessors(s.PreProcessors)
+		if err != nil {
+			return err
+		}
+		tHandler.circuitBreaker = s.CircuitBreaker
+		tHandler.decompress = s.Decompress == DecompressAuto
+		if tHandler.charset, err = validateCharset(s.Charset); err != nil {
+			return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+		}
+		tHandler.shadowMode = s.ShadowMode
+		tHandler.pipeline = s.Pipeline
+		tHandler.fencing = s.GenerationFencing
+		tHandler.nextTopic = s.NextTopic
+		tHandler.retryEnable = consumeroptions.RetryEnable
+		if s.TenantProperty != "" {
+			if s.TenantValue == "" {
+				return fmt.Errorf("handler [%s]: tenantValue is required when tenantProperty is set", handler.Name())
+			}
+			tHandler.tenant = &tenantFilter{property: s.TenantProperty, value: s.TenantValue}
+		}
+		tHandler.verifyChecksum = s.VerifyChecksum
+		if tHandler.dedup, err = connection.NewDeduplicator(time.Duration(s.DedupWindow)*time.Second, s.DedupHash, s.DedupProperties); err != nil {
+			return fmt.Errorf("handler [%s]: dedupHash: %v", handler.Name(), err)
+		}
+		if tHandler.projection, err = newProjection(s.ProjectFields); err != nil {
+			return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+		}
+		switch s.SchemaType {
+		case "", SchemaTypeBytes:
+		case SchemaTypeAvro:
+			if tHandler.avro, err = newAvroSchema(t.connMgr, s.Topic, s.SchemaDefinition); err != nil {
+				return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+			}
+			// the broker checks the compatibility with the schema of the topic when subscribing
+			tHandler.consumerOpts.Schema = tHandler.avro
+		case SchemaTypeJSON:
+			definition := s.SchemaDefinition
+			if definition == "" {
+				if definition, err = fetchTopicSchema(t.connMgr, s.Topic, "JSON"); err != nil {
+					return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+				}
+			}
+			schema, err := pulsar.NewJSONSchemaWithValidation(definition, nil)
+			if err != nil {
+				return fmt.Errorf("handler [%s]: invalid JSON schema: %v", handler.Name(), err)
+			}
+			if tHandler.recordSchema, err = recordSchema(definition); err != nil {
+				return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+			}
+			tHandler.consumerOpts.Schema = schema
+		case SchemaTypeProtobuf:
+			if s.ProtoDescriptor == "" || s.ProtoMessage == "" {
+				return fmt.Errorf("handler [%s]: protoDescriptor and protoMessage are required when schemaType is %s", handler.Name(), SchemaTypeProtobuf)
+			}
+			if tHandler.protobuf, err = newProtoDecoder(s.ProtoDescriptor, s.ProtoMessage, "PROTOBUF"); err != nil {
+				return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+			}
+			if s.SchemaDefinition != "" {
+				schema, err := pulsar.NewProtoSchemaWithValidation(s.SchemaDefinition, nil)
+				if err != nil {
+					return fmt.Errorf("handler [%s]: invalid Protobuf schema: %v", handler.Name(), err)
+				}
+				tHandler.consumerOpts.Schema = schema
+			}
+		case SchemaTypeProtobufNative:
+			// the client has no ProtobufNative schema, the consumer subscribes without schema
+			descriptor, message := s.ProtoDescriptor, s.ProtoMessage
+			if descriptor == "" {
+				var root string
+				if descriptor, root, err = fetchNativeDescriptor(t.connMgr, s.Topic); err != nil {
+					return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+				}
+				if message == "" {
+					message = root
+				}
+			} else if message == "" {
+				return fmt.Errorf("handler [%s]: protoMessage is required with protoDescriptor", handler.Name())
+			}
+			if tHandler.protobuf, err = newProtoDecoder(descriptor, message, "PROTOBUF_NATIVE"); err != nil {
+				return fmt.Errorf("handler [%s]: %v", handler.Name(), err)
+			}
+		case SchemaTypeAutoConsume:
+			// the consumer subscribes without schema, the schema versions of the messages are looked up instead
+			if _, err = t.connMgr.AdminClient(); err != nil {
+				return fmt.Errorf("handler [%s]: schemaType %s requires the admin API: %v", handler.Name(), SchemaTypeAutoConsume, err)
+			}
+			tHandler.autoSchema = newAutoSchema(t.connMgr)
+		default:
+			return fmt.Errorf("handler [%s]: unsupported schemaType [%s]", handler.Name(), s.SchemaType)
+		}
+		if s.ProcessingHistory {
+			tHandler.history = &processingHistory{host: hostName, handler: handler.Name()}
+		}
+		if s.SampleRate > 0 && s.SampleRate < 100 {
+			tHandler.sampler = &sampler{rate: s.SampleRate, byKey: s.SampleByKey}
+		}
+		if s.StuckThreshold > 0 || s.AckTimeout > 0 {
+			tHandler.watchdog = newWatchdog(tHandler, time.Duration(s.StuckThreshold)*time.Second, s.NackStuck, time.Duration(s.AckTimeout)*time.Second)
+		}
+		if s.MaxMessageAge > 0 {
+			tHandler.expiry = &messageExpiry{maxAge: time.Duration(s.MaxMessageAge) * time.Second, action: s.ExpiredAction, topic: s.ExpiredTopic}
+			if s.ExpiredAction == ExpiredActionRoute {
+				if s.ExpiredTopic == "" {
+					return fmt.Errorf("handler [%s]: expiredTopic is required when expiredAction is %s", handler.Name(), ExpiredActionRoute)
+				}
+				if tHandler.expiry.topic, err = t.connMgr.NormalizeTopic(s.ExpiredTopic); err != nil {
+					return fmt.Errorf("handler [%s]: expiredTopic: %v", handler.Name(), err)
+				}
+			}
+		}
+		if s.OutputSchema != "" {
+			tHandler.outputSchema, err = parseJSONSchema(s.OutputSchema)
+			if err != nil {
+				return err
+			}
+		}
+		tHandler.schemaMismatch = s.SchemaMismatch
+		if s.SchemaInferenceFile != "" {
+			tHandler.inference = newSchemaInference(s.SchemaInferenceFile, s.SchemaInferenceSamples)
+		}
+		tHandler.retry = newRetryPolicy(s.RetryCount, s.RetryDelay, s.RetryOn)
+		tHandler.startBeforeLatest = s.StartBeforeLatest
+		if s.Singleton && t.leaderTopic == "" {
+			return fmt.Errorf("handler [%s]: singleton requires a leaderTopic", handler.Name())
+		}
+		tHandler.singleton = s.Singleton
+		tHandler.sagaCompensate = s.SagaCompensate
+		if s.StartFromMessageID != "" {
+			if s.StartBeforeLatest > 0 {
+				return fmt.Errorf("handler [%s]: startFromMessageId and startBeforeLatest are mutually exclusive", handler.Name())
+			}
+			if tHandler.startFrom, err = parseMsgID(s.StartFromMessageID); err != nil {
+				return fmt.Errorf("handler [%s]: startFromMessageId: %v", handler.Name(), err)
+			}
+		}
+		if s.StartFromTime != "" {
+			if s.StartBeforeLatest > 0 || s.StartFromMessageID != "" {
+				return fmt.Errorf("handler [%s]: startFromTime cannot be combined with startBeforeLatest or startFromMessageId", handler.Name())
+			}
+			if _, err = startTime(s.StartFromTime); err != nil {
+				return fmt.Errorf("handler [%s]: startFromTime: %v", handler.Name(), err)
+			}
+			tHandler.startFromTime = s.StartFromTime
+		}
+		tHandler.scaling = newScalingSignal(s.ScalingTargetLag, s.ScalingMinReplicas, s.ScalingMaxReplicas)
+		if s.SessionGap > 0 {
+			tHandler.sessions = newSessionWindows(tHandler, time.Duration(s.SessionGap)*time.Millisecond, s.SessionMaxMessages)
+		}
+		if s.BatchMaxMessages > 0 {
+			if tHandler.sessions != nil {
+				return fmt.Errorf("handler [%s]: batchMaxMessages cannot be combined with sessionGap", handler.Name())
+			}
+			tHandler.batches = newBatchWindow(tHandler, s.BatchMaxMessages, s.BatchTimeout)
+		}
+		switch s.WatermarkSource {
+		case WatermarkSourceEventTime:
+			tHandler.watermark = newWatermark(s.WatermarkSource, "", time.Duration(s.WatermarkLateness)*time.Millisecond)
+		case WatermarkSourcePayloadField:
+			if s.WatermarkField == "" {
+				return fmt.Errorf("handler [%s]: watermarkField is required when watermarkSource is %s", handler.Name(), WatermarkSourcePayloadField)
+			}
+			tHandler.watermark = newWatermark(s.WatermarkSource, s.WatermarkField, time.Duration(s.WatermarkLateness)*time.Millisecond)
+		}
+		if s.PriorityProperty != "" {
+			tHandler.priority = newPriorityLane(tHandler, s.PriorityProperty, s.PriorityValues, s.PriorityWorkers)
+		}
+		if s.CanaryOf != "" {
+			canaries = append(canaries, canaryTarget{handler: tHandler, primary: s.CanaryOf, percent: s.CanaryPercent, byKey: s.CanaryByKey})
+		}
 		t.handlers = append(t.handlers, tHandler)
 	}
 
-	return nil
+	if t.scalerAddress != "" && !t.hasScalingSignal() {
+		return fmt.Errorf("scalerAddress requires a handler with a scalingTargetLag")
+	}
+	return t.resolveCanaries(canaries)
 }
 
 func getMaxMessageCount() int {
@@ -146,9 +552,50 @@ func getMaxMessageCount() int {
 func (t *Trigger) Start() error {
 	t.logger.Info("Starting Trigger")
 	t.connMgr = t.pulsarCnn.GetConnection().(connection.PulsarConnManager)
+	if validateMode() {
+		// check the handlers against the brokers without consuming, the app exits once validated
+		t.logger.Infof("Validating the handlers, the report is written to [%s]", os.Getenv(EnvValidateReport))
+		go t.validate()
+		return nil
+	}
 	for _, handler := range t.handlers {
-
-		go handler.consume(t.connMgr)
+		if handler.isCanary {
+			// consumes through its primary handler
+			continue
+		}
+		if handler.singleton {
+			t.startSingleton(handler)
+		} else {
+			handler.start(t.connMgr)
+		}
+		connection.RegisterDrainer(handler)
+		connection.RegisterDiagnostics(handler)
+	}
+	if t.loadReportInterval > 0 && t.loadReportDone == nil {
+		t.loadReportDone = make(chan bool)
+		go t.reportLoad(t.loadReportInterval, t.loadReportDone)
+	}
+	if t.scalingDone == nil && t.hasScalingSignal() {
+		interval := t.scalingInterval
+		if interval <= 0 {
+			interval = defaultScalingInterval
+		}
+		t.scalingDone = make(chan bool)
+		go t.reportScaling(interval, t.scalingDone)
+	}
+	if t.scalerAddress != "" && t.scaler == nil {
+		if err := t.startScaler(); err != nil {
+			return fmt.Errorf("unable to serve scaling signals on [%s]: %v", t.scalerAddress, err)
+		}
+	}
+	if t.controlTopic != "" && t.controlDone == nil {
+		t.controlDone = make(chan bool)
+		go t.listenControl(t.connMgr, t.controlDone)
+	}
+	if t.managementAddress != "" && t.management == nil {
+		if err := t.startManagement(); err != nil {
+			return fmt.Errorf("unable to serve the management API on [%s]: %v", t.managementAddress, err)
+		}
 	}
 	t.logger.Info("Trigger Started")
 	return nil
@@ -157,12 +604,32 @@ func (t *Trigger) Start() error {
 // Stop implements util.Managed.Stop
 func (t *Trigger) Stop() error {
 	t.logger.Info("Stopping Trigger")
+	// drains the handlers of this trigger only, the producers of the activities are flushed by the connection
+	drainers := make([]connection.Drainer, 0, len(t.handlers))
 	for _, handler := range t.handlers {
-		// Stop polling
-		if handler.consumer != nil {
-			handler.done <- true
-			handler.consumer.Close()
-		}
+		handler.stopSingleton()
+		connection.UnregisterDrainer(handler)
+		connection.UnregisterDiagnostics(handler)
+		drainers = append(drainers, handler)
+	}
+	connection.Drain(connection.ShutdownDeadline(), drainers...)
+	if t.loadReportDone != nil {
+		close(t.loadReportDone)
+		t.loadReportDone = nil
+	}
+	if t.scalingDone != nil {
+		close(t.scalingDone)
+		t.scalingDone = nil
+	}
+	if t.scaler != nil {
+		t.stopScaler()
+	}
+	if t.controlDone != nil {
+		close(t.controlDone)
+		t.controlDone = nil
+	}
+	if t.management != nil {
+		t.stopManagement()
 	}
 	t.logger.Info("Trigger Stopped")
 	return nil
@@ -179,111 +646,695 @@ func (t *Trigger) Resume() error {
 
 func (t *Trigger) Pause() error {
 	for _, handler := range t.handlers {
-		handler.done <- true
+		handler.StopIntake()
+		handler.stopSingleton()
 	}
 	t.logger.Info("Trigger Paused")
 	return nil
 }
 
-func (handler *Handler) consume(connMgr connection.PulsarConnManager) {
+func (handler *Handler) start(connMgr connection.PulsarConnManager) {
+	handler.stateLock.Lock()
+	defer handler.stateLock.Unlock()
+	if handler.running {
+		return
+	}
+	handler.done = make(chan bool)
+	handler.running = true
+	handler.connMgr = connMgr
+	if handler.priority != nil {
+		handler.priority.start(handler.done)
+	}
+	if handler.watchdog != nil {
+		go handler.watchdog.run(handler.done)
+	}
+	go handler.consume(connMgr, handler.done)
+}
 
-	var err error
+// StopIntake implements connection.Drainer.StopIntake
+func (handler *Handler) StopIntake() {
+	handler.stateLock.Lock()
+	defer handler.stateLock.Unlock()
+	if handler.running {
+		handler.running = false
+		close(handler.done)
+	}
+}
 
-	for handler.consumer == nil {
-		handler.handler.Logger().Debugf("Attempting subscriber creation for handler %v", handler.handler.Name())
-		handler.consumer, err = connMgr.GetSubscriber(handler.consumerOpts)
-		if err != nil {
-			handler.handler.Logger().Errorf("%v", err)
+// AwaitInFlight implements connection.Drainer.AwaitInFlight
+func (handler *Handler) AwaitInFlight(deadline time.Time) bool {
+	if handler.sessions != nil {
+		// no more messages will arrive, close all open session windows
+		handler.sessions.closeAll()
+	}
+	if handler.batches != nil {
+		handler.batches.closeAll()
+	}
+	drained := make(chan struct{})
+	go func() {
+		handler.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-time.After(time.Until(deadline)):
+		handler.logger.Warnf("Handler [%s] still has in-flight messages at shutdown deadline", handler.handler.Name())
+		return false
+	}
+}
 
-			handler.handler.Logger().Infof("Retrying connection after 60 seconds")
-			time.Sleep(60 * time.Second)
-		}
+// Close implements connection.Drainer.Close
+func (handler *Handler) Close() {
+	handler.consumerLock.Lock()
+	if handler.consumer != nil {
+		handler.connMgr.CloseSubscriber(handler.consumer)
+		handler.consumer = nil
 	}
+	handler.consumerLock.Unlock()
+	handler.producersLock.Lock()
+	defer handler.producersLock.Unlock()
+	for topic, producer := range handler.producers {
+		handler.connMgr.CloseProducer(producer)
+		delete(handler.producers, topic)
+	}
+}
 
-	defer handler.handler.Logger().Info("Pulsar Message consumer is stopped")
-	handler.handler.Logger().Info("Pulsar Message consumer is started")
+func (handler *Handler) consume(connMgr connection.PulsarConnManager, done chan bool) {
+
+	refreshed := connMgr.NotifyRefreshed()
+	if !handler.subscribe(&connMgr, done) {
+		return
+	}
+	// only when the handler starts for the first time, consumers re-created later and handlers resumed after a
+	// pause, a drain or a leader election continue where the subscription is
+	handler.startSeek.Do(handler.seekStart)
+
+	defer handler.logger.Info("Pulsar Message consumer is stopped")
+	handler.logger.Info("Pulsar Message consumer is started")
+	// consecutive closures of the consumer channel
+	closures := 0
 	for {
+		if !handler.holdOff(connMgr.Backpressure, done) || !handler.awaitCircuit(done) || !handler.pause.wait(done) {
+			return
+		}
 		select {
+		case <-refreshed:
+			refreshed = connMgr.NotifyRefreshed()
+			if !handler.reattach(&connMgr, done) {
+				return
+			}
 		case msg, ok := <-handler.consumer.Chan():
 			if !ok {
-				handler.handler.Logger().Error("Error while receiving message")
-				time.Sleep(1 * time.Second)
+				// a closed channel stays closed, only a new consumer receives again
+				if !handler.resubscribe(&connMgr, done, closures) {
+					return
+				}
+				closures++
 				continue
 			}
+			closures = 0
 			// Handle messages concurrently on separate goroutine
 			// go handler.handleMessage(msg)
+			// receiving blocks while the payloads in flight exceed the limit, released when processed
+			handler.inFlightBytes.Acquire(int64(len(msg.Payload())))
+			handler.inFlight.Add(1)
+			if handler.priority != nil && handler.priority.matches(msg) {
+				if !handler.priority.dispatch(msg, done) {
+					handler.inFlightBytes.Release(int64(len(msg.Payload())))
+					handler.inFlight.Done()
+					return
+				}
+				continue
+			}
+			if handler.partitions != nil {
+				if !handler.partitions.dispatch(msg, done) {
+					handler.inFlightBytes.Release(int64(len(msg.Payload())))
+					handler.inFlight.Done()
+					return
+				}
+				continue
+			}
 			if handler.asyncMode {
 				handler.wg.Add(1)
 				handler.currentMsgCount++
-				go handler.handleMessage(msg)
+				go handler.handleAsync(msg)
 				if handler.currentMsgCount >= handler.maxMsgCount {
-					handler.handler.Logger().Infof("Total messages received are equal or more than maximum threshold [%d]. Blocking message handler.", handler.maxMsgCount)
+					handler.logger.Infof("Total messages received are equal or more than maximum threshold [%d]. Blocking message handler.", handler.maxMsgCount)
 					handler.wg.Wait()
 					// reset count
 					handler.currentMsgCount = 0
-					handler.handler.Logger().Info("All received messages are processed. Unblocking message handler.")
+					handler.logger.Info("All received messages are processed. Unblocking message handler.")
 				}
 			} else {
 				handler.handleMessage(msg)
 			}
-		case <-handler.done:
+		case <-done:
 			return
 		}
 	}
 }
 
+// subscribe creates the consumer once connected, it returns false if the handler was stopped meanwhile or
+// reconnecting was given up
+func (handler *Handler) subscribe(connMgr *connection.PulsarConnManager, done chan bool) bool {
+	var err error
+	for retry := 0; handler.consumer == nil; retry++ {
+		// the connection reconnects in the background with backoff and notifies once it is connected
+		select {
+		case <-connMgr.NotifyConnected():
+		case <-done:
+			return false
+		}
+		handler.logger.Debugf("Attempting subscriber creation for handler %v", handler.handler.Name())
+		var consumer pulsar.Consumer
+		consumer, err = connMgr.GetSubscriber(handler.consumerOpts)
+		handler.consumerLock.Lock()
+		handler.consumer = consumer
+		handler.consumerLock.Unlock()
+		if err != nil {
+			handler.logger.Errorf("%v", err)
+			if errors.Is(err, connection.ErrReconnectGaveUp) {
+				return false
+			}
+			// the retries of the connection are exhausted or the failure is permanent, e.g. missing permissions
+			// which may still be granted
+			select {
+			case <-time.After(connMgr.RetryDelay(retry)):
+			case <-done:
+				return false
+			}
+		}
+	}
+	if handler.fencing {
+		handler.logger.Infof("Consumer subscribed with generation %d", handler.generation.advance())
+	}
+
+	if handler.canary != nil {
+		handler.canary.target.consumer = handler.consumer
+		handler.canary.target.connMgr = *connMgr
+	}
+	return true
+}
+
+// resubscribe re-creates the consumer once its message channel was closed, e.g. by a broker restart or fencing,
+// after the messages received with it are processed. Consumers closed again right away are re-created with the
+// backoff of the connection.
+func (handler *Handler) resubscribe(connMgr *connection.PulsarConnManager, done chan bool, closures int) bool {
+	resubscribes.WithLabelValues(handler.handler.Name()).Inc()
+	if closures > 0 {
+		delay := connMgr.RetryDelay(closures - 1)
+		handler.logger.Warnf("Consumer of handler [%s] closed again, re-creating it in %v", handler.handler.Name(), delay)
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return false
+		}
+	} else {
+		handler.logger.Warnf("Consumer of handler [%s] closed, re-creating it", handler.handler.Name())
+	}
+	handler.inFlight.Wait()
+	handler.Close()
+	return handler.subscribe(connMgr, done)
+}
+
+// reattach re-creates the consumer and the reject producers with the client swapped by a credential refresh,
+// once the messages received with the previous consumer are processed
+func (handler *Handler) reattach(connMgr *connection.PulsarConnManager, done chan bool) bool {
+	if !connMgr.Refreshed(handler.consumer) {
+		return true
+	}
+	handler.logger.Infof("Connection refreshed, re-creating the consumer of handler [%s]", handler.handler.Name())
+	handler.inFlight.Wait()
+	handler.Close()
+	handler.connMgr = *connMgr
+	return handler.subscribe(connMgr, done)
+}
+
+// currentConsumer returns the consumer of the handler to acknowledge the message with, nil once the consumer was
+// closed, e.g. when a window is flushed after the shutdown deadline. The broker then redelivers the message.
+func (handler *Handler) currentConsumer(msg pulsar.ConsumerMessage) pulsar.Consumer {
+	handler.consumerLock.Lock()
+	defer handler.consumerLock.Unlock()
+	if handler.consumer == nil {
+		handler.logger.Warnf("Consumer of handler [%s] is closed, message [%s] is redelivered", handler.handler.Name(), msg.ID())
+	}
+	return handler.consumer
+}
+
+// withConsumer runs the operation with the consumer of the handler, which is not closed meanwhile. It fails with
+// errNotSubscribed if the handler has no consumer.
+func (handler *Handler) withConsumer(operation func(pulsar.Consumer) error) error {
+	handler.consumerLock.Lock()
+	defer handler.consumerLock.Unlock()
+	if handler.consumer == nil {
+		return errNotSubscribed
+	}
+	return operation(handler.consumer)
+}
+
+// seekStart moves the subscription to the start position given by startBeforeLatest, startFromMessageId or
+// startFromTime
+func (handler *Handler) seekStart() {
+	if handler.startBeforeLatest > 0 {
+		if err := handler.seekBeforeLatest(handler.startBeforeLatest); err != nil {
+			handler.logger.Errorf("Unable to start %d messages before the latest position: %v", handler.startBeforeLatest, err)
+		}
+	} else if handler.startFrom != nil {
+		if err := handler.withConsumer(func(consumer pulsar.Consumer) error { return consumer.Seek(handler.startFrom) }); err != nil {
+			handler.logger.Errorf("Unable to start from message [%s]: %v", formatMsgID(handler.startFrom), err)
+		} else {
+			handler.logger.Infof("Subscription [%s] moved to message [%s]", handler.consumerOpts.SubscriptionName, formatMsgID(handler.startFrom))
+		}
+	} else if handler.startFromTime != "" {
+		// relative times are relative to the start of the handler
+		ts, _ := startTime(handler.startFromTime)
+		if err := handler.withConsumer(func(consumer pulsar.Consumer) error { return consumer.SeekByTime(ts) }); err != nil {
+			handler.logger.Errorf("Unable to start from %v: %v", ts, err)
+		} else {
+			handler.logger.Infof("Subscription [%s] moved to the messages published since %v", handler.consumerOpts.SubscriptionName, ts)
+		}
+	}
+}
+
+// holdOff waits while producers signal backpressure, it returns false if the handler was stopped meanwhile
+func (handler *Handler) holdOff(shared *connection.Backpressure, done chan bool) bool {
+	for {
+		var delay time.Duration
+		if shared != nil {
+			delay = shared.Delay()
+		}
+		if delay <= 0 {
+			return true
+		}
+		handler.logger.Debugf("Backpressure signalled, holding off receiving for %v", delay)
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return false
+		}
+	}
+}
+
+// formatMsgID formats message ids the way they are exposed in the trigger output
+func formatMsgID(msgID pulsar.MessageID) string {
+	if msgID == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", msgID.Serialize())
+}
+
+// parseMsgID parses a message id formatted by formatMsgID
+func parseMsgID(s string) (pulsar.MessageID, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message id [%s], expected the msgid output of the trigger: %v", s, err)
+	}
+	id, err := pulsar.DeserializeMessageID(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message id [%s]: %v", s, err)
+	}
+	return id, nil
+}
+
+func (handler *Handler) ack(msg pulsar.ConsumerMessage) {
+	consumer := handler.currentConsumer(msg)
+	if consumer == nil {
+		return
+	}
+	if err := consumer.Ack(msg); err != nil {
+		// with ackWithResponse the broker confirms the ack, the message is redelivered if it failed
+		handler.logger.Errorf("Failed to acknowledge message [%s]: %v", msg.ID(), err)
+		handledMessages.WithLabelValues(handler.handler.Name(), "ack_failed").Inc()
+		return
+	}
+	handler.stats.recordAck(true)
+	handledMessages.WithLabelValues(handler.handler.Name(), "ack").Inc()
+}
+
+func (handler *Handler) nack(msg pulsar.ConsumerMessage) {
+	consumer := handler.currentConsumer(msg)
+	if consumer == nil {
+		return
+	}
+	if handler.shadowMode {
+		// redelivering on the shadow subscription would only repeat the dry run
+		handler.logger.Infof("Shadow mode: flow outcome for message [%s] is nack, acknowledging it on the shadow subscription", msg.ID())
+		consumer.Ack(msg)
+		handler.stats.recordAck(false)
+		handledMessages.WithLabelValues(handler.handler.Name(), "nack").Inc()
+		return
+	}
+	consumer.Nack(msg)
+	handler.stats.recordAck(false)
+	handledMessages.WithLabelValues(handler.handler.Name(), "nack").Inc()
+}
+
+// reconsumeLater redelivers the message through the retry topic after the delay, the message is acknowledged
+// on the subscription
+func (handler *Handler) reconsumeLater(msg pulsar.ConsumerMessage, delay time.Duration) {
+	if handler.shadowMode {
+		handler.nack(msg)
+		return
+	}
+	if !handler.retryEnable {
+		handler.logger.Warnf("Flow asked to reconsume message [%s] later but retryEnable is not set, negatively acknowledging it", msg.ID())
+		handler.nack(msg)
+		return
+	}
+	consumer := handler.currentConsumer(msg)
+	if consumer == nil {
+		return
+	}
+	consumer.ReconsumeLater(handler.withHistory(msg, "reconsume"), delay)
+	handler.stats.recordAck(false)
+	handledMessages.WithLabelValues(handler.handler.Name(), "reconsume").Inc()
+}
+
+// reconsumeDelay returns the delay the flow asked to reconsume the message after, 0 if it did not
+func reconsumeDelay(attrs map[string]interface{}) time.Duration {
+	seconds, err := coerce.ToInt(attrs["reconsumeLater"])
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// awaitCircuit pauses consumption while the downstream circuit breaker is open, it returns false if
+// the handler was stopped meanwhile
+func (handler *Handler) awaitCircuit(done chan bool) bool {
+	if handler.circuitBreaker == "" {
+		return true
+	}
+	state, changed := connection.GetCircuitState(handler.circuitBreaker)
+	if state != connection.CircuitOpen {
+		return true
+	}
+	handler.logger.Infof("Circuit breaker [%s] is open, pausing consumption", handler.circuitBreaker)
+	for state == connection.CircuitOpen {
+		select {
+		case <-changed:
+			state, changed = connection.GetCircuitState(handler.circuitBreaker)
+		case <-done:
+			return false
+		}
+	}
+	handler.logger.Infof("Circuit breaker [%s] is %s, resuming consumption", handler.circuitBreaker, state)
+	return true
+}
+
+func (handler *Handler) handleAsync(msg pulsar.ConsumerMessage) {
+	defer func() {
+		handler.wg.Done()
+		handler.currentMsgCount--
+	}()
+	handler.handleMessage(msg)
+}
+
 func (handler *Handler) handleMessage(msg pulsar.ConsumerMessage) {
 	defer func() {
-		if handler.asyncMode {
-			handler.wg.Done()
-			handler.currentMsgCount--
-		}
+		handler.inFlightBytes.Release(int64(len(msg.Payload())))
+		handler.inFlight.Done()
 	}()
-	handler.handler.Logger().Debugf("Message received - %s", msg.ID())
-	out := &Output{}
-	if handler.handler.Settings()["format"] != nil &&
+	if handler.canary != nil && handler.canary.sampler.sampled(msg) {
+		handler.canary.target.inFlightBytes.Acquire(int64(len(msg.Payload())))
+		handler.canary.target.inFlight.Add(1)
+		handler.canary.target.handleMessage(msg)
+		return
+	}
+	handler.logger.Debugf("Message received - %s", msg.ID())
+	handler.lastMsgID.Store(formatMsgID(msg.ID()))
+	if handler.tenant != nil && !handler.tenant.matches(msg) {
+		filteredMessages.WithLabelValues(handler.handler.Name()).Inc()
+		handler.logger.Debugf("Message [%s] belongs to another tenant, acknowledging it", msg.ID())
+		handler.ack(msg)
+		return
+	}
+	if handler.verifyChecksum {
+		if _, err := connection.VerifyChecksum(msg.Payload(), msg.Properties()); err != nil {
+			checksumFailures.WithLabelValues(handler.handler.Name()).Inc()
+			handler.reject(msg, err.Error())
+			return
+		}
+	}
+	if handler.dedup != nil && handler.dedup.Duplicate(handler.dedup.Hash(msg.Payload(), msg.Properties()), formatMsgID(msg.ID())) {
+		duplicateMessages.WithLabelValues(handler.handler.Name()).Inc()
+		handler.logger.Debugf("Message [%s] has the content of a message received within the dedup window, acknowledging it", msg.ID())
+		handler.ack(msg)
+		return
+	}
+	if handler.maxPayloadSize > 0 && len(msg.Payload()) > handler.maxPayloadSize {
+		oversizedMessages.WithLabelValues(handler.handler.Name(), handler.connMgr.MetricTopics.Label(msg.Topic())).Inc()
+		handler.reject(msg, fmt.Sprintf("payload size %d exceeds maximum of %d bytes", len(msg.Payload()), handler.maxPayloadSize))
+		return
+	}
+	if handler.sampler != nil && !handler.sampler.sampled(msg) {
+		handler.logger.Debugf("Message [%s] not sampled, acknowledging it", msg.ID())
+		handler.ack(msg)
+		return
+	}
+	expired := false
+	if handler.expiry != nil {
+		if age, ok := handler.expiry.expired(msg); ok {
+			expiredMessages.WithLabelValues(handler.handler.Name()).Inc()
+			switch handler.expiry.action {
+			case ExpiredActionFlag:
+				expired = true
+			case ExpiredActionRoute:
+				handler.sendAndAck(handler.expiry.topic, msg, fmt.Sprintf("message age %v exceeds maximum of %v", age, handler.expiry.maxAge))
+				return
+			default:
+				handler.logger.Debugf("Message [%s] expired %v ago, skipping it", msg.ID(), age-handler.expiry.maxAge)
+				handler.ack(msg)
+				return
+			}
+		}
+	}
+	ctx := context.Background()
+	message := &Message{Topic: msg.Topic(), Key: msg.Key(), Payload: msg.Payload(), Properties: msg.Properties()}
+	for _, p := range handler.preProcessors {
+		if err := p.Process(ctx, message); err != nil {
+			handler.logger.Errorf("Pre-processing of message [%s] failed: %v", msg.ID(), err)
+			handler.nack(msg)
+			return
+		}
+	}
+	if handler.decompress {
+		if err := decompressPayload(message); err != nil {
+			handler.logger.Errorf("Decompression of message [%s] failed: %v", msg.ID(), err)
+			handler.nack(msg)
+			return
+		}
+	}
+	// with consumerCryptoFailureAction CONSUME messages which could not be decrypted are delivered as is
+	out := &Output{SchemaVersion: schemaVersion(msg), Encrypted: msg.GetEncryptionContext() != nil}
+	if out.Encrypted {
+		out.Payload = base64.StdEncoding.EncodeToString(message.Payload)
+	} else if handler.avro != nil {
+		record, err := decodeAvro(handler.avro, message.Payload)
+		if err != nil {
+			handler.logger.Errorf("Decoding of message [%s] failed: %v", msg.ID(), err)
+			handler.nack(msg)
+			return
+		}
+		out.Payload = record
+	} else if handler.protobuf != nil {
+		record, err := handler.protobuf.decode(message.Payload)
+		if err != nil {
+			handler.logger.Errorf("Decoding of message [%s] failed: %v", msg.ID(), err)
+			handler.nack(msg)
+			return
+		}
+		out.Payload = record
+	} else if handler.autoSchema != nil && out.SchemaVersion >= 0 {
+		record, err := handler.autoSchema.decode(msg.Topic(), out.SchemaVersion, message.Payload)
+		if err != nil {
+			handler.logger.Errorf("Decoding of message [%s] failed: %v", msg.ID(), err)
+			handler.nack(msg)
+			return
+		}
+		out.Payload = record
+	} else if err := decodePayload(message, handler.charset); err != nil {
+		handler.logger.Errorf("Decoding of message [%s] failed: %v", msg.ID(), err)
+		handler.nack(msg)
+		return
+	} else if handler.recordSchema != nil || handler.handler.Settings()["format"] != nil &&
 		handler.handler.Settings()["format"].(string) == "JSON" {
 		var obj interface{}
-		err := json.Unmarshal(msg.Payload(), &obj)
+		err := json.Unmarshal(message.Payload, &obj)
 		if err != nil {
-			handler.handler.Logger().Errorf("Pulsar consumer, configured to receive JSON formatted messages, was unable to parse message: [%v]", msg.Payload())
-			handler.consumer.Nack(msg)
+			handler.logger.Errorf("Pulsar consumer, configured to receive JSON formatted messages, was unable to parse message: [%v]", message.Payload)
+			handler.nack(msg)
 			return
 		}
 		out.Payload = obj
 	} else {
-		out.Payload = string(msg.Payload())
+		out.Payload = string(message.Payload)
 	}
 
-	ctx := context.Background()
 	if trace.Enabled() {
-		tc, _ := trace.GetTracer().Extract(trace.TextMap, msg.Properties())
+		tc, _ := trace.GetTracer().Extract(trace.TextMap, message.Properties)
 		if tc != nil {
 			ctx = trace.AppendTracingContext(ctx, tc)
 		}
 	}
-	out.Properties = msg.Properties()
+	if handler.recordSchema != nil && !out.Encrypted {
+		// validated only, the payload is output as published
+		if _, err := handler.recordSchema.coerce(out.Payload, "$"); err != nil {
+			if !handler.schemaMismatched(msg, "topic schema", err) {
+				return
+			}
+		}
+	}
+	if handler.outputSchema != nil && !out.Encrypted {
+		coerced, err := handler.outputSchema.coerce(out.Payload, "$")
+		if err != nil {
+			if !handler.schemaMismatched(msg, "output schema", err) {
+				return
+			}
+		} else {
+			out.Payload = coerced
+		}
+	}
+	if handler.inference != nil {
+		if err := handler.inference.observe(out.Payload); err != nil {
+			handler.logger.Errorf("Failed to write inferred schema: %v", err)
+		}
+	}
+	if handler.watermark != nil {
+		if eventTime, ok := handler.watermark.eventTime(msg, out.Payload); ok {
+			current, late := handler.watermark.observe(eventTime)
+			out.Watermark = current.UnixMilli()
+			out.Late = late
+			watermarkGauge.WithLabelValues(handler.handler.Name()).Set(float64(current.UnixMilli()) / 1000)
+		} else {
+			handler.logger.Debugf("No valid event time found in message [%s]", msg.ID())
+		}
+	}
+	if len(handler.projection) > 0 && !out.Encrypted {
+		// after the schema validation and the event time lookup, which need the whole document
+		out.Payload = handler.projection.project(out.Payload)
+	}
+	out.Properties = message.Properties
 	out.Topic = msg.Topic()
+	out.Key = msg.Key()
 	out.RedeliveryCount = int(msg.RedeliveryCount())
-	msgID := msg.ID()
-	if msgID != nil {
-		out.Msgid = fmt.Sprintf("%x", msgID.Serialize())
+	// empty for messages published to the cluster of the connection
+	out.OriginCluster = msg.GetReplicatedFrom()
+	out.Partition = -1
+	if msgID := msg.ID(); msgID != nil {
+		out.Partition = int(msgID.PartitionIdx())
+	}
+	setBatchMetadata(out, msg)
+	out.Expired = expired
+	out.Msgid = formatMsgID(msg.ID())
+	out.Context = handler.connMgr.Propagation.Extract(out.Properties)
+	out.StructuredProperties = handler.connMgr.PropertyCodec.Decode(out.Properties)
+	if saga := connection.SagaFromProperties(out.Properties); saga != nil {
+		out.Saga = saga.ToMap()
+	}
+	ctx = connection.NewContextWithValues(ctx, out.Context)
+	if identity, err := handler.connMgr.Identity(); err != nil {
+		handler.logger.Warnf("Identity of the connection not provided to the flow: %v", err)
+	} else if identity != nil {
+		out.Identity = identity.ToMap()
+		ctx = connection.NewContextWithIdentity(ctx, identity)
+	}
+	if handler.logger.DebugEnabled() {
+		masker := handler.connMgr.Masker
+		handler.logger.Debugf("Message received [%v] with properties [%v] and msgID [%v]", masker.MaskPayload(out.Payload), masker.MaskProperties(out.Properties), out.Msgid)
+	}
+	if handler.sessions != nil {
+		// the flow is invoked when the session window of the message key closes
+		handler.sessions.add(msg.Key(), msg, out)
+		return
+	}
+	if handler.batches != nil {
+		// the flow is invoked when the batch is full or its timeout expires
+		handler.batches.add("", msg, out)
+		return
 	}
-	handler.handler.Logger().Debugf("Message received [%v] with msgID [%v]", out.Payload, out.Msgid)
 	// Do something with the message
 	if out.Msgid != "" {
 		ctx = trigger.NewContextWithEventId(ctx, out.Msgid)
 	}
+	handler.invoke(ctx, out, msg)
+}
+
+// invoke runs the flow and acknowledges the messages it was invoked for based on the outcome
+func (handler *Handler) invoke(ctx context.Context, out *Output, msgs ...pulsar.ConsumerMessage) {
+	for _, msg := range msgs {
+		msg := msg
+		connection.TrackMessage(formatMsgID(msg.ID()), func() { handler.ack(msg) })
+		if handler.watchdog != nil {
+			handler.watchdog.track(msg)
+		}
+	}
+	if handler.fencing {
+		out.Generation = handler.generation.current()
+	}
+	atomic.AddInt64(&handler.processing, 1)
+	defer atomic.AddInt64(&handler.processing, -1)
+	handler.stateLock.Lock()
+	done := handler.done
+	handler.stateLock.Unlock()
+	start := time.Now()
 	attrs, err := handler.handler.Handle(ctx, out)
-	if err == nil {
-		// Message processed successfully
-		if attrs[" _nack"] != nil && attrs[" _nack"] == true {
-			handler.consumer.Nack(msg)
-		} else {
-			handler.consumer.Ack(msg)
+	stopped := false
+	for attempt := 1; err != nil && !stopped && handler.retry.shouldRetry(attempt, err); attempt++ {
+		handler.logger.Warnf("Flow execution failed, retrying in %v (attempt %d of %d): %v", handler.retry.delay, attempt, handler.retry.count, err)
+		select {
+		case <-time.After(handler.retry.delay):
+			attrs, err = handler.handler.Handle(ctx, out)
+		case <-done:
+			// the broker redelivers the messages once the handler or another consumer is running again
+			handler.logger.Infof("Handler stopped, returning the messages to the broker instead of retrying")
+			stopped = true
+		}
+	}
+	elapsed := time.Since(start)
+	handler.stats.recordProcessingTime(elapsed)
+	if handler.scaling != nil {
+		handler.scaling.record(elapsed)
+	}
+	processingTime.WithLabelValues(handler.handler.Name()).Observe(elapsed.Seconds())
+	delay := reconsumeDelay(attrs)
+	nacked := nackedMessages(attrs)
+	if err == nil && handler.nextTopic != "" && !handler.shadowMode && attrs[" _nack"] != true && delay == 0 {
+		// the messages are only acknowledged once the next stage has them
+		if err = handler.forwardStage(msgs, attrs); err != nil {
+			handler.logger.Errorf("Failed to publish to the next stage [%s]: %v", handler.nextTopic, err)
+		}
+	}
+	for _, msg := range msgs {
+		if !connection.ReleaseMessage(formatMsgID(msg.ID())) {
+			// already acknowledged by an activity of the flow
+			if handler.watchdog != nil {
+				handler.watchdog.release(msg)
+			}
+			continue
+		}
+		if handler.watchdog != nil && !handler.watchdog.release(msg) {
+			// already negatively acknowledged as stuck
+			continue
+		}
+		if handler.fencing && out.Generation != handler.generation.current() {
+			// received by a previous consumer, the broker redelivers it to the current one
+			handler.logger.Warnf("Message [%s] of generation %d fenced, the consumer subscribed again meanwhile", msg.ID(), out.Generation)
+			continue
+		}
+		if err == nil {
+			// Message processed successfully
+			if (attrs[" _nack"] != nil && attrs[" _nack"] == true) || nacked[formatMsgID(msg.ID())] {
+				handler.nack(msg)
+			} else if delay > 0 {
+				handler.reconsumeLater(msg, delay)
+			} else {
+				handler.ack(msg)
+			}
+		} else if stopped || !handler.sagaCompensate || !handler.compensate(msg, err) {
+			// Failed to process messages
+			handler.nack(msg)
 		}
-	} else {
-		// Failed to process messages
-		handler.consumer.Nack(msg)
 	}
 }
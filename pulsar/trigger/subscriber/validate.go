@@ -0,0 +1,303 @@
+package subscriber
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+// EnvValidateReport enables the dry-validate mode: instead of consuming, the triggers check the settings of their
+// handlers against the brokers, write the report to the given file ("-" for stdout) and exit the app, with status 1
+// if a check failed
+const EnvValidateReport = "FLOGO_PULSAR_VALIDATE_REPORT"
+
+const (
+	CheckPassed  = "passed"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+// validationReport is the machine-readable report of the dry-validate mode
+type validationReport struct {
+	Valid    bool                `json:"valid"`
+	Handlers []handlerValidation `json:"handlers"`
+}
+
+type handlerValidation struct {
+	Handler      string            `json:"handler"`
+	Topic        string            `json:"topic"`
+	Subscription string            `json:"subscription"`
+	Valid        bool              `json:"valid"`
+	Checks       []validationCheck `json:"checks"`
+}
+
+type validationCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// topicSchema is the schema of a topic returned by the admin API
+type topicSchema struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// validation collects the reports of all pulsar triggers of the app, the report is written once all of them
+// validated their handlers
+var validation = struct {
+	lock     sync.Mutex
+	triggers int
+	reported int
+	handlers []handlerValidation
+}{}
+
+func validateMode() bool {
+	return os.Getenv(EnvValidateReport) != ""
+}
+
+// validate checks the handlers of the trigger without consuming and exits the app once all triggers reported
+func (t *Trigger) validate() {
+	connMgr := t.connMgr
+	connErr := connMgr.Connect()
+	var results []handlerValidation
+	for _, handler := range t.handlers {
+		results = append(results, handler.validate(&connMgr, connErr))
+	}
+
+	validation.lock.Lock()
+	defer validation.lock.Unlock()
+	validation.handlers = append(validation.handlers, results...)
+	validation.reported++
+	if validation.reported < validation.triggers {
+		return
+	}
+	report := validationReport{Valid: true, Handlers: validation.handlers}
+	for _, h := range report.Handlers {
+		report.Valid = report.Valid && h.Valid
+	}
+	if err := writeValidationReport(os.Getenv(EnvValidateReport), report); err != nil {
+		t.logger.Errorf("Unable to write the validation report: %v", err)
+		os.Exit(2)
+	}
+	if !report.Valid {
+		t.logger.Errorf("Validation of the pulsar handlers failed")
+		os.Exit(1)
+	}
+	t.logger.Info("Validation of the pulsar handlers passed")
+	os.Exit(0)
+}
+
+func writeValidationReport(path string, report validationReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// validate checks that the topic exists, that the outputSchema matches the schema of the topic and that the
+// connection is permitted to consume from it. The topic and schema checks require the admin API.
+func (handler *Handler) validate(connMgr *connection.PulsarConnManager, connErr error) handlerValidation {
+	topic := handler.consumerOpts.Topic
+	if topic == "" {
+		topic = handler.consumerOpts.TopicsPattern
+	}
+	v := handlerValidation{Handler: handler.handler.Name(), Topic: topic, Subscription: handler.consumerOpts.SubscriptionName, Valid: true}
+	check := func(name string, err error, skipped, message string) {
+		c := validationCheck{Name: name, Status: CheckPassed, Message: message}
+		if err != nil {
+			c.Status, c.Message = CheckFailed, err.Error()
+			v.Valid = false
+		} else if skipped != "" {
+			c.Status, c.Message = CheckSkipped, skipped
+		}
+		v.Checks = append(v.Checks, c)
+	}
+	if connErr != nil {
+		check("connection", connErr, "", "")
+		return v
+	}
+	check("connection", nil, "", "")
+
+	admin, adminErr := connMgr.AdminClient()
+	if handler.consumerOpts.TopicsPattern != "" {
+		// the topics are discovered at runtime, topics created later are validated by consuming them
+		if adminErr != nil {
+			check("topic", nil, "requires the admin API: "+adminErr.Error(), "")
+		} else {
+			message, err := matchingTopics(admin, handler.consumerOpts.TopicsPattern)
+			check("topic", err, "", message)
+		}
+		check("schema", nil, "not checked for a topicsPattern", "")
+		check("permission", nil, "not checked for a topicsPattern", "")
+		return v
+	}
+	// the admin API takes fully qualified topics
+	qualified, _ := connection.NormalizeTopic(handler.consumerOpts.Topic, true)
+	topicPath := "/admin/v2/" + strings.Replace(qualified, "://", "/", 1)
+	exists := true
+	if adminErr != nil {
+		check("topic", nil, "requires the admin API: "+adminErr.Error(), "")
+		check("schema", nil, "requires the admin API: "+adminErr.Error(), "")
+	} else {
+		var message string
+		var err error
+		exists, message, err = topicExists(admin, topicPath)
+		check("topic", err, "", message)
+		if exists {
+			message, err = handler.checkSchema(admin, qualified)
+			check("schema", err, "", message)
+		} else {
+			check("schema", nil, "the topic does not exist", "")
+		}
+	}
+	if exists {
+		check("permission", checkConsumePermission(connMgr, handler.consumerOpts.Topic), "", "")
+	} else {
+		// a reader would create the topic if the brokers allow auto topic creation
+		check("permission", nil, "the topic could not be verified", "")
+	}
+	return v
+}
+
+// topicExists looks up the topic with the admin API, the message reports its partitions
+func topicExists(admin *connection.AdminClient, topicPath string) (bool, string, error) {
+	var metadata struct {
+		Partitions int `json:"partitions"`
+	}
+	if err := admin.Get(topicPath+"/partitions", &metadata); err != nil {
+		return false, "", err
+	}
+	if metadata.Partitions > 0 {
+		return true, fmt.Sprintf("partitioned topic with %d partitions", metadata.Partitions), nil
+	}
+	// the partitions of topics which do not exist are reported as 0 as well
+	err := admin.Get(topicPath+"/stats", nil)
+	var adminErr *connection.AdminError
+	if errors.As(err, &adminErr) && adminErr.StatusCode == http.StatusNotFound {
+		return false, "", fmt.Errorf("the topic does not exist")
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, "non-partitioned topic", nil
+}
+
+// matchingTopics lists the topics of the namespace of the pattern, the message reports how many match it. Like the
+// client, the pattern from the namespace on is matched against the topic names without the partition suffix.
+func matchingTopics(admin *connection.AdminClient, pattern string) (string, error) {
+	namespacePattern := pattern[strings.Index(pattern, "://")+3:]
+	parts := strings.SplitN(namespacePattern, "/", 3)
+	re, err := regexp.Compile(namespacePattern)
+	if err != nil {
+		return "", err
+	}
+	var topics []string
+	if err = admin.Get("/admin/v2/namespaces/"+parts[0]+"/"+parts[1]+"/topics", &topics); err != nil {
+		return "", err
+	}
+	matched := make(map[string]bool)
+	for _, t := range topics {
+		if i := strings.LastIndex(t, "-partition-"); i > 0 {
+			t = t[:i]
+		}
+		if re.MatchString(t) {
+			matched[t] = true
+		}
+	}
+	if len(matched) == 0 {
+		return "", fmt.Errorf("no topic of namespace [%s/%s] matches the pattern", parts[0], parts[1])
+	}
+	return fmt.Sprintf("%d topics match the pattern", len(matched)), nil
+}
+
+// checkSchema verifies that the properties required by the outputSchema are fields of the JSON or Avro schema of the
+// topic, and that the topic of a handler with a schemaType has a schema of that type. Topics without a schema are consumed as bytes.
+func (handler *Handler) checkSchema(admin *connection.AdminClient, topic string) (string, error) {
+	schema := &topicSchema{}
+	err := admin.Get(topicSchemaPath(topic), schema)
+	var adminErr *connection.AdminError
+	if errors.As(err, &adminErr) && adminErr.StatusCode == http.StatusNotFound {
+		if handler.avro != nil {
+			return "", fmt.Errorf("the handler consumes Avro records but the topic has no schema")
+		}
+		return "no schema, payloads are consumed as bytes", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if handler.avro != nil && schema.Type != "AVRO" {
+		return "", fmt.Errorf("the handler consumes Avro records but the topic schema is %s", schema.Type)
+	}
+	if handler.recordSchema != nil && schema.Type != "JSON" {
+		return "", fmt.Errorf("the handler consumes JSON records but the topic schema is %s", schema.Type)
+	}
+	if handler.protobuf != nil && schema.Type != handler.protobuf.topicSchemaType {
+		return "", fmt.Errorf("the handler consumes %s messages but the topic schema is %s", handler.protobuf.topicSchemaType, schema.Type)
+	}
+	if handler.outputSchema == nil {
+		return "schema " + schema.Type, nil
+	}
+	if schema.Type != "JSON" && schema.Type != "AVRO" {
+		return "", fmt.Errorf("the outputSchema expects JSON payloads but the topic schema is %s", schema.Type)
+	}
+	var record struct {
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	}
+	if err = json.Unmarshal([]byte(schema.Data), &record); err != nil {
+		return "", fmt.Errorf("unable to parse the %s schema of the topic: %v", schema.Type, err)
+	}
+	fields := make(map[string]bool, len(record.Fields))
+	for _, f := range record.Fields {
+		fields[f.Name] = true
+	}
+	var missing []string
+	for _, name := range handler.outputSchema.Required {
+		if !fields[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("the properties %v required by the outputSchema are not fields of the %s schema of the topic", missing, schema.Type)
+	}
+	return "schema " + schema.Type + " compatible with the outputSchema", nil
+}
+
+// checkConsumePermission creates a reader on the (first partition of the) topic, which requires the consume
+// permission but neither creates a subscription nor acknowledges messages
+func checkConsumePermission(connMgr *connection.PulsarConnManager, topic string) error {
+	partitions, err := connMgr.Client.TopicPartitions(topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) > 0 {
+		topic = partitions[0]
+	}
+	reader, err := connMgr.Client.CreateReader(pulsar.ReaderOptions{
+		Topic:             topic,
+		StartMessageID:    pulsar.LatestMessageID(),
+		ReceiverQueueSize: 1,
+	})
+	if err != nil {
+		return err
+	}
+	reader.Close()
+	return nil
+}
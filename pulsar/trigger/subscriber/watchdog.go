@@ -0,0 +1,104 @@
+package subscriber
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// watchdog flags messages whose flow runs longer than the threshold, as the flow is likely hung, and
+// optionally negatively acknowledges them so they are redelivered to another consumer. Messages not acknowledged
+// within the ack timeout are negatively acknowledged as well.
+type watchdog struct {
+	handler    *Handler
+	threshold  time.Duration
+	nackStuck  bool
+	ackTimeout time.Duration
+	lock       sync.Mutex
+	inFlight   map[string]*trackedMessage
+}
+
+type trackedMessage struct {
+	msg      pulsar.ConsumerMessage
+	started  time.Time
+	flagged  bool
+	resolved bool
+}
+
+func newWatchdog(handler *Handler, threshold time.Duration, nackStuck bool, ackTimeout time.Duration) *watchdog {
+	return &watchdog{handler: handler, threshold: threshold, nackStuck: nackStuck, ackTimeout: ackTimeout, inFlight: make(map[string]*trackedMessage)}
+}
+
+func (w *watchdog) track(msg pulsar.ConsumerMessage) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.inFlight[msgKey(msg)] = &trackedMessage{msg: msg, started: time.Now()}
+}
+
+// release stops tracking the message, it returns false if the watchdog already negatively
+// acknowledged it, in which case the flow outcome must be ignored
+func (w *watchdog) release(msg pulsar.ConsumerMessage) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	key := msgKey(msg)
+	tracked, ok := w.inFlight[key]
+	if !ok {
+		return true
+	}
+	delete(w.inFlight, key)
+	if tracked.flagged {
+		w.handler.logger.Warnf("Flow for message [%s] completed after %v", key, time.Since(tracked.started))
+	}
+	return !tracked.resolved
+}
+
+func (w *watchdog) run(done chan bool) {
+	interval := w.threshold / 2
+	if w.ackTimeout > 0 && (interval == 0 || w.ackTimeout/2 < interval) {
+		interval = w.ackTimeout / 2
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (w *watchdog) check() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for key, tracked := range w.inFlight {
+		elapsed := time.Since(tracked.started)
+		if w.ackTimeout > 0 && !tracked.resolved && elapsed >= w.ackTimeout {
+			tracked.resolved = true
+			ackTimeouts.WithLabelValues(w.handler.handler.Name()).Inc()
+			w.handler.nack(tracked.msg)
+			w.handler.logger.Warnf("Message [%s] not acknowledged within %v, negatively acknowledged it for redelivery", key, w.ackTimeout)
+			continue
+		}
+		if w.threshold <= 0 || tracked.flagged || elapsed < w.threshold {
+			continue
+		}
+		tracked.flagged = true
+		stuckMessages.WithLabelValues(w.handler.handler.Name()).Inc()
+		w.handler.logger.Warnf("Flow for event id [%s] has been running for %v, it is likely hung", key, elapsed)
+		if w.nackStuck {
+			tracked.resolved = true
+			w.handler.nack(tracked.msg)
+			w.handler.logger.Warnf("Negatively acknowledged stuck message [%s] for redelivery", key)
+		}
+	}
+}
+
+func msgKey(msg pulsar.Message) string {
+	return formatMsgID(msg.ID())
+}
@@ -0,0 +1,86 @@
+package subscriber
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	connection "github.com/jdattatr-tibco/messaging-contrib/pulsar/connection"
+)
+
+const (
+	WatermarkSourceEventTime    = "EventTime"
+	WatermarkSourcePayloadField = "PayloadField"
+)
+
+// watermark tracks the event-time progress of a handler. The watermark trails the highest event time
+// seen by the allowed lateness; messages with an event time before the watermark are considered late.
+type watermark struct {
+	lock         sync.Mutex
+	source       string
+	field        string
+	lateness     time.Duration
+	maxEventTime time.Time
+}
+
+func newWatermark(source, field string, lateness time.Duration) *watermark {
+	return &watermark{source: source, field: field, lateness: lateness}
+}
+
+// eventTime extracts the event time of a message, either from the message metadata or from a payload field. When a
+// clock skew tolerance is set, event times further ahead of the clock are invalid, they would advance the watermark
+// past the messages to come.
+func (w *watermark) eventTime(msg pulsar.Message, payload interface{}) (time.Time, bool) {
+	var t time.Time
+	if w.source == WatermarkSourceEventTime {
+		t = msg.EventTime()
+		if t.IsZero() {
+			return t, false
+		}
+	} else {
+		value, ok := connection.LookupPath(payload, w.field)
+		if !ok {
+			return time.Time{}, false
+		}
+		if t, ok = toTime(value); !ok {
+			return t, false
+		}
+	}
+	if connection.ClockSkewTolerance() > 0 && connection.InFuture(t) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// observe advances the watermark with the event time of a message and returns the current watermark
+// and whether the message is late
+func (w *watermark) observe(eventTime time.Time) (time.Time, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	late := !w.maxEventTime.IsZero() && eventTime.Before(w.maxEventTime.Add(-w.lateness))
+	if eventTime.After(w.maxEventTime) {
+		w.maxEventTime = eventTime
+	}
+	return w.maxEventTime.Add(-w.lateness), late
+}
+
+// toTime converts epoch milliseconds or RFC3339 strings to a time
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		return time.UnixMilli(int64(v)), true
+	case int64:
+		return time.UnixMilli(v), true
+	case int:
+		return time.UnixMilli(int64(v)), true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.UnixMilli(ms), true
+		}
+	}
+	return time.Time{}, false
+}
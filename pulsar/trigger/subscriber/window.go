@@ -0,0 +1,97 @@
+package subscriber
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// windows accumulates consumed messages by key and invokes the flow once with all messages of a window, which are
+// acknowledged together. A window closes when it holds the maximum number of messages or when its timer fires: the
+// timer is restarted by every message of a sliding window and runs from the first message otherwise.
+type windows struct {
+	handler     *Handler
+	kind        string
+	timeout     time.Duration
+	sliding     bool
+	maxMessages int
+	lock        sync.Mutex
+	open        map[string]*window
+	closed      bool
+}
+
+type window struct {
+	key   string
+	msgs  []pulsar.ConsumerMessage
+	outs  []interface{}
+	timer *time.Timer
+}
+
+func newWindows(handler *Handler, kind string, timeout time.Duration, sliding bool, maxMessages int) *windows {
+	return &windows{handler: handler, kind: kind, timeout: timeout, sliding: sliding, maxMessages: maxMessages, open: make(map[string]*window)}
+}
+
+// add adds the message to the open window of the key, opening one if there is none. The message is in flight until
+// its window closes. Messages added once all windows were closed are negatively acknowledged.
+func (w *windows) add(key string, msg pulsar.ConsumerMessage, out *Output) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		// the handler stops, the broker redelivers the message
+		w.handler.nack(msg)
+		return
+	}
+	w.handler.inFlight.Add(1)
+	win, ok := w.open[key]
+	if !ok {
+		win = &window{key: key}
+		w.open[key] = win
+		win.timer = time.AfterFunc(w.timeout, func() { w.expire(win) })
+	} else if w.sliding {
+		win.timer.Reset(w.timeout)
+	}
+	win.msgs = append(win.msgs, msg)
+	win.outs = append(win.outs, out.ToMap())
+	if w.maxMessages > 0 && len(win.msgs) >= w.maxMessages {
+		w.closeLocked(win)
+	}
+}
+
+func (w *windows) expire(win *window) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closeLocked(win)
+}
+
+// closeAll closes all open windows and refuses new ones, used when the handler stops
+func (w *windows) closeAll() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	for _, win := range w.open {
+		w.closeLocked(win)
+	}
+}
+
+func (w *windows) closeLocked(win *window) {
+	if current, ok := w.open[win.key]; !ok || current != win {
+		// already closed
+		return
+	}
+	win.timer.Stop()
+	delete(w.open, win.key)
+
+	go func() {
+		// the messages were in flight since they were added
+		defer w.handler.inFlight.Add(-len(win.msgs))
+		if w.sliding {
+			w.handler.logger.Debugf("%s for key [%s] closed with %d messages", w.kind, win.key, len(win.msgs))
+		} else {
+			w.handler.logger.Debugf("%s closed with %d messages", w.kind, len(win.msgs))
+		}
+		out := &Output{Key: win.key, Topic: win.msgs[0].Topic(), Messages: win.outs}
+		w.handler.invoke(context.Background(), out, win.msgs...)
+	}()
+}
@@ -0,0 +1,230 @@
+package subscriber
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/core/trigger"
+)
+
+func TestWindowsFlush(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		maxMessages int
+		messages    int
+		closeAll    bool
+		wantSizes   []int
+	}{
+		{"on size", time.Hour, 2, 4, false, []int{2, 2}},
+		{"on timeout", 10 * time.Millisecond, 0, 3, false, []int{3}},
+		{"on close", time.Hour, 0, 3, true, []int{3}},
+		{"remainder on close", time.Hour, 2, 3, true, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, flow, consumer := newWindowHandler()
+			w := newWindows(handler, "Batch", tt.timeout, false, tt.maxMessages)
+			for i := 0; i < tt.messages; i++ {
+				w.add("", newStubMessage(i), &Output{})
+			}
+			if tt.closeAll {
+				w.closeAll()
+			}
+			flow.await(t, len(tt.wantSizes))
+			if got := flow.sizes(); !equalSizes(got, tt.wantSizes) {
+				t.Errorf("flow invoked with %v messages, want %v", got, tt.wantSizes)
+			}
+			handler.inFlight.Wait()
+			want := 0
+			for _, size := range tt.wantSizes {
+				want += size
+			}
+			if acked, _ := consumer.counts(); acked != want {
+				t.Errorf("%d messages acknowledged, want %d", acked, want)
+			}
+		})
+	}
+}
+
+func TestWindowsInFlight(t *testing.T) {
+	handler, flow, _ := newWindowHandler()
+	w := newWindows(handler, "Batch", time.Hour, false, 0)
+	w.add("", newStubMessage(0), &Output{})
+	drained := make(chan struct{})
+	go func() {
+		handler.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("message of an open window not in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	w.closeAll()
+	flow.await(t, 1)
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message still in flight after its window was flushed")
+	}
+}
+
+func TestWindowsClosed(t *testing.T) {
+	handler, flow, consumer := newWindowHandler()
+	w := newWindows(handler, "Batch", time.Millisecond, false, 0)
+	w.closeAll()
+	w.add("", newStubMessage(0), &Output{})
+	time.Sleep(20 * time.Millisecond)
+	handler.inFlight.Wait()
+	if got := flow.sizes(); len(got) != 0 {
+		t.Errorf("flow invoked with %v messages after the windows were closed", got)
+	}
+	if _, nacked := consumer.counts(); nacked != 1 {
+		t.Errorf("%d messages negatively acknowledged, want 1", nacked)
+	}
+}
+
+func TestWindowsFlushWithoutConsumer(t *testing.T) {
+	handler, flow, _ := newWindowHandler()
+	w := newWindows(handler, "Batch", 10*time.Millisecond, false, 0)
+	w.add("", newStubMessage(0), &Output{})
+	// the consumer is closed at the shutdown deadline while the window is open
+	handler.consumerLock.Lock()
+	handler.consumer = nil
+	handler.consumerLock.Unlock()
+	flow.await(t, 1)
+	handler.inFlight.Wait()
+}
+
+func newWindowHandler() (*Handler, *stubFlow, *stubConsumer) {
+	flow := &stubFlow{invoked: make(chan int, 10)}
+	consumer := &stubConsumer{}
+	handler := &Handler{handler: flow, logger: log.RootLogger(), consumer: consumer}
+	return handler, flow, consumer
+}
+
+// equalSizes compares the sizes of the windows in ascending order, windows are flushed concurrently
+func equalSizes(got, want []int) bool {
+	sort.Ints(got)
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// stubFlow records the number of messages of each invocation
+type stubFlow struct {
+	trigger.Handler
+	lock        sync.Mutex
+	invocations []int
+	invoked     chan int
+}
+
+func (f *stubFlow) Name() string {
+	return "test"
+}
+
+func (f *stubFlow) Handle(_ context.Context, triggerData interface{}) (map[string]interface{}, error) {
+	size := len(triggerData.(*Output).Messages)
+	f.lock.Lock()
+	f.invocations = append(f.invocations, size)
+	f.lock.Unlock()
+	f.invoked <- size
+	return nil, nil
+}
+
+func (f *stubFlow) await(t *testing.T, invocations int) {
+	t.Helper()
+	for i := 0; i < invocations; i++ {
+		select {
+		case <-f.invoked:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("flow invoked %d times, want %d", i, invocations)
+		}
+	}
+}
+
+func (f *stubFlow) sizes() []int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]int(nil), f.invocations...)
+}
+
+// stubConsumer counts the acknowledgements
+type stubConsumer struct {
+	pulsar.Consumer
+	lock          sync.Mutex
+	acked, nacked int
+}
+
+func (c *stubConsumer) Ack(pulsar.Message) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.acked++
+	return nil
+}
+
+func (c *stubConsumer) Nack(pulsar.Message) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.nacked++
+}
+
+func (c *stubConsumer) counts() (acked, nacked int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.acked, c.nacked
+}
+
+type stubMessage struct {
+	pulsar.Message
+	id stubMessageID
+}
+
+func newStubMessage(entry int) pulsar.ConsumerMessage {
+	return pulsar.ConsumerMessage{Message: &stubMessage{id: stubMessageID(entry)}}
+}
+
+func (m *stubMessage) ID() pulsar.MessageID {
+	return m.id
+}
+
+func (m *stubMessage) Topic() string {
+	return "persistent://public/default/test"
+}
+
+type stubMessageID int64
+
+func (id stubMessageID) Serialize() []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(id))
+	return data
+}
+
+func (id stubMessageID) LedgerID() int64 {
+	return 0
+}
+
+func (id stubMessageID) EntryID() int64 {
+	return int64(id)
+}
+
+func (id stubMessageID) BatchIdx() int32 {
+	return -1
+}
+
+func (id stubMessageID) PartitionIdx() int32 {
+	return -1
+}